@@ -0,0 +1,46 @@
+package gcr
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+)
+
+// TargetMismatch is a signed tag whose manifest in the registry differs from
+// the signed one. Actual is the digest the tag points to in the registry, or
+// the zero hash if Missing, i.e. the registry does not know the tag.
+type TargetMismatch struct {
+	Tag     string
+	Signed  v1.Hash
+	Actual  v1.Hash
+	Missing bool
+}
+
+// auditTags compares the signed digest of each of tags with the digest head
+// returns for the tag, and returns the mismatches sorted by tag.
+func auditTags(repo name.Repository, tags map[string]v1.Hash, head func(name.Reference) (v1.Hash, error)) ([]TargetMismatch, error) {
+	mismatches := []TargetMismatch{}
+	for tag, signed := range tags {
+		ref, err := name.NewTag(repo.String()+":"+tag, name.StrictValidation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse tag %s", tag)
+		}
+		actual, err := head(ref)
+		if err != nil {
+			if terr, ok := err.(*transport.Error); ok && terr.StatusCode == http.StatusNotFound {
+				mismatches = append(mismatches, TargetMismatch{Tag: tag, Signed: signed, Missing: true})
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to get registry digest of %s", ref)
+		}
+		if actual != signed {
+			mismatches = append(mismatches, TargetMismatch{Tag: tag, Signed: signed, Actual: actual})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Tag < mismatches[j].Tag })
+	return mismatches, nil
+}
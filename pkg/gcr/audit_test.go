@@ -0,0 +1,52 @@
+package gcr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestAuditTags(t *testing.T) {
+	hash := func(s string) v1.Hash {
+		sum := sha256.Sum256([]byte(s))
+		return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+	}
+	repo, err := name.NewRepository("gcr.io/project/image")
+	assert.NilError(t, err)
+	registry := map[string]v1.Hash{"latest": hash("latest"), "stable": hash("moved")}
+	head := func(ref name.Reference) (v1.Hash, error) {
+		digest, ok := registry[ref.Identifier()]
+		if !ok {
+			return v1.Hash{}, &transport.Error{StatusCode: http.StatusNotFound}
+		}
+		return digest, nil
+	}
+
+	mismatches, err := auditTags(repo, map[string]v1.Hash{
+		"latest": hash("latest"),
+		"stable": hash("stable"),
+		"gone":   hash("gone"),
+	}, head)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(mismatches, []TargetMismatch{
+		{Tag: "gone", Signed: hash("gone"), Missing: true},
+		{Tag: "stable", Signed: hash("stable"), Actual: hash("moved")},
+	}))
+
+	mismatches, err = auditTags(repo, map[string]v1.Hash{"latest": hash("latest")}, head)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(mismatches, 0))
+
+	_, err = auditTags(repo, map[string]v1.Hash{"latest": hash("latest")}, func(name.Reference) (v1.Hash, error) {
+		return v1.Hash{}, errors.New("connection refused")
+	})
+	assert.Check(t, is.ErrorContains(err, "connection refused"))
+}
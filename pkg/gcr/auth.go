@@ -0,0 +1,50 @@
+package gcr
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+)
+
+// refreshingAuth is a registry authenticator whose credentials can be
+// replaced with fresh ones when the registry rejects them, e.g. because a GCR
+// access token expired during a long push.
+type refreshingAuth struct {
+	mu      sync.Mutex
+	auth    authn.Authenticator
+	refresh func() (authn.Authenticator, error)
+}
+
+// Authorization returns the current credentials.
+func (a *refreshingAuth) Authorization() (*authn.AuthConfig, error) {
+	a.mu.Lock()
+	auth := a.auth
+	a.mu.Unlock()
+	return auth.Authorization()
+}
+
+// Refresh replaces the current credentials with those of the refresh
+// function.
+func (a *refreshingAuth) Refresh() error {
+	auth, err := a.refresh()
+	if err != nil {
+		return errors.Wrap(err, "failed to refresh registry credentials")
+	}
+	if auth == nil {
+		return errors.New("failed to refresh registry credentials: no authenticator returned")
+	}
+	a.mu.Lock()
+	a.auth = auth
+	a.mu.Unlock()
+	return nil
+}
+
+// isUnauthorized reports whether err is a registry response rejecting the
+// credentials of the request.
+func isUnauthorized(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusUnauthorized
+}
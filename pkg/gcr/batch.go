@@ -0,0 +1,108 @@
+package gcr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+)
+
+// Verify parses refStr, a tag or digest reference, and returns its trusted
+// target, verified with the trust config of configDir like VerifyTag or
+// VerifyDigest, e.g. to tell whether an image is trusted in a single call.
+// ErrNoTrustData is returned when the tag is not signed, and
+// ErrDigestNotSigned or, without any trust data, ErrUninitialized when the
+// digest is not.
+func Verify(ctx context.Context, refStr string, notaryAuth authn.Authenticator, configDir string) (*client.Target, error) {
+	ref, err := name.ParseReference(refStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse reference %s", refStr)
+	}
+	repo, err := NewTrustedGcrRepository(configDir, ref, nil, notaryAuth)
+	if err != nil {
+		return nil, err
+	}
+	return repo.verifyReference(ctx, ref)
+}
+
+// VerifyAll verifies each of refs with the trust config of configDir and
+// returns the trusted targets and the errors, both keyed by the reference
+// string. Tag references are verified like VerifyTag and digest references
+// like VerifyDigest. The trust config is read once and the references of one
+// repository share a notary repository, so its trust data is fetched once,
+// while different repositories are verified concurrently.
+func VerifyAll(ctx context.Context, refs []name.Reference, notaryAuth authn.Authenticator, configDir string) (map[string]*client.Target, map[string]error) {
+	targets := make(map[string]*client.Target, len(refs))
+	errs := make(map[string]error)
+	config, err := trust.ParseConfig(configDir)
+	if err != nil {
+		for _, ref := range refs {
+			errs[ref.String()] = err
+		}
+		return targets, errs
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, group := range groupByRepository(refs) {
+		wg.Add(1)
+		go func(group []name.Reference) {
+			defer wg.Done()
+			cfg := *config
+			repo, err := NewTrustedGcrRepositoryFromConfig(group[0], &cfg, nil, notaryAuth)
+			for _, ref := range group {
+				var target *client.Target
+				verifyErr := err
+				if verifyErr == nil {
+					target, verifyErr = repo.verifyReference(ctx, ref)
+				}
+				mu.Lock()
+				if verifyErr != nil {
+					errs[ref.String()] = verifyErr
+				} else {
+					targets[ref.String()] = target
+				}
+				mu.Unlock()
+			}
+		}(group)
+	}
+	wg.Wait()
+	return targets, errs
+}
+
+// groupByRepository groups refs by repository, keeping the order of refs
+// within and across the groups.
+func groupByRepository(refs []name.Reference) [][]name.Reference {
+	var groups [][]name.Reference
+	index := make(map[string]int)
+	for _, ref := range refs {
+		repo := ref.Context().String()
+		i, ok := index[repo]
+		if !ok {
+			i = len(groups)
+			index[repo] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], ref)
+	}
+	return groups
+}
+
+// verifyReference verifies ref, a reference to the repository of repo, by
+// its digest or tag.
+func (repo *TrustedGcrRepository) verifyReference(ctx context.Context, ref name.Reference) (*client.Target, error) {
+	defer repo.lock()()
+	if d, ok := ref.(name.Digest); ok {
+		digest, err := v1.NewHash(d.DigestStr())
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse digest of %s", ref)
+		}
+		return repo.verifyDigest(ctx, digest)
+	}
+	return repo.verifyTag(ctx, ref.Identifier())
+}
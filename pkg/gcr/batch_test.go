@@ -0,0 +1,102 @@
+package gcr
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestGroupByRepository(t *testing.T) {
+	var refs []name.Reference
+	for _, s := range []string{
+		"gcr.io/project/a:latest",
+		"gcr.io/project/b:latest",
+		"gcr.io/project/a@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"gcr.io/project/a:stable",
+	} {
+		ref, err := name.ParseReference(s)
+		assert.NilError(t, err)
+		refs = append(refs, ref)
+	}
+
+	var groups [][]string
+	for _, group := range groupByRepository(refs) {
+		var names []string
+		for _, ref := range group {
+			names = append(names, ref.String())
+		}
+		groups = append(groups, names)
+	}
+	assert.Check(t, is.DeepEqual(groups, [][]string{
+		{refs[0].String(), refs[2].String(), refs[3].String()},
+		{refs[1].String()},
+	}))
+}
+
+func TestVerify(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer s.Close()
+	configDir, err := ioutil.TempDir("", "notary-gcr")
+	assert.NilError(t, err)
+	defer os.RemoveAll(configDir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(configDir, "gcr-config.json"), []byte(`{"server_url": "`+s.URL+`"}`), 0600))
+
+	_, err = Verify(context.Background(), "gcr.io/project/a:latest", authn.Anonymous, configDir)
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+	_, err = Verify(context.Background(), "gcr.io/project/a@sha256:"+"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", authn.Anonymous, configDir)
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+
+	_, err = Verify(context.Background(), "gcr.io/project/A", authn.Anonymous, configDir)
+	assert.Check(t, is.ErrorContains(err, "couldn't parse reference"))
+}
+
+func TestVerifyAll(t *testing.T) {
+	var pings int32
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			atomic.AddInt32(&pings, 1)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer s.Close()
+	configDir, err := ioutil.TempDir("", "notary-gcr")
+	assert.NilError(t, err)
+	defer os.RemoveAll(configDir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(configDir, "gcr-config.json"), []byte(`{"server_url": "`+s.URL+`"}`), 0600))
+
+	var refs []name.Reference
+	for _, s := range []string{"gcr.io/project/a:latest", "gcr.io/project/a:stable", "gcr.io/project/b:latest"} {
+		ref, err := name.ParseReference(s)
+		assert.NilError(t, err)
+		refs = append(refs, ref)
+	}
+
+	targets, errs := VerifyAll(context.Background(), refs, authn.Anonymous, configDir)
+	assert.Check(t, is.Len(targets, 0))
+	assert.Check(t, is.Len(errs, 3))
+	for _, ref := range refs {
+		assert.Check(t, errors.Is(errs[ref.String()], ErrNoTrustData), "%s: unexpected error: %v", ref, errs[ref.String()])
+	}
+	// the notary repository is set up once per repository
+	assert.Check(t, is.Equal(atomic.LoadInt32(&pings), int32(2)))
+
+	_, errs = VerifyAll(context.Background(), refs[:1], authn.Anonymous, filepath.Join(configDir, "missing"))
+	assert.Check(t, errs[refs[0].String()] != nil)
+}
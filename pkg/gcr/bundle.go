@@ -0,0 +1,169 @@
+package gcr
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// The trust bundles of ExportTrustBundle are tar archives holding the TUF
+// metadata of a repository as metadata/<role>.json and, written by
+// ExportTrustBundleWithKeys, its signing keys as keys/<key ID>.key, in the
+// notary PEM format.
+const (
+	bundleMetadataDir = "metadata/"
+	bundleKeysDir     = "keys/"
+)
+
+// writeTrustBundle writes meta and keys to w as a trust bundle. The entries
+// are sorted by name and carry no timestamps, so the same trust state always
+// gives the same bundle.
+func writeTrustBundle(w io.Writer, meta map[data.RoleName][]byte, keys map[string][]byte) error {
+	files := make(map[string][]byte, len(meta)+len(keys))
+	for role, raw := range meta {
+		files[bundleMetadataDir+role.String()+".json"] = raw
+	}
+	for keyID, pemBytes := range keys {
+		files[bundleKeysDir+keyID+".key"] = pemBytes
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(files[name])), Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readTrustBundle reads the metadata, keyed by role name, and the keys, keyed
+// by key ID, of the trust bundle of r.
+func readTrustBundle(r io.Reader) (meta map[data.RoleName][]byte, keys map[string][]byte, err error) {
+	meta = make(map[data.RoleName][]byte)
+	keys = make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return meta, keys, nil
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid trust bundle")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid trust bundle")
+		}
+		switch {
+		case strings.HasPrefix(name, bundleMetadataDir) && path.Ext(name) == ".json":
+			meta[data.RoleName(strings.TrimSuffix(strings.TrimPrefix(name, bundleMetadataDir), ".json"))] = content
+		case strings.HasPrefix(name, bundleKeysDir) && path.Ext(name) == ".key":
+			keys[strings.TrimSuffix(strings.TrimPrefix(name, bundleKeysDir), ".key")] = content
+		default:
+			return nil, nil, errors.Errorf("unexpected entry %s in trust bundle", hdr.Name)
+		}
+	}
+}
+
+// exportGUNKeys returns the private keys of cs that belong to gun, and those
+// of the root keys of root, as PEM blocks encrypted with passphrase, keyed by
+// key ID.
+func exportGUNKeys(cs signed.CryptoService, gun data.GUN, root *data.SignedRoot, passphrase string) (map[string][]byte, error) {
+	infos, ok := cs.(keyInfoService)
+	if !ok {
+		return nil, errors.New("the key store does not record the repository of its keys")
+	}
+	keys := make(map[string][]byte)
+	export := func(keyID string, role data.RoleName, keyGUN data.GUN) error {
+		key, _, err := cs.GetPrivateKey(keyID)
+		if err != nil {
+			return errors.Wrapf(err, "could not read %s key %s", role, keyID)
+		}
+		pemBytes, err := utils.ConvertPrivateKeyToPKCS8(key, role, keyGUN, passphrase)
+		if err != nil {
+			return errors.Wrapf(err, "could not encrypt %s key %s", role, keyID)
+		}
+		keys[keyID] = pemBytes
+		return nil
+	}
+	for keyID, role := range cs.ListAllKeys() {
+		if role == data.CanonicalRootRole {
+			continue
+		}
+		if info, err := infos.GetKeyInfo(keyID); err != nil || info.Gun != gun {
+			continue
+		}
+		if err := export(keyID, role, gun); err != nil {
+			return nil, err
+		}
+	}
+	if root != nil {
+		// root keys are published as certificates, but held by the ID of
+		// their public key
+		for _, keyID := range root.Signed.Roles[data.CanonicalRootRole].KeyIDs {
+			if key, ok := root.Signed.Keys[keyID]; ok {
+				if canonicalID, err := utils.CanonicalKeyID(key); err == nil {
+					keyID = canonicalID
+				}
+			}
+			if _, _, err := cs.GetPrivateKey(keyID); err != nil {
+				continue
+			}
+			if err := export(keyID, data.CanonicalRootRole, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keys, nil
+}
+
+// bundleKey is a private key read from a trust bundle, with the role and GUN
+// it was created for.
+type bundleKey struct {
+	role data.RoleName
+	gun  data.GUN
+	key  data.PrivateKey
+}
+
+// decryptKeys decrypts the PEM encoded keys with passphrase, in key ID order.
+func decryptKeys(keys map[string][]byte, passphrase string) ([]bundleKey, error) {
+	keyIDs := make([]string, 0, len(keys))
+	for keyID := range keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	decrypted := make([]bundleKey, 0, len(keys))
+	for _, keyID := range keyIDs {
+		role, gun, err := utils.ExtractPrivateKeyAttributes(keys[keyID])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", keyID)
+		}
+		key, err := utils.ParsePEMPrivateKey(keys[keyID], passphrase)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decrypt key %s", keyID)
+		}
+		decrypted = append(decrypted, bundleKey{role: role, gun: gun, key: key})
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,107 @@
+package gcr
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/utils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestTrustBundle(t *testing.T) {
+	meta := map[data.RoleName][]byte{
+		data.CanonicalRootRole:    []byte(`{"root":1}`),
+		data.CanonicalTargetsRole: []byte(`{"targets":1}`),
+		"targets/releases":        []byte(`{"releases":1}`),
+	}
+	keys := map[string][]byte{"0123": []byte("PEM")}
+
+	var first, second bytes.Buffer
+	assert.NilError(t, writeTrustBundle(&first, meta, keys))
+	assert.NilError(t, writeTrustBundle(&second, meta, keys))
+	assert.Check(t, is.DeepEqual(first.Bytes(), second.Bytes()))
+
+	readMeta, readKeys, err := readTrustBundle(&first)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(readMeta, meta))
+	assert.Check(t, is.DeepEqual(readKeys, keys))
+
+	var unexpected bytes.Buffer
+	tw := tar.NewWriter(&unexpected)
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "config.json", Mode: 0600, Size: 2}))
+	_, err = tw.Write([]byte("{}"))
+	assert.NilError(t, err)
+	assert.NilError(t, tw.Close())
+	_, _, err = readTrustBundle(&unexpected)
+	assert.Check(t, is.ErrorContains(err, "unexpected entry config.json"))
+}
+
+func TestExportGUNKeys(t *testing.T) {
+	gun := data.GUN("gcr.io/project/image")
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	rootPub, err := cs.Create(data.CanonicalRootRole, "", data.ECDSAKey)
+	assert.NilError(t, err)
+	_, err = cs.Create(data.CanonicalRootRole, "", data.ECDSAKey)
+	assert.NilError(t, err)
+	targetsKey, err := cs.Create(data.CanonicalTargetsRole, gun, data.ECDSAKey)
+	assert.NilError(t, err)
+	_, err = cs.Create(data.CanonicalTargetsRole, "gcr.io/project/other", data.ECDSAKey)
+	assert.NilError(t, err)
+
+	priv, _, err := cs.GetPrivateKey(rootPub.ID())
+	assert.NilError(t, err)
+	cert, err := cryptoservice.GenerateCertificate(priv, gun, time.Now(), time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+	rootCert := utils.CertToKey(cert)
+	roles := map[data.RoleName]*data.RootRole{}
+	for _, role := range data.BaseRoles {
+		roles[role] = &data.RootRole{KeyIDs: []string{rootCert.ID()}, Threshold: 1}
+	}
+	root, err := data.NewRoot(data.Keys{rootCert.ID(): rootCert}, roles, false)
+	assert.NilError(t, err)
+
+	// only the keys of gun and the root key of its root metadata are exported
+	keys, err := exportGUNKeys(cs, gun, root, "bundle-passphrase")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(keys, 2))
+
+	_, err = decryptKeys(keys, "wrong")
+	assert.Check(t, is.ErrorContains(err, "could not decrypt key"))
+	decrypted, err := decryptKeys(keys, "bundle-passphrase")
+	assert.NilError(t, err)
+	got := map[string]data.RoleName{}
+	for _, k := range decrypted {
+		got[k.key.ID()] = k.role
+		if k.role != data.CanonicalRootRole {
+			assert.Check(t, is.Equal(k.gun, gun))
+		}
+	}
+	assert.Check(t, is.DeepEqual(got, map[string]data.RoleName{
+		rootPub.ID():    data.CanonicalRootRole,
+		targetsKey.ID(): data.CanonicalTargetsRole,
+	}))
+}
+
+func TestTrustBundleKeysNeedPassphrase(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+
+	var bundle bytes.Buffer
+	err := repo.ExportTrustBundleWithKeys(&bundle, "")
+	assert.Check(t, is.ErrorContains(err, "a passphrase is required"))
+	assert.Check(t, is.Equal(bundle.Len(), 0))
+
+	meta := map[data.RoleName][]byte{data.CanonicalRootRole: []byte(`{"root":1}`)}
+	assert.NilError(t, writeTrustBundle(&bundle, meta, map[string][]byte{"0123": []byte("PEM")}))
+	err = repo.ImportTrustBundle(bytes.NewReader(bundle.Bytes()))
+	assert.Check(t, is.ErrorContains(err, "use ImportTrustBundleWithKeys"))
+	err = repo.ImportTrustBundleWithKeys(bytes.NewReader(bundle.Bytes()), "")
+	assert.Check(t, is.ErrorContains(err, "a passphrase is required"))
+}
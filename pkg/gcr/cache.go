@@ -0,0 +1,200 @@
+package gcr
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+)
+
+// verifyCacheKey identifies a verification: of tag, or of digest when tag is
+// empty, in the notary repository gun of the notary server server, by a
+// repository whose verification policy has the fingerprint policy.
+type verifyCacheKey struct {
+	server, gun, tag, digest, policy string
+}
+
+type verifyCacheEntry struct {
+	key     verifyCacheKey
+	target  client.TargetWithRole
+	expires time.Time
+}
+
+// verifyCache is a least recently used cache of successful verifications,
+// safe for concurrent use.
+type verifyCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[verifyCacheKey]*list.Element
+	// lru holds the entries, most recently used first
+	lru *list.List
+}
+
+func newVerifyCache(size int, ttl time.Duration) *verifyCache {
+	return &verifyCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[verifyCacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the target cached for key, unless it has expired by now.
+func (c *verifyCache) get(key verifyCacheKey, now time.Time) (*client.TargetWithRole, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*verifyCacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	target := entry.target
+	return &target, true
+}
+
+// add caches target for key until the TTL of the cache elapses, or until
+// metadataExpires if that comes first, evicting the least recently used
+// entry when the cache is full.
+func (c *verifyCache) add(key verifyCacheKey, target *client.TargetWithRole, now, metadataExpires time.Time) {
+	expires := now.Add(c.ttl)
+	if metadataExpires.Before(expires) {
+		expires = metadataExpires
+	}
+	if !now.Before(expires) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &verifyCacheEntry{key: key, target: *target, expires: expires}
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&verifyCacheEntry{key: key, target: *target, expires: expires})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*verifyCacheEntry).key)
+	}
+}
+
+// remove drops every entry of the notary repository gun of server.
+func (c *verifyCache) remove(server, gun string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if key.server == server && key.gun == gun {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// verifyCacheKey returns the key of the verification of tag, or digest when
+// tag is empty, in the notary repository of repo.
+func (repo *TrustedGcrRepository) verifyCacheKey(tag, digest string) (verifyCacheKey, error) {
+	registry := repo.ref.Context().Registry
+	server, err := trust.Server(repo.config.ServerUrl, &registry)
+	if err != nil {
+		return verifyCacheKey{}, err
+	}
+	gun, err := trust.GUN(repo.ref, &registry, repo.config)
+	if err != nil {
+		return verifyCacheKey{}, err
+	}
+	return verifyCacheKey{server: server, gun: gun.String(), tag: tag, digest: digest, policy: repo.verifyPolicy()}, nil
+}
+
+// verifyPolicy returns a fingerprint of the checks the verifications of repo
+// run on top of notary's: the pinned or accepted root keys, the root pin
+// store, the required signer keys and the trust anchor. A repository only
+// answers from cached verifications of repositories with the same checks, so
+// sharing a verify cache with a laxer repository does not skip them.
+func (repo *TrustedGcrRepository) verifyPolicy() string {
+	sorted := func(keyIDs []string) []string {
+		keyIDs = append([]string(nil), keyIDs...)
+		sort.Strings(keyIDs)
+		return keyIDs
+	}
+	policy, err := json.Marshal(struct {
+		PinnedRoots     []string
+		RootPinStore    string
+		RequiredSigners []string
+		TrustAnchor     []byte
+	}{sorted(repo.pinnedRoots), repo.rootPinStore, sorted(repo.requiredSigners), repo.config.TrustAnchor})
+	if err != nil {
+		// cannot happen, but never share verifications if it does
+		return err.Error()
+	}
+	sum := sha256.Sum256(policy)
+	return hex.EncodeToString(sum[:])
+}
+
+// forgetVerifications drops the verifications of the notary repository of
+// repo from its verify cache, if any, once its trust data changed.
+func (repo *TrustedGcrRepository) forgetVerifications() {
+	if repo.verifyCache == nil {
+		return
+	}
+	key, err := repo.verifyCacheKey("", "")
+	if err != nil {
+		return
+	}
+	repo.verifyCache.remove(key.server, key.gun)
+}
+
+// cachedVerification returns the target the verify cache of repo holds for
+// tag, or digest when tag is empty, if any.
+func (repo *TrustedGcrRepository) cachedVerification(tag, digest string) (*client.TargetWithRole, bool) {
+	if repo.verifyCache == nil {
+		return nil, false
+	}
+	key, err := repo.verifyCacheKey(tag, digest)
+	if err != nil {
+		return nil, false
+	}
+	return repo.verifyCache.get(key, time.Now())
+}
+
+// cacheVerification records in the verify cache of repo, if any, that target
+// was verified for tag, or digest when tag is empty, until the earliest
+// expiry of the cached metadata it was verified with.
+func (repo *TrustedGcrRepository) cacheVerification(tag, digest string, target *client.TargetWithRole) {
+	if repo.verifyCache == nil {
+		return
+	}
+	key, err := repo.verifyCacheKey(tag, digest)
+	if err != nil {
+		return
+	}
+	registry := repo.ref.Context().Registry
+	expiries, err := trust.GetMetadataExpiries(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Debugf("not caching verification: failed to read metadata expiries: %s", err)
+		return
+	}
+	var earliest time.Time
+	for _, expires := range expiries {
+		if earliest.IsZero() || expires.Before(earliest) {
+			earliest = expires
+		}
+	}
+	if earliest.IsZero() {
+		return
+	}
+	repo.verifyCache.add(key, target, time.Now(), earliest)
+}
@@ -0,0 +1,162 @@
+package gcr
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theupdateframework/notary/client"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestVerifyCache(t *testing.T) {
+	now := time.Now()
+	cache := newVerifyCache(2, time.Minute)
+	latest := verifyCacheKey{server: "https://notary.example.com", gun: "gcr.io/project/image", tag: "latest"}
+	stable := verifyCacheKey{server: "https://notary.example.com", gun: "gcr.io/project/image", tag: "stable"}
+	digest := verifyCacheKey{server: "https://notary.example.com", gun: "gcr.io/project/image", digest: "sha256:0123"}
+	target := &client.TargetWithRole{Target: client.Target{Name: "latest", Length: 1}}
+
+	cache.add(latest, target, now, now.Add(time.Hour))
+	cached, ok := cache.get(latest, now.Add(time.Second))
+	assert.Assert(t, ok)
+	assert.Check(t, is.DeepEqual(cached, target))
+	_, ok = cache.get(latest, now.Add(time.Minute))
+	assert.Check(t, !ok, "expected the TTL to have elapsed")
+
+	// entries do not outlive the metadata they were verified with
+	cache.add(latest, target, now, now.Add(time.Second))
+	_, ok = cache.get(latest, now.Add(2*time.Second))
+	assert.Check(t, !ok, "expected the metadata to have expired")
+	cache.add(latest, target, now, now.Add(-time.Second))
+	_, ok = cache.get(latest, now)
+	assert.Check(t, !ok, "expected expired metadata not to be cached")
+
+	// the least recently used entry is evicted
+	cache.add(latest, target, now, now.Add(time.Hour))
+	cache.add(stable, target, now, now.Add(time.Hour))
+	_, ok = cache.get(latest, now)
+	assert.Check(t, ok)
+	cache.add(digest, target, now, now.Add(time.Hour))
+	_, ok = cache.get(stable, now)
+	assert.Check(t, !ok, "expected stable to be evicted")
+	_, ok = cache.get(latest, now)
+	assert.Check(t, ok)
+	_, ok = cache.get(digest, now)
+	assert.Check(t, ok)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.add(latest, target, now, now.Add(time.Hour))
+				cache.get(digest, now)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Check(t, is.Equal(cache.lru.Len(), 2))
+
+	// only the entries of the given GUN of the given server are removed
+	mirrored := verifyCacheKey{server: "https://mirror.example.com", gun: "gcr.io/project/image", tag: "latest"}
+	cache.add(mirrored, target, now, now.Add(time.Hour))
+	cache.remove("https://notary.example.com", "gcr.io/project/image")
+	_, ok = cache.get(digest, now)
+	assert.Check(t, !ok, "expected digest to be removed")
+	_, ok = cache.get(mirrored, now)
+	assert.Check(t, ok)
+}
+
+func TestWithVerifyCache(t *testing.T) {
+	opt := WithVerifyCache(10, time.Minute)
+	a, err := makeOptions(opt)
+	assert.NilError(t, err)
+	b, err := makeOptions(opt)
+	assert.NilError(t, err)
+	assert.Check(t, a.verifyCache != nil)
+	assert.Check(t, a.verifyCache == b.verifyCache, "expected the cache to be shared")
+
+	_, err = makeOptions(WithVerifyCache(0, time.Minute))
+	assert.Check(t, is.ErrorContains(err, "must be positive"))
+	_, err = makeOptions(WithVerifyCache(10, 0))
+	assert.Check(t, is.ErrorContains(err, "must be positive"))
+}
+
+func TestVerifyCacheSkipsFailures(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t, WithVerifyCache(10, time.Minute))
+	defer cleanup()
+
+	_, err := repo.VerifyTag("latest")
+	assert.Check(t, err != nil)
+	assert.Check(t, is.Equal(repo.verifyCache.lru.Len(), 0))
+}
+
+func TestForgetVerifications(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t, WithVerifyCache(10, time.Minute))
+	defer cleanup()
+	mirror, err := repo.ForTrustServer("https://mirror.example.com")
+	assert.NilError(t, err)
+	target := &client.TargetWithRole{Target: client.Target{Name: "latest", Length: 1}}
+	key, err := repo.verifyCacheKey("latest", "")
+	assert.NilError(t, err)
+	repo.verifyCache.add(key, target, time.Now(), time.Now().Add(time.Hour))
+
+	_, ok := repo.cachedVerification("latest", "")
+	assert.Check(t, ok)
+	// the verifications of another trust server are cached apart
+	_, ok = mirror.cachedVerification("latest", "")
+	assert.Check(t, !ok, "expected the mirror not to share the verification")
+
+	mirror.forgetVerifications()
+	_, ok = repo.cachedVerification("latest", "")
+	assert.Check(t, ok)
+	repo.forgetVerifications()
+	_, ok = repo.cachedVerification("latest", "")
+	assert.Check(t, !ok, "expected the verification to be forgotten")
+}
+
+func TestVerifyCacheKeepsPolicies(t *testing.T) {
+	lax, _, cleanup := newUninitializedRepository(t, WithVerifyCache(10, time.Minute))
+	defer cleanup()
+	target := &client.TargetWithRole{Target: client.Target{Name: "latest", Length: 1}}
+	key, err := lax.verifyCacheKey("latest", "")
+	assert.NilError(t, err)
+	lax.verifyCache.add(key, target, time.Now(), time.Now().Add(time.Hour))
+	verified, err := lax.VerifyTag("latest")
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(verified, &target.Target))
+
+	anchor := []byte(`{"signed":{}}`)
+	for name, strict := range map[string]func(repo *TrustedGcrRepository){
+		"pinned roots":     func(repo *TrustedGcrRepository) { repo.pinnedRoots = []string{"root-key"} },
+		"root pin store":   func(repo *TrustedGcrRepository) { repo.rootPinStore = "pins.json" },
+		"required signers": func(repo *TrustedGcrRepository) { repo.requiredSigners = []string{"release-key"} },
+		"trust anchor": func(repo *TrustedGcrRepository) {
+			config := *repo.config
+			config.TrustAnchor = anchor
+			repo.config = &config
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo := lax.forReference(lax.ref, lax.registryAuth, lax.notaryAuth)
+			strict(repo)
+			assert.Check(t, repo.verifyCache == lax.verifyCache)
+			// the repository runs its own checks rather than answering from
+			// the verification of the laxer one
+			_, ok := repo.cachedVerification("latest", "")
+			assert.Check(t, !ok, "expected the verification not to be shared")
+			_, err := repo.VerifyTag("latest")
+			assert.Check(t, err != nil)
+		})
+	}
+
+	// the order of key IDs does not matter
+	a := lax.forReference(lax.ref, lax.registryAuth, lax.notaryAuth)
+	a.pinnedRoots = []string{"old-root", "new-root"}
+	b := lax.forReference(lax.ref, lax.registryAuth, lax.notaryAuth)
+	b.pinnedRoots = []string{"new-root", "old-root"}
+	assert.Check(t, is.Equal(a.verifyPolicy(), b.verifyPolicy()))
+}
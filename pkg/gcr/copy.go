@@ -0,0 +1,190 @@
+package gcr
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// forReference returns a repository for ref that shares the configuration and
+// options of repo but has notary state of its own.
+func (repo *TrustedGcrRepository) forReference(ref name.Reference, registryAuth authn.Authenticator, notaryAuth authn.Authenticator) *TrustedGcrRepository {
+	other := *repo
+	other.ref = ref
+	other.registryAuth = registryAuth
+	other.notaryAuth = notaryAuth
+	other.mu = new(sync.Mutex)
+	other.notary = nil
+	other.notaryCtx = nil
+	other.useReferenceLogger()
+	return &other
+}
+
+// ForTrustServer returns a repository for the same reference and options as
+// repo whose notary calls all go to the notary server at serverURL, e.g. a
+// mirror of the trust server, so that a single verification can be pointed at
+// it. The trust data of the mirror is checked against the metadata cached in
+// the trust directory, so a mirror lagging behind what was already seen fails
+// verification rather than rolling it back.
+func (repo *TrustedGcrRepository) ForTrustServer(serverURL string) (*TrustedGcrRepository, error) {
+	if err := checkTrustServer(serverURL); err != nil {
+		return nil, err
+	}
+	other := repo.forReference(repo.ref, repo.registryAuth, repo.notaryAuth)
+	config := *repo.config
+	config.ServerUrl = serverURL
+	other.config = &config
+	// the GUN depends on the trust server
+	other.useReferenceLogger()
+	return other, nil
+}
+
+// copyTrustedImage copies the manifest signed for the tag of repo, pinned by
+// its digest, to the repository of dst and signs it there under the tag of dst.
+func (repo *TrustedGcrRepository) copyTrustedImage(ctx context.Context, dst *TrustedGcrRepository) error {
+	tag, err := name.NewTag(repo.ref.String(), name.StrictValidation)
+	if err != nil {
+		return errors.Wrap(err, "couldn't parse tag from repository name")
+	}
+	target, err := repo.verifyTag(ctx, tag.Identifier())
+	if err != nil {
+		return err
+	}
+	digest, err := targetDigest(target)
+	if err != nil {
+		return err
+	}
+
+	// pulling by digest makes the registry content match the signed target
+	src := repo.ref.Context().Digest(digest.String())
+	desc, err := remote.Get(src, repo.remoteOptions(ctx)...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %s", src)
+	}
+	if desc.Size != target.Length {
+		return errors.Errorf("size of %s is %d, signed as %d", src, desc.Size, target.Length)
+	}
+	err = dst.upload(ctx, dst.ref, func(options ...remote.Option) error {
+		return pushDescriptor(repo.logger, dst.ref, desc, options...)
+	})
+	if err != nil {
+		return err
+	}
+
+	return dst.signTargets(ctx, &client.Target{
+		Name:   dst.ref.Identifier(),
+		Hashes: target.Hashes,
+		Length: target.Length,
+		Custom: target.Custom,
+	})
+}
+
+// pushDescriptor pushes the image or image index desc to ref.
+func pushDescriptor(log trust.Logger, ref name.Reference, desc *remote.Descriptor, options ...remote.Option) error {
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return pushIndex(log, ref, idx, options...)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return err
+	}
+	return pushImage(log, ref, img, options...)
+}
+
+// replicateTrust stages on dst the delegations of src, with their keys, paths
+// and thresholds, and the targets each role of src signed, initializing dst
+// first if it has no trust data. Every delegation holding targets must have
+// one of its keys in the local key store, as its targets are signed anew.
+// Nothing is staged unless all the trust data can be replicated. It returns
+// the number of targets replicated.
+func replicateTrust(log trust.Logger, src, dst client.Repository, repoName string, serverManagedRoles []data.RoleName) (int, error) {
+	delegations, err := src.GetDelegationRoles()
+	if err != nil {
+		return 0, notaryError(repoName, err)
+	}
+	signed, err := src.GetAllTargetMetadataByName("")
+	if _, ok := err.(client.ErrNoSuchTarget); ok {
+		signed, err = nil, nil
+	}
+	if err != nil {
+		return 0, notaryError(repoName, err)
+	}
+	keys := make(map[string]data.PublicKey)
+	targets := make(map[data.RoleName][]*client.Target)
+	for i := range signed {
+		for keyID, key := range signed[i].Role.Keys {
+			keys[keyID] = key
+		}
+		targets[signed[i].Role.Name] = append(targets[signed[i].Role.Name], &signed[i].Target)
+	}
+
+	// parents are staged before the delegations they delegate to
+	sort.SliceStable(delegations, func(i, j int) bool {
+		return strings.Count(delegations[i].Name.String(), "/") < strings.Count(delegations[j].Name.String(), "/")
+	})
+	delegationKeys := make([][]data.PublicKey, len(delegations))
+	for i := range delegations {
+		delegation := &delegations[i]
+		for _, keyID := range delegation.KeyIDs {
+			key, ok := keys[keyID]
+			if !ok {
+				if key = src.GetCryptoService().GetKey(keyID); key == nil {
+					return 0, errors.Errorf("public key %s of delegation %s of %s is unknown", keyID, delegation.Name, repoName)
+				}
+			}
+			delegationKeys[i] = append(delegationKeys[i], key)
+		}
+		if len(targets[delegation.Name]) > 0 && !hasDelegationKey(src, delegation) {
+			return 0, errors.Errorf("no signing key of delegation %s of %s found in the key store, its targets cannot be replicated", delegation.Name, repoName)
+		}
+	}
+
+	_, err = dst.ListTargets()
+	switch err.(type) {
+	case nil:
+	case client.ErrRepoNotInitialized, client.ErrRepositoryNotExist:
+		if err := initializeRepository(dst, serverManagedRoles); err != nil {
+			return 0, err
+		}
+		log.Infof("Finished initializing %s\n", repoName)
+	default:
+		return 0, notaryError(repoName, err)
+	}
+	for i, delegation := range delegations {
+		if err := addDelegationWithThreshold(log, dst, repoName, delegation.Name, delegationKeys[i], delegation.Paths, delegation.Threshold); err != nil {
+			return 0, err
+		}
+	}
+	replicated := 0
+	for _, role := range append([]data.RoleName{data.CanonicalTargetsRole}, delegationNames(delegations)...) {
+		for _, target := range targets[role] {
+			if err := dst.AddTarget(target, role); err != nil {
+				return 0, err
+			}
+			replicated++
+		}
+	}
+	return replicated, nil
+}
+
+// delegationNames returns the names of delegations.
+func delegationNames(delegations []data.Role) []data.RoleName {
+	names := make([]data.RoleName, 0, len(delegations))
+	for _, delegation := range delegations {
+		names = append(names, delegation.Name)
+	}
+	return names
+}
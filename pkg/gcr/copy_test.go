@@ -0,0 +1,110 @@
+package gcr
+
+import (
+	"testing"
+
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// signedRepository is a migratingRepository returning fixed signed targets.
+type signedRepository struct {
+	*migratingRepository
+	signed []client.TargetSignedStruct
+}
+
+func (r *signedRepository) GetAllTargetMetadataByName(name string) ([]client.TargetSignedStruct, error) {
+	if len(r.signed) == 0 {
+		return nil, client.ErrNoSuchTarget(name)
+	}
+	return r.signed, nil
+}
+
+// replicaRepository is a migratingRepository staging into a changelist.
+type replicaRepository struct {
+	*migratingRepository
+	cl changelist.Changelist
+}
+
+func (r *replicaRepository) GetChangelist() (changelist.Changelist, error) {
+	return r.cl, nil
+}
+
+func TestReplicateTrust(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	key, err := cs.Create(trust.ReleasesRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	other := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	foreign, err := other.Create("targets/ci", "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+
+	releases, err := data.NewRole(trust.ReleasesRole, 1, []string{key.ID()}, []string{""})
+	assert.NilError(t, err)
+	ci, err := data.NewRole("targets/ci", 1, []string{foreign.ID()}, []string{"ci-"})
+	assert.NilError(t, err)
+	newSource := func(signed ...client.TargetSignedStruct) *signedRepository {
+		return &signedRepository{
+			migratingRepository: &migratingRepository{
+				removingRepository: &removingRepository{},
+				cs:                 cs,
+				delegations:        []data.Role{*releases, *ci},
+			},
+			signed: signed,
+		}
+	}
+	newReplica := func() *replicaRepository {
+		return &replicaRepository{
+			migratingRepository: &migratingRepository{
+				removingRepository: &removingRepository{},
+				cs:                 cs,
+				added:              make(map[string][]data.RoleName),
+			},
+			cl: changelist.NewMemChangelist(),
+		}
+	}
+	latest := client.TargetSignedStruct{
+		Role:   data.DelegationRole{BaseRole: data.BaseRole{Name: data.CanonicalTargetsRole}},
+		Target: client.Target{Name: "latest"},
+	}
+	v1 := client.TargetSignedStruct{
+		Role:   data.DelegationRole{BaseRole: data.NewBaseRole(trust.ReleasesRole, 1, key)},
+		Target: client.Target{Name: "v1"},
+	}
+	build := client.TargetSignedStruct{
+		Role:   data.DelegationRole{BaseRole: data.NewBaseRole("targets/ci", 1, foreign)},
+		Target: client.Target{Name: "ci-1"},
+	}
+
+	// the public keys of a delegation without targets are only known when held
+	_, err = replicateTrust(trust.DefaultLogger(), newSource(latest, v1), newReplica(), "gcr.io/project/image", nil)
+	assert.Check(t, is.ErrorContains(err, "public key "+foreign.ID()+" of delegation targets/ci"))
+
+	dst := newReplica()
+	source := newSource(latest, v1)
+	source.delegations = []data.Role{*releases}
+	replicated, err := replicateTrust(trust.DefaultLogger(), source, dst, "gcr.io/project/image", nil)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(replicated, 2))
+	assert.Check(t, is.DeepEqual(dst.added, map[string][]data.RoleName{
+		"latest": {data.CanonicalTargetsRole},
+		"v1":     {trust.ReleasesRole},
+	}))
+	changes := dst.cl.List()
+	assert.Assert(t, is.Len(changes, 1))
+	assert.Check(t, is.Equal(changes[0].Scope(), trust.ReleasesRole))
+	assert.Check(t, is.Equal(changes[0].Type(), changelist.TypeTargetsDelegation))
+
+	// targets of a delegation whose keys are not held cannot be signed anew
+	dst = newReplica()
+	_, err = replicateTrust(trust.DefaultLogger(), newSource(latest, v1, build), dst, "gcr.io/project/image", nil)
+	assert.Check(t, is.ErrorContains(err, "no signing key of delegation targets/ci"))
+	assert.Check(t, is.Len(dst.added, 0))
+	assert.Check(t, is.Len(dst.cl.List(), 0))
+}
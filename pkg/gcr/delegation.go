@@ -0,0 +1,290 @@
+package gcr
+
+import (
+	"encoding/json"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// addDelegation stages the creation of the delegation role with the given
+// public keys and path prefixes.
+func addDelegation(log trust.Logger, notaryRepo client.Repository, repoName string, role data.RoleName, pubKeys []data.PublicKey, paths []string) error {
+	if err := validateDelegationRole(role); err != nil {
+		return err
+	}
+	if len(pubKeys) == 0 {
+		return errors.Errorf("at least one public key is required to add delegation %s", role)
+	}
+
+	if err := notaryRepo.AddDelegation(role, pubKeys, paths); err != nil {
+		return errors.Wrapf(err, "could not add delegation %s", role)
+	}
+	log.Infof("Staged addition of delegation %s to %s\n", role, repoName)
+	return nil
+}
+
+// addDelegationWithThreshold is like addDelegation but makes the delegation
+// require signatures of threshold of pubKeys, which notary itself always
+// creates with a threshold of 1.
+func addDelegationWithThreshold(log trust.Logger, notaryRepo client.Repository, repoName string, role data.RoleName, pubKeys []data.PublicKey, paths []string, threshold int) error {
+	if err := validateDelegationRole(role); err != nil {
+		return err
+	}
+	if threshold < 1 || threshold > len(pubKeys) {
+		return errors.Errorf("invalid threshold %d of delegation %s, must be between 1 and the %d keys given", threshold, role, len(pubKeys))
+	}
+
+	content, err := json.Marshal(&changelist.TUFDelegation{
+		NewThreshold: threshold,
+		AddKeys:      data.KeyList(pubKeys),
+		AddPaths:     paths,
+	})
+	if err != nil {
+		return err
+	}
+	cl, err := notaryRepo.GetChangelist()
+	if err != nil {
+		return err
+	}
+	change := changelist.NewTUFChange(changelist.ActionCreate, role, changelist.TypeTargetsDelegation, "", content)
+	if err := cl.Add(change); err != nil {
+		return errors.Wrapf(err, "could not add delegation %s", role)
+	}
+	log.Infof("Staged addition of delegation %s with threshold %d to %s\n", role, threshold, repoName)
+	return nil
+}
+
+// validateDelegationRole checks that role is a valid delegation name such as
+// targets/releases.
+func validateDelegationRole(role data.RoleName) error {
+	if !data.IsDelegation(role) {
+		return errors.Errorf("invalid delegation role %s, must be of the form targets/<name>", role)
+	}
+	return nil
+}
+
+// listDelegations returns all delegation roles of the notary repository with
+// their thresholds, key IDs and path prefixes.
+func listDelegations(log trust.Logger, notaryRepo client.Repository, repoName string) ([]data.Role, error) {
+	roles, err := notaryRepo.GetDelegationRoles()
+	if err != nil {
+		return nil, notaryError(repoName, err)
+	}
+	for _, r := range roles {
+		log.Debugf("%s: threshold %d, keys %v, paths %v\n", r.Name, r.Threshold, r.KeyIDs, r.Paths)
+	}
+	return roles, nil
+}
+
+// removeDelegation stages the removal of the delegation role.
+func removeDelegation(log trust.Logger, notaryRepo client.Repository, repoName string, role data.RoleName) error {
+	if _, err := findDelegation(notaryRepo, repoName, role); err != nil {
+		return err
+	}
+
+	if err := notaryRepo.RemoveDelegationRole(role); err != nil {
+		return errors.Wrapf(err, "could not remove delegation %s", role)
+	}
+	log.Infof("Staged removal of delegation %s from %s\n", role, repoName)
+	return nil
+}
+
+// removeDelegationKeys stages the removal of keyIDs from the delegation role.
+// Removing every key of the delegation removes the delegation.
+func removeDelegationKeys(log trust.Logger, notaryRepo client.Repository, repoName string, role data.RoleName, keyIDs []string) error {
+	delegation, err := findDelegation(notaryRepo, repoName, role)
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]struct{}, len(keyIDs))
+	for _, keyID := range keyIDs {
+		removed[keyID] = struct{}{}
+	}
+	remaining := 0
+	for _, keyID := range delegation.KeyIDs {
+		if _, ok := removed[keyID]; !ok {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		return removeDelegation(log, notaryRepo, repoName, role)
+	}
+
+	if err := notaryRepo.RemoveDelegationKeys(role, keyIDs); err != nil {
+		return errors.Wrapf(err, "could not remove keys from delegation %s", role)
+	}
+	log.Infof("Staged removal of keys %v from delegation %s of %s\n", keyIDs, role, repoName)
+	return nil
+}
+
+// rotateDelegationKeys stages the replacement of the keys removeKeyIDs of the
+// delegation role with addKeys as a single change, so that both apply in the
+// same publish. The paths and threshold of the delegation are kept.
+func rotateDelegationKeys(log trust.Logger, notaryRepo client.Repository, repoName string, role data.RoleName, removeKeyIDs []string, addKeys []data.PublicKey) error {
+	delegation, err := findDelegation(notaryRepo, repoName, role)
+	if err != nil {
+		return err
+	}
+	td, err := delegationKeyRotation(delegation, removeKeyIDs, addKeys)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(td)
+	if err != nil {
+		return err
+	}
+	cl, err := notaryRepo.GetChangelist()
+	if err != nil {
+		return err
+	}
+	change := changelist.NewTUFChange(changelist.ActionUpdate, role, changelist.TypeTargetsDelegation, "", content)
+	if err := cl.Add(change); err != nil {
+		return errors.Wrapf(err, "could not rotate keys of delegation %s", role)
+	}
+	log.Infof("Staged rotation of keys %v of delegation %s of %s\n", removeKeyIDs, role, repoName)
+	return nil
+}
+
+// delegationKeyRotation returns the delegation update replacing the keys
+// removeKeyIDs of delegation with addKeys. Every key to remove must belong to
+// the delegation, and the delegation must be left with at least as many keys
+// as its threshold.
+func delegationKeyRotation(delegation *data.Role, removeKeyIDs []string, addKeys []data.PublicKey) (*changelist.TUFDelegation, error) {
+	if len(removeKeyIDs) == 0 && len(addKeys) == 0 {
+		return nil, errors.Errorf("no keys to rotate in delegation %s", delegation.Name)
+	}
+	keys := make(map[string]bool, len(delegation.KeyIDs))
+	for _, keyID := range delegation.KeyIDs {
+		keys[keyID] = true
+	}
+	for _, keyID := range removeKeyIDs {
+		if !keys[keyID] {
+			return nil, errors.Errorf("key %s is not a key of delegation %s", keyID, delegation.Name)
+		}
+		delete(keys, keyID)
+	}
+	for _, key := range addKeys {
+		keys[key.ID()] = true
+	}
+	if len(keys) < delegation.Threshold {
+		return nil, errors.Errorf("rotating keys of delegation %s would leave %d keys, fewer than its threshold of %d", delegation.Name, len(keys), delegation.Threshold)
+	}
+	return &changelist.TUFDelegation{
+		AddKeys:    data.KeyList(addKeys),
+		RemoveKeys: removeKeyIDs,
+	}, nil
+}
+
+// witnessDelegation stages the re-signing of the delegation role, as is, with
+// the keys it currently has, e.g. after its keys were rotated out of band and
+// its metadata no longer verifies. The delegation must exist and have one of
+// its keys in the local key store.
+func witnessDelegation(log trust.Logger, notaryRepo client.Repository, repoName string, role data.RoleName) error {
+	delegation, err := findDelegation(notaryRepo, repoName, role)
+	if err != nil {
+		return err
+	}
+	if !hasDelegationKey(notaryRepo, delegation) {
+		return errors.Errorf("no signing key of delegation %s of %s found in the key store", role, repoName)
+	}
+	if _, err := notaryRepo.Witness(role); err != nil {
+		return errors.Wrapf(err, "could not witness delegation %s", role)
+	}
+	log.Infof("Staged witnessing of delegation %s of %s\n", role, repoName)
+	return nil
+}
+
+// findDelegation returns the delegation role named role, or
+// ErrNoSuchDelegation if the repository has no such delegation.
+func findDelegation(notaryRepo client.Repository, repoName string, role data.RoleName) (*data.Role, error) {
+	if err := validateDelegationRole(role); err != nil {
+		return nil, err
+	}
+	roles, err := notaryRepo.GetDelegationRoles()
+	if err != nil {
+		return nil, notaryError(repoName, err)
+	}
+	for i := range roles {
+		if roles[i].Name == role {
+			return &roles[i], nil
+		}
+	}
+	return nil, errors.Wrapf(ErrNoSuchDelegation, "%s in %s", role, repoName)
+}
+
+// stageTargetToRole stages target into the delegation role, which must exist,
+// allow the name of target and have one of its keys in the local key store.
+// The paths are checked locally, so that a target outside of them fails with
+// ErrPathNotAllowed rather than being rejected when notary publishes it.
+func stageTargetToRole(notaryRepo client.Repository, repoName string, role data.RoleName, target *client.Target) error {
+	delegation, err := findDelegation(notaryRepo, repoName, role)
+	if err != nil {
+		return err
+	}
+	if !delegation.CheckPaths(target.Name) {
+		return errors.Wrapf(ErrPathNotAllowed, "delegation %s of %s does not allow signing %s", role, repoName, target.Name)
+	}
+	if !hasDelegationKey(notaryRepo, delegation) {
+		return errors.Errorf("no signing key of delegation %s of %s found in the key store", role, repoName)
+	}
+	return notaryRepo.AddTarget(target, role)
+}
+
+// hasDelegationKey reports whether the local key store holds one of the keys
+// of delegation.
+func hasDelegationKey(notaryRepo client.Repository, delegation *data.Role) bool {
+	held := make(map[string]struct{})
+	for fullKeyID := range notaryRepo.GetCryptoService().ListAllKeys() {
+		held[path.Base(fullKeyID)] = struct{}{}
+	}
+	for _, keyID := range delegation.KeyIDs {
+		if _, ok := held[keyID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateTargets stages a copy of every target of the top level targets role
+// into the delegation role, which must exist, allow the name of every target
+// and have one of its keys in the local key store, and, with removeFromTargets,
+// the removal of the targets from the targets role. Nothing is staged unless
+// every target can be migrated. It returns the number of targets migrated.
+func migrateTargets(notaryRepo client.Repository, repoName string, role data.RoleName, removeFromTargets bool) (int, error) {
+	delegation, err := findDelegation(notaryRepo, repoName, role)
+	if errors.Is(err, ErrNoSuchDelegation) {
+		return 0, errors.Wrap(err, "create the delegation with AddDelegation before migrating targets to it")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !hasDelegationKey(notaryRepo, delegation) {
+		return 0, errors.Errorf("no signing key of delegation %s of %s found in the key store", role, repoName)
+	}
+	targets, err := listRoleTargets(notaryRepo, repoName, data.CanonicalTargetsRole)
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range targets {
+		if !delegation.CheckPaths(t.Name) {
+			return 0, errors.Wrapf(ErrPathNotAllowed, "delegation %s of %s does not allow signing %s", role, repoName, t.Name)
+		}
+	}
+	for _, t := range targets {
+		if err := notaryRepo.AddTarget(t, role); err != nil {
+			return 0, err
+		}
+		if removeFromTargets {
+			if err := notaryRepo.RemoveTarget(t.Name, data.CanonicalTargetsRole); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(targets), nil
+}
@@ -0,0 +1,278 @@
+package gcr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/trustpinning"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestAddDelegationWithThreshold(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "delegation")
+	assert.NilError(t, err)
+	defer os.RemoveAll(tmpDir)
+	notaryRepo, err := client.NewFileCachedRepository(tmpDir, "gcr.io/project/image", "https://localhost", nil, passphrase.ConstantRetriever("passphrase"), trustpinning.TrustPinConfig{})
+	assert.NilError(t, err)
+
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	var keys []data.PublicKey
+	for i := 0; i < 3; i++ {
+		key, err := cs.Create(trust.ReleasesRole, "gcr.io/project/image", data.ECDSAKey)
+		assert.NilError(t, err)
+		keys = append(keys, key)
+	}
+
+	log := trust.DefaultLogger()
+	for _, threshold := range []int{0, 4} {
+		err := addDelegationWithThreshold(log, notaryRepo, "gcr.io/project/image", trust.ReleasesRole, keys, []string{""}, threshold)
+		assert.Check(t, is.ErrorContains(err, "invalid threshold"))
+	}
+	assert.NilError(t, addDelegationWithThreshold(log, notaryRepo, "gcr.io/project/image", trust.ReleasesRole, keys, []string{""}, 2))
+
+	cl, err := notaryRepo.GetChangelist()
+	assert.NilError(t, err)
+	changes := cl.List()
+	assert.Assert(t, is.Len(changes, 1))
+	assert.Check(t, is.Equal(changes[0].Scope(), trust.ReleasesRole))
+	assert.Check(t, is.Equal(changes[0].Type(), changelist.TypeTargetsDelegation))
+	var td changelist.TUFDelegation
+	assert.NilError(t, json.Unmarshal(changes[0].Content(), &td))
+	assert.Check(t, is.Equal(td.NewThreshold, 2))
+	assert.Check(t, is.Len(td.AddKeys, 3))
+	assert.Check(t, is.DeepEqual(td.AddPaths, []string{""}))
+}
+
+func TestDelegationKeyRotation(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	var keys []data.PublicKey
+	for i := 0; i < 3; i++ {
+		key, err := cs.Create(trust.ReleasesRole, "gcr.io/project/image", data.ECDSAKey)
+		assert.NilError(t, err)
+		keys = append(keys, key)
+	}
+	delegation, err := data.NewRole(trust.ReleasesRole, 2, []string{keys[0].ID(), keys[1].ID()}, []string{""})
+	assert.NilError(t, err)
+
+	td, err := delegationKeyRotation(delegation, []string{keys[0].ID()}, keys[2:])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(td.RemoveKeys, []string{keys[0].ID()}))
+	assert.Check(t, is.Len(td.AddKeys, 1))
+	// neither the threshold nor the paths change
+	assert.Check(t, is.Equal(td.NewThreshold, 0))
+	assert.Check(t, is.Len(td.AddPaths, 0))
+	assert.Check(t, is.Len(td.RemovePaths, 0))
+
+	_, err = delegationKeyRotation(delegation, []string{keys[2].ID()}, nil)
+	assert.Check(t, is.ErrorContains(err, "is not a key of delegation"))
+	_, err = delegationKeyRotation(delegation, []string{keys[0].ID()}, nil)
+	assert.Check(t, is.ErrorContains(err, "fewer than its threshold of 2"))
+	_, err = delegationKeyRotation(delegation, nil, nil)
+	assert.Check(t, is.ErrorContains(err, "no keys to rotate"))
+}
+
+// migratingRepository is a removingRepository with delegations that records
+// the targets added to it.
+type migratingRepository struct {
+	*removingRepository
+	cs          signed.CryptoService
+	delegations []data.Role
+	added       map[string][]data.RoleName
+}
+
+func (r *migratingRepository) GetCryptoService() signed.CryptoService {
+	return r.cs
+}
+
+func (r *migratingRepository) GetDelegationRoles() ([]data.Role, error) {
+	return r.delegations, nil
+}
+
+func (r *migratingRepository) AddTarget(target *client.Target, roles ...data.RoleName) error {
+	r.added[target.Name] = roles
+	return nil
+}
+
+func TestMigrateTargets(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	key, err := cs.Create(trust.ReleasesRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	releases, err := data.NewRole(trust.ReleasesRole, 1, []string{key.ID()}, []string{""})
+	assert.NilError(t, err)
+	stable, err := data.NewRole("targets/stable", 1, []string{key.ID()}, []string{"stable"})
+	assert.NilError(t, err)
+	newRepo := func() *migratingRepository {
+		return &migratingRepository{
+			removingRepository: &removingRepository{
+				targets: []*client.TargetWithRole{
+					{Target: client.Target{Name: "latest"}, Role: data.CanonicalTargetsRole},
+					{Target: client.Target{Name: "stable"}, Role: data.CanonicalTargetsRole},
+					{Target: client.Target{Name: "v1"}, Role: trust.ReleasesRole},
+				},
+				removed: make(map[string][]data.RoleName),
+			},
+			cs:          cs,
+			delegations: []data.Role{*releases, *stable},
+			added:       make(map[string][]data.RoleName),
+		}
+	}
+
+	notaryRepo := newRepo()
+	migrated, err := migrateTargets(notaryRepo, "gcr.io/project/image", trust.ReleasesRole, false)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(migrated, 2))
+	assert.Check(t, is.DeepEqual(notaryRepo.added, map[string][]data.RoleName{
+		"latest": {trust.ReleasesRole},
+		"stable": {trust.ReleasesRole},
+	}))
+	assert.Check(t, is.Len(notaryRepo.removed, 0))
+
+	notaryRepo = newRepo()
+	_, err = migrateTargets(notaryRepo, "gcr.io/project/image", trust.ReleasesRole, true)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(notaryRepo.removed, map[string][]data.RoleName{
+		"latest": {data.CanonicalTargetsRole},
+		"stable": {data.CanonicalTargetsRole},
+	}))
+
+	notaryRepo = newRepo()
+	_, err = migrateTargets(notaryRepo, "gcr.io/project/image", "targets/stable", true)
+	assert.Check(t, is.ErrorContains(err, "does not allow signing latest"))
+	assert.Check(t, errors.Is(err, ErrPathNotAllowed), "unexpected error: %v", err)
+	assert.Check(t, is.Len(notaryRepo.added, 0))
+
+	_, err = migrateTargets(newRepo(), "gcr.io/project/image", "targets/missing", false)
+	assert.Check(t, errors.Is(err, ErrNoSuchDelegation), "unexpected error: %v", err)
+	assert.Check(t, is.ErrorContains(err, "AddDelegation"))
+}
+
+func TestStageTargetToRole(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	key, err := cs.Create("targets/stable", "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	stable, err := data.NewRole("targets/stable", 1, []string{key.ID()}, []string{"stable", "v1."})
+	assert.NilError(t, err)
+
+	for _, tc := range []struct {
+		tag     string
+		allowed bool
+	}{
+		{tag: "stable", allowed: true},
+		{tag: "stable-2020", allowed: true},
+		{tag: "v1.2.3", allowed: true},
+		{tag: "latest"},
+		{tag: "v10"},
+	} {
+		t.Run(tc.tag, func(t *testing.T) {
+			notaryRepo := &migratingRepository{
+				removingRepository: &removingRepository{},
+				cs:                 cs,
+				delegations:        []data.Role{*stable},
+				added:              make(map[string][]data.RoleName),
+			}
+			err := stageTargetToRole(notaryRepo, "gcr.io/project/image", "targets/stable", &client.Target{Name: tc.tag})
+			if tc.allowed {
+				assert.NilError(t, err)
+				assert.Check(t, is.DeepEqual(notaryRepo.added, map[string][]data.RoleName{tc.tag: {"targets/stable"}}))
+				return
+			}
+			assert.Check(t, errors.Is(err, ErrPathNotAllowed), "unexpected error: %v", err)
+			assert.Check(t, is.Len(notaryRepo.added, 0))
+		})
+	}
+}
+
+func TestAddDelegation(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t, WithDeferredPublish())
+	defer cleanup()
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	key, err := cs.Create(trust.ReleasesRole, "gcr.io/project/never-signed", data.ECDSAKey)
+	assert.NilError(t, err)
+
+	for _, role := range []data.RoleName{"releases", data.CanonicalTargetsRole, "targets/", "targets/../root", data.CanonicalSnapshotRole} {
+		err := repo.AddDelegation(role, []data.PublicKey{key}, []string{""})
+		assert.Check(t, is.ErrorContains(err, "invalid delegation role"), "role %s", role)
+	}
+	err = repo.AddDelegation(trust.ReleasesRole, nil, []string{""})
+	assert.Check(t, is.ErrorContains(err, "at least one public key is required"))
+	pending, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(pending, 0))
+
+	assert.NilError(t, repo.AddDelegation(trust.ReleasesRole, []data.PublicKey{key}, []string{"v"}))
+	pending, err = repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Assert(t, len(pending) > 0)
+	var td changelist.TUFDelegation
+	for _, c := range pending {
+		assert.Check(t, is.Equal(c.Scope(), trust.ReleasesRole))
+		assert.Check(t, is.Equal(c.Type(), changelist.TypeTargetsDelegation))
+		var staged changelist.TUFDelegation
+		assert.NilError(t, json.Unmarshal(c.Content(), &staged))
+		td.AddKeys = append(td.AddKeys, staged.AddKeys...)
+		td.AddPaths = append(td.AddPaths, staged.AddPaths...)
+	}
+	assert.Assert(t, is.Len(td.AddKeys, 1))
+	assert.Check(t, is.Equal(td.AddKeys[0].ID(), key.ID()))
+	assert.Check(t, is.DeepEqual(td.AddPaths, []string{"v"}))
+}
+
+func TestRemoveDelegationKeys(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t, WithDeferredPublish())
+	defer cleanup()
+	staging, err := repo.NotaryRepository()
+	assert.NilError(t, err)
+	releases, err := data.NewRole(trust.ReleasesRole, 1, []string{"alice", "bob"}, []string{""})
+	assert.NilError(t, err)
+	repo.notary = &migratingRepository{
+		removingRepository: &removingRepository{Repository: staging},
+		delegations:        []data.Role{*releases},
+	}
+	staged := func() []changelist.Change {
+		pending, err := repo.PendingChanges()
+		assert.NilError(t, err)
+		assert.NilError(t, repo.ClearPendingChanges())
+		return pending
+	}
+
+	err = repo.RemoveDelegation("targets/qa")
+	assert.Check(t, errors.Is(err, ErrNoSuchDelegation), "unexpected error: %v", err)
+	err = repo.RemoveDelegationKeys("targets/qa", []string{"alice"})
+	assert.Check(t, errors.Is(err, ErrNoSuchDelegation), "unexpected error: %v", err)
+	assert.Check(t, is.Len(staged(), 0))
+
+	// removing some keys keeps the delegation
+	assert.NilError(t, repo.RemoveDelegationKeys(trust.ReleasesRole, []string{"alice"}))
+	changes := staged()
+	assert.Assert(t, is.Len(changes, 1))
+	assert.Check(t, is.Equal(changes[0].Action(), changelist.ActionUpdate))
+	var td changelist.TUFDelegation
+	assert.NilError(t, json.Unmarshal(changes[0].Content(), &td))
+	assert.Check(t, is.DeepEqual(td.RemoveKeys, []string{"alice"}))
+
+	// removing the last keys removes the delegation, like RemoveDelegation
+	assert.NilError(t, repo.RemoveDelegation(trust.ReleasesRole))
+	removed := staged()
+	assert.Assert(t, is.Len(removed, 1))
+	assert.NilError(t, repo.RemoveDelegationKeys(trust.ReleasesRole, []string{"bob", "alice"}))
+	changes = staged()
+	assert.Assert(t, is.Len(changes, 1))
+	for _, c := range []changelist.Change{changes[0], removed[0]} {
+		assert.Check(t, is.Equal(c.Action(), changelist.ActionDelete))
+		assert.Check(t, is.Equal(c.Scope(), trust.ReleasesRole))
+		assert.Check(t, is.Equal(c.Type(), changelist.TypeTargetsDelegation))
+	}
+	assert.Check(t, is.DeepEqual(changes[0].Content(), removed[0].Content()))
+}
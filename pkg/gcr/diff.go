@@ -0,0 +1,87 @@
+package gcr
+
+import (
+	"context"
+	"sort"
+
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/storage"
+)
+
+// trustDiff compares the targets cached in the trust directory with those
+// the notary server currently publishes. The server metadata is fetched into
+// memory, so the cache is left as it was for inspection.
+func (repo *TrustedGcrRepository) trustDiff(ctx context.Context) (added, removed, changed []client.Target, err error) {
+	registry := repo.ref.Context().Registry
+	repoName := repo.ref.Context().Name()
+
+	cachedRepo, err := trust.GetOfflineNotaryRepository(repo.ref, &registry, repo.config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	local, err := cachedRepo.ListTargets()
+	switch err.(type) {
+	case nil:
+	case client.ErrRepositoryNotExist, client.ErrRepoNotInitialized, storage.ErrOffline:
+		// nothing cached yet, so everything on the server is new
+		local = nil
+	default:
+		return nil, nil, nil, notaryError(repoName, err)
+	}
+
+	remoteRepo, err := trust.GetUncachedNotaryRepository(ctx, repo.ref, repo.notaryAuth, &registry, repo.config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	remote, err := remoteRepo.ListTargets()
+	if err != nil {
+		return nil, nil, nil, notaryError(repoName, err)
+	}
+
+	added, removed, changed = diffTargets(local, remote)
+	return added, removed, changed, nil
+}
+
+// diffTargets returns the targets of remote missing from local, those of
+// local missing from remote, and the remote version of the targets whose
+// length or hashes differ between the two. Each slice is sorted by name.
+func diffTargets(local, remote []*client.TargetWithRole) (added, removed, changed []client.Target) {
+	cached := make(map[string]client.Target, len(local))
+	for _, t := range local {
+		cached[t.Name] = t.Target
+	}
+	published := make(map[string]bool, len(remote))
+	for _, t := range remote {
+		published[t.Name] = true
+		old, ok := cached[t.Name]
+		switch {
+		case !ok:
+			added = append(added, t.Target)
+		case old.Length != t.Length || !sameHashes(old, t.Target):
+			changed = append(changed, t.Target)
+		}
+	}
+	for _, t := range local {
+		if !published[t.Name] {
+			removed = append(removed, t.Target)
+		}
+	}
+	for _, targets := range [][]client.Target{added, removed, changed} {
+		sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	}
+	return added, removed, changed
+}
+
+// sameHashes reports whether a and b carry the same hashes.
+func sameHashes(a, b client.Target) bool {
+	if len(a.Hashes) != len(b.Hashes) {
+		return false
+	}
+	for alg, sum := range a.Hashes {
+		if string(b.Hashes[alg]) != string(sum) {
+			return false
+		}
+	}
+	return true
+}
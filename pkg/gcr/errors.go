@@ -0,0 +1,134 @@
+package gcr
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/trustpinning"
+	"github.com/theupdateframework/notary/tuf/signed"
+)
+
+var (
+	// ErrNoTrustData is returned when the requested tag has no signed target
+	// in the trusted roles of the notary repository.
+	ErrNoTrustData = errors.New("no trust data")
+	// ErrDigestNotSigned is returned when no signed target of the notary
+	// repository matches the requested digest.
+	ErrDigestNotSigned = errors.New("digest is not signed")
+	// ErrAlreadyInitialized is returned by InitTrust when the notary
+	// repository already has trust data.
+	ErrAlreadyInitialized = errors.New("trust data already initialized")
+	// ErrNoSuchDelegation is returned when the requested delegation role does
+	// not exist in the notary repository.
+	ErrNoSuchDelegation = errors.New("no such delegation")
+	// ErrPathNotAllowed is returned when signing a target into a delegation
+	// role whose paths do not cover the name of the target.
+	ErrPathNotAllowed = errors.New("target path not allowed by delegation")
+	// ErrExpiredMetadata is returned when the TUF metadata of the notary
+	// repository has expired.
+	ErrExpiredMetadata = errors.New("trust metadata expired")
+	// ErrSignatureVerification is returned when the TUF metadata of the notary
+	// repository fails signature, threshold or version checks.
+	ErrSignatureVerification = errors.New("trust data signature verification failed")
+	// ErrUninitialized is returned when the notary repository has never been
+	// initialized.
+	ErrUninitialized = errors.New("trust data not initialized")
+	// ErrVersionNotAvailable is returned by VerifyAtVersion when the notary
+	// server does not have the requested version of the targets metadata.
+	ErrVersionNotAvailable = errors.New("targets metadata version not available")
+	// ErrThresholdNotMet is returned by VerifyWithThreshold when fewer keys
+	// of the role than required signed the target.
+	ErrThresholdNotMet = errors.New("signature threshold not met")
+	// ErrTagAlreadySigned is returned, with immutable tags, when a tag is
+	// already signed with another digest.
+	ErrTagAlreadySigned = errors.New("tag already signed with another digest")
+	// ErrRootKeyMismatch is returned by verification when the root key of the
+	// notary repository is not the pinned one, which may mean that the root
+	// key was compromised or that the notary server was swapped.
+	ErrRootKeyMismatch = errors.New("root key does not match pinned root key")
+	// ErrRequiredSignerMissing is returned by verification when none of the
+	// required signer keys signed the trusted target.
+	ErrRequiredSignerMissing = errors.New("target not signed by a required key")
+	// ErrAlreadyServerManaged is returned when rotating a key to the notary
+	// server that the server already holds.
+	ErrAlreadyServerManaged = errors.New("key already managed by the notary server")
+	// ErrDigestNotInRegistry is returned by VerifyImage when the trusted
+	// digest of a tag is not served by the registry.
+	ErrDigestNotInRegistry = errors.New("trusted digest not found in registry")
+	// ErrSizeMismatch is returned by VerifyImage when the manifest served for
+	// the trusted digest differs in length from the signed target.
+	ErrSizeMismatch = errors.New("manifest size does not match signed size")
+	// ErrNoSuchTarget is returned by DeleteTarget when the top level targets
+	// role has no target of the given name.
+	ErrNoSuchTarget = errors.New("no such target")
+	// ErrNotaryUnreachable is returned by PingNotary when the notary server
+	// cannot be connected to.
+	ErrNotaryUnreachable = errors.New("notary server unreachable")
+	// ErrNotaryUnauthorized is returned by PingNotary when the notary server
+	// or its token service rejects the credentials.
+	ErrNotaryUnauthorized = errors.New("notary server rejected credentials")
+	// ErrNotaryServerError is returned by PingNotary when the notary server
+	// answers with an unexpected error.
+	ErrNotaryServerError = errors.New("notary server error")
+)
+
+// TagErrors maps tags to the error that occurred while processing them, for
+// operations handling many tags at once.
+type TagErrors map[string]error
+
+func (e TagErrors) Error() string {
+	tags := make([]string, 0, len(e))
+	for tag := range e {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	msgs := make([]string, len(tags))
+	for i, tag := range tags {
+		msgs[i] = fmt.Sprintf("%s: %s", tag, e[tag])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// notaryError formats err received from the notary service like
+// trust.NotaryError, and wraps the exported error matching its condition so
+// that callers can test for it with errors.Is.
+func notaryError(repoName string, err error) error {
+	var kind error
+	switch err.(type) {
+	case client.ErrRepositoryNotExist, client.ErrRepoNotInitialized:
+		kind = ErrUninitialized
+	case storage.ErrMetaNotFound, client.ErrNoSuchTarget:
+		kind = ErrNoTrustData
+	case signed.ErrExpired:
+		kind = ErrExpiredMetadata
+	case trustpinning.ErrRootRotationFail, trustpinning.ErrValidationFail, signed.ErrInvalidKeyType,
+		signed.ErrLowVersion, signed.ErrRoleThreshold:
+		kind = ErrSignatureVerification
+	default:
+		return trust.NotaryError(repoName, err)
+	}
+	return fmt.Errorf("%w: %v", kind, trust.NotaryError(repoName, err))
+}
+
+// pingError classifies an error of trust.PingNotary.
+func pingError(err error) error {
+	var terr *transport.Error
+	var nerr net.Error
+	switch {
+	case errors.As(err, &terr) && (terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden):
+		return fmt.Errorf("%w: %v", ErrNotaryUnauthorized, err)
+	case errors.As(err, &terr):
+		return fmt.Errorf("%w: %v", ErrNotaryServerError, err)
+	case errors.As(err, &nerr):
+		return fmt.Errorf("%w: %v", ErrNotaryUnreachable, err)
+	}
+	return err
+}
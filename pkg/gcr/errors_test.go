@@ -0,0 +1,33 @@
+package gcr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"gotest.tools/assert"
+)
+
+func TestNotaryError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{client.ErrRepositoryNotExist{}, ErrUninitialized},
+		{client.ErrRepoNotInitialized{}, ErrUninitialized},
+		{storage.ErrMetaNotFound{Resource: "root"}, ErrNoTrustData},
+		{client.ErrNoSuchTarget("latest"), ErrNoTrustData},
+		{signed.ErrExpired{Role: "timestamp", Expired: time.Now().String()}, ErrExpiredMetadata},
+		{signed.ErrRoleThreshold{}, ErrSignatureVerification},
+	}
+	for _, c := range cases {
+		err := notaryError("gcr.io/foo/image", c.err)
+		assert.Check(t, errors.Is(err, c.kind), "%T should wrap %v, got %v", c.err, c.kind, err)
+	}
+
+	err := notaryError("gcr.io/foo/image", errors.New("boom"))
+	assert.Error(t, err, "boom")
+}
@@ -1,71 +1,2353 @@
 package gcr
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
 	"github.com/simonshyu/notary-gcr/trust"
-	log "github.com/sirupsen/logrus"
 	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/data"
 )
 
+// TrustedGcrRepository signs, pushes and verifies the images of a repository
+// with notary. It is safe for concurrent use by multiple goroutines: the
+// operations using the notary repository are run one at a time.
 type TrustedGcrRepository struct {
 	ref          name.Reference
 	registryAuth authn.Authenticator
 	notaryAuth   authn.Authenticator
 	config       *trust.Config
+	// logger is baseLogger with the GUN and reference of the repository
+	// attached as fields, see useReferenceLogger
+	logger     trust.Logger
+	baseLogger trust.Logger
+	// registryTransport is used for all registry traffic
+	registryTransport http.RoundTripper
+	// deferPublish keeps staged changes in the changelist until Publish
+	deferPublish bool
+	// serverManagedRoles are the roles whose keys the notary server holds
+	// when the repository is initialized
+	serverManagedRoles []data.RoleName
+	// maxStaleness is how long after being fetched cached trust data is used
+	// by verification without fetching it again
+	maxStaleness time.Duration
+	// expiryWarning is how long before expiry verification warns about
+	// expiring metadata
+	expiryWarning time.Duration
+	// pushProgress receives the progress of registry pushes
+	pushProgress func(v1.Update)
+	// pushRetries overrides the number of retries of each blob upload
+	pushRetries *int
+	// immutableTags forbids signing a tag again with another digest
+	immutableTags bool
+	// pinnedRoots are the root key IDs verification accepts
+	pinnedRoots []string
+	// rootPinStore is the file recording the root key first seen for each
+	// repository
+	rootPinStore string
+	// observer is notified of the outcome of trust operations
+	observer Observer
+	// verifyHook is told the outcome of every verification
+	verifyHook func(tag string, target *client.Target, err error)
+	// dryRun skips registry pushes and notary publishes, recording the
+	// changes that would have been published in dryRunChanges
+	dryRun        bool
+	dryRunChanges []changelist.Change
+	// noAutoInit makes signing fail on a repository without trust data
+	noAutoInit bool
+	// ignoreExpiry lets VerifyTagIgnoringExpiry accept expired trust data
+	ignoreExpiry bool
+	// prereleases lets LatestSignedSemver return prerelease versions
+	prereleases bool
+	// rootChangeHook is told when verification sees the root key change
+	rootChangeHook func(oldKeyID, newKeyID string)
+	// requiredSigners are the key IDs one of which must have signed the
+	// trusted target
+	requiredSigners []string
+	// verifyCache, if set, holds recent successful verifications
+	verifyCache *verifyCache
+
+	// mu serializes the operations using the notary handle and its
+	// changelist; copies of a repository share it
+	mu        *sync.Mutex
+	notary    client.Repository
+	notaryCtx *operationContext
+}
+
+// NewTrustedGcrRepository returns a repository for ref reading its trust
+// config from configDir and authenticating to the registry and the notary
+// server with registryAuth and notaryAuth. It is equivalent to
+// NewTrustedGcrRepositoryWithOptions with WithConfigDir, WithRegistryAuth and
+// WithNotaryAuth, followed by opts.
+func NewTrustedGcrRepository(configDir string, ref name.Reference, registryAuth authn.Authenticator, notaryAuth authn.Authenticator, opts ...Option) (TrustedGcrRepository, error) {
+	positional := func(o *options) error {
+		o.configDir = configDir
+		o.registryAuth = registryAuth
+		o.notaryAuth = notaryAuth
+		return nil
+	}
+	return NewTrustedGcrRepositoryWithOptions(ref, append([]Option{positional}, opts...)...)
+}
+
+// NewTrustedGcrRepositoryWithOptions returns a repository for ref configured
+// by opts. Without options the trust config is read from the default config
+// directory and both the registry and the notary server are accessed
+// anonymously.
+func NewTrustedGcrRepositoryWithOptions(ref name.Reference, opts ...Option) (TrustedGcrRepository, error) {
+	o, err := makeOptions(opts...)
+	if err != nil {
+		return TrustedGcrRepository{}, err
+	}
+	config, err := trust.ParseConfig(o.configDir)
+	if err != nil {
+		o.logger.Errorf("failed to parse config: %s", err)
+		return TrustedGcrRepository{}, err
+	}
+	return newTrustedGcrRepository(ref, config, o)
 }
 
-func NewTrustedGcrRepository(configDir string, ref name.Reference, registryAuth authn.Authenticator, notaryAuth authn.Authenticator) (TrustedGcrRepository, error) {
-	config, err := trust.ParseConfig(configDir)
+// NewTrustedGcrRepositoryFromConfig is like NewTrustedGcrRepository but takes
+// the trust config as is instead of reading it from a config directory, e.g.
+// to configure the repository programmatically in a container. The logger,
+// passphrase retriever, retry policy, transport, key algorithm, key
+// generation hook, key store, trust anchor and metadata expiry of cfg are used
+// unless overridden by opts. cfg is not modified; WithConfigDir has no effect.
+func NewTrustedGcrRepositoryFromConfig(ref name.Reference, cfg *trust.Config, registryAuth authn.Authenticator, notaryAuth authn.Authenticator, opts ...Option) (TrustedGcrRepository, error) {
+	if cfg == nil {
+		return TrustedGcrRepository{}, errors.New("trust config must not be nil")
+	}
+	positional := func(o *options) error {
+		o.registryAuth = registryAuth
+		o.notaryAuth = notaryAuth
+		if cfg.Logger != nil {
+			o.logger = cfg.Logger
+		}
+		o.passRetriever = cfg.PassRetriever
+		o.retry = cfg.Retry
+		o.notaryTransport = cfg.Transport
+		o.keyAlgorithm = cfg.KeyAlgorithm
+		o.keyGenHook = cfg.KeyGenHook
+		o.keyStore = cfg.KeyStore
+		o.trustAnchor = cfg.TrustAnchor
+		o.metadataExpiry = cfg.MetadataExpiry
+		return nil
+	}
+	o, err := makeOptions(append([]Option{positional}, opts...)...)
 	if err != nil {
-		log.Errorf("failed to parse config: %s", err)
 		return TrustedGcrRepository{}, err
 	}
-	return TrustedGcrRepository{ref, registryAuth, notaryAuth, config}, nil
+	config := *cfg
+	return newTrustedGcrRepository(ref, &config, o)
 }
 
-func (repo *TrustedGcrRepository) ListTarget() ([]*client.Target, error) {
-	targets, err := listTargets(repo.ref, repo.notaryAuth, repo.config)
+// newTrustedGcrRepository returns a repository for ref using config, with the
+// options o applied on top of it.
+func newTrustedGcrRepository(ref name.Reference, config *trust.Config, o *options) (TrustedGcrRepository, error) {
+	if err := o.resolveAuth(ref.Context()); err != nil {
+		o.logger.Errorf("failed to resolve credentials: %s", err)
+		return TrustedGcrRepository{}, err
+	}
+	if o.trustServer != "" {
+		config.ServerUrl = o.trustServer
+	}
+	config.Logger = o.logger
+	config.PassRetriever = o.passRetriever
+	config.Retry = o.retry
+	config.Transport = o.notaryTransport
+	config.KeyAlgorithm = o.keyAlgorithm
+	config.KeyGenHook = o.keyGenHook
+	config.TrustAnchor = o.trustAnchor
+	config.KeyStore = o.keyStore
+	config.MetadataExpiry = o.metadataExpiry
+	if o.inMemoryKeyStore {
+		config.KeyStore = trust.NewMemoryKeyStore(config)
+	}
+	if o.notaryRootCAs != nil {
+		config.RootCAs = o.notaryRootCAs
+	}
+	if o.insecureSkipVerify != nil {
+		config.InsecureSkipVerify = o.insecureSkipVerify
+	}
+	if o.cacheDir != "" {
+		if err := os.MkdirAll(o.cacheDir, 0700); err != nil {
+			o.logger.Errorf("failed to create cache directory: %s", err)
+			return TrustedGcrRepository{}, err
+		}
+		config.CacheDir = o.cacheDir
+	}
+	registryTransport := o.registryTransport
+	if registryTransport == nil {
+		registryTransport = defaultRegistryTransport()
+	}
+	if o.pushChunkSize > 0 {
+		registryTransport = &chunkingTransport{base: registryTransport, size: o.pushChunkSize}
+	}
+	registryAuth := o.registryAuth
+	if o.tokenRefresh != nil {
+		registryAuth = &refreshingAuth{auth: registryAuth, refresh: o.tokenRefresh}
+	}
+	repo := TrustedGcrRepository{
+		ref:                ref,
+		registryAuth:       registryAuth,
+		notaryAuth:         o.notaryAuth,
+		config:             config,
+		baseLogger:         o.logger,
+		registryTransport:  registryTransport,
+		deferPublish:       o.deferPublish,
+		serverManagedRoles: o.serverManagedRoles,
+		expiryWarning:      o.expiryWarning,
+		maxStaleness:       o.maxStaleness,
+		pushProgress:       o.pushProgress,
+		pushRetries:        o.pushRetries,
+		immutableTags:      o.immutableTags,
+		pinnedRoots:        o.pinnedRoots,
+		rootPinStore:       o.rootPinStore,
+		observer:           o.observer,
+		verifyHook:         o.verifyHook,
+		dryRun:             o.dryRun,
+		noAutoInit:         o.noAutoInit,
+		ignoreExpiry:       o.ignoreExpiry,
+		prereleases:        o.prereleases,
+		verifyCache:        o.verifyCache,
+		requiredSigners:    o.requiredSigners,
+		rootChangeHook:     o.rootChangeHook,
+		mu:                 new(sync.Mutex),
+	}
+	repo.useReferenceLogger()
+	return repo, nil
+}
+
+// useReferenceLogger makes repo, including its notary repository setup, log
+// through its base logger with the GUN, reference and tag or digest of the
+// repository attached as structured fields, e.g. to correlate the logs of a
+// batch over many repositories. It must be called whenever the reference or
+// trust server changes.
+func (repo *TrustedGcrRepository) useReferenceLogger() {
+	fields := map[string]interface{}{"reference": repo.ref.String()}
+	switch ref := repo.ref.(type) {
+	case name.Tag:
+		fields["tag"] = ref.TagStr()
+	case name.Digest:
+		fields["digest"] = ref.DigestStr()
+	}
+	registry := repo.ref.Context().Registry
+	if gun, err := trust.GUN(repo.ref, &registry, repo.config); err == nil {
+		fields["gun"] = gun.String()
+	}
+	config := *repo.config
+	config.Logger = trust.WithFields(repo.baseLogger, fields)
+	repo.config = &config
+	repo.logger = config.Logger
+}
+
+// targetLogger returns the logger of repo with the name and, when known, the
+// digest of target attached as fields.
+func (repo *TrustedGcrRepository) targetLogger(target *client.Target) trust.Logger {
+	fields := map[string]interface{}{"tag": target.Name}
+	if digest, err := targetDigest(target); err == nil {
+		fields["digest"] = digest.String()
+	}
+	return trust.WithFields(repo.logger, fields)
+}
+
+// remoteOptions returns the go-containerregistry options of registry calls
+// made on behalf of ctx.
+func (repo *TrustedGcrRepository) remoteOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithAuth(repo.registryAuth),
+		remote.WithTransport(repo.registryTransport),
+		remote.WithContext(ctx),
+	}
+}
+
+// pushOptions is like remoteOptions but also applies the push retries and
+// reports the progress of the push to the push progress callback, if any.
+// The returned function waits for the last update to be delivered and must
+// be called once the push returned.
+func (repo *TrustedGcrRepository) pushOptions(ctx context.Context) ([]remote.Option, func()) {
+	options := repo.remoteOptions(ctx)
+	if repo.pushRetries != nil {
+		options = append(options, remote.WithRetryBackoff(remote.Backoff{
+			Duration: time.Second,
+			Factor:   3,
+			Jitter:   0.1,
+			Steps:    *repo.pushRetries + 1,
+		}))
+	}
+	if repo.pushProgress == nil {
+		return options, func() {}
+	}
+	// go-containerregistry closes updates when the push returns
+	updates := make(chan v1.Update, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for update := range updates {
+			repo.pushProgress(update)
+		}
+	}()
+	return append(options, remote.WithProgress(updates)), func() { <-done }
+}
+
+// upload runs push, which pushes to ref with the given options, reporting its
+// progress to the push progress callback. Nothing is pushed in dry-run mode.
+// The options carry ctx, so that cancelling it stops an upload in progress,
+// and the context error is returned when ctx is done by the time the push
+// returns, so an upload racing with the cancellation is never signed. With
+// WithTokenRefresh, an upload rejected as unauthorized is retried once with
+// refreshed credentials.
+func (repo *TrustedGcrRepository) upload(ctx context.Context, ref name.Reference, push func(options ...remote.Option) error) error {
+	if repo.dryRun {
+		repo.logger.Infof("Dry run: would push %s\n", ref)
+		return nil
+	}
+	options, wait := repo.pushOptions(ctx)
+	err := push(options...)
+	wait()
+	if auth, ok := repo.registryAuth.(*refreshingAuth); ok && isUnauthorized(err) && ctx.Err() == nil {
+		repo.logger.Warnf("Registry credentials rejected while pushing %s, refreshing them\n", ref)
+		if err := auth.Refresh(); err != nil {
+			return err
+		}
+		options, wait = repo.pushOptions(ctx)
+		err = push(options...)
+		wait()
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return errors.Wrapf(ctxErr, "push of %s aborted", ref)
+	}
+	return err
+}
+
+// InitTrust initializes the notary repository of the reference, generating
+// its root and targets keys, and publishes the initial metadata without
+// pushing any image. ErrAlreadyInitialized is returned if the repository
+// already has trust data.
+func (repo *TrustedGcrRepository) InitTrust() error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	if err := initTrust(repo.logger, notaryRepo, repo.ref.Context().Name(), repo.serverManagedRoles); err != nil {
+		repo.logger.Errorf("failed to initialize trust: %s", err)
+		return err
+	}
+	return nil
+}
+
+// InitTrustWithKeys is like InitTrust but uses rootKeys, e.g. root
+// certificates, and targetsKeys as the keys of the root and targets roles
+// instead of generating them, e.g. for keys generated offline in an HSM. The
+// private keys of all root keys and of at least one targets key must be
+// reachable through the key store, as the initial metadata is signed with
+// them; later signatures may come from delegations instead. Notary generates
+// a targets key while initializing, which is replaced by targetsKeys before
+// publishing and then removed from the key store.
+func (repo *TrustedGcrRepository) InitTrustWithKeys(rootKeys, targetsKeys []data.PublicKey) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	if err := initTrustWithKeys(repo.logger, notaryRepo, repo.ref.Context().Name(), repo.serverManagedRoles, rootKeys, targetsKeys); err != nil {
+		repo.logger.Errorf("failed to initialize trust: %s", err)
+		return err
+	}
+	return nil
+}
+
+// RotateKey rotates the key of the root, targets, snapshot or timestamp role
+// and publishes the change. When serverManaged is set the new snapshot or
+// timestamp key is held by the notary server; root and targets keys are
+// always managed locally.
+func (repo *TrustedGcrRepository) RotateKey(role data.RoleName, serverManaged bool) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
 	if err != nil {
-		log.Errorf("failed to list targets: %s", err)
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	if err := rotateKey(repo.logger, notaryRepo, repo.ref.Context().Name(), role, serverManaged); err != nil {
+		repo.logger.Errorf("failed to rotate key: %s", err)
+		return err
+	}
+	return nil
+}
+
+// ExportRootKey writes the root key of the local key store to w in the notary
+// PEM format, encrypted with passphrase, e.g. to escrow it for recovery.
+func (repo *TrustedGcrRepository) ExportRootKey(w io.Writer, passphrase string) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	if err := exportRootKey(notaryRepo.GetCryptoService(), w, passphrase); err != nil {
+		repo.logger.Errorf("failed to export root key: %s", err)
+		return err
+	}
+	return nil
+}
+
+// NotaryRepository returns the notary repository of the GUN of the reference,
+// authenticated with the notary credentials of the repository and created on
+// first use, for workflows the repository does not cover, e.g. reading
+// metadata of specific versions. It is the handle later calls of the
+// repository reuse. Using it directly bypasses the checks of the repository,
+// such as root pinning, immutable tags and dry runs, and it must not be used
+// concurrently with them.
+func (repo *TrustedGcrRepository) NotaryRepository() (client.Repository, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
 		return nil, err
 	}
-	return targets, nil
+	return notaryRepo, nil
 }
 
-func (repo *TrustedGcrRepository) TrustPush(img v1.Image) error {
-	err := pushImage(repo.ref, img, repo.registryAuth)
+// ImportRootKey reads a root key in the notary PEM format encrypted with
+// passphrase from r, e.g. one written by ExportRootKey, and adds it to the
+// local key store so that the trust data of the repository can be managed
+// from this machine again.
+func (repo *TrustedGcrRepository) ImportRootKey(r io.Reader, passphrase string) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
 	if err != nil {
-		log.Errorf("failed to push image: %s", err)
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	if err := importRootKey(notaryRepo.GetCryptoService(), r, passphrase); err != nil {
+		repo.logger.Errorf("failed to import root key: %s", err)
 		return err
 	}
-	return pushTrustedReference(repo.ref, img, repo.notaryAuth, repo.config)
+	return nil
 }
 
-func (repo *TrustedGcrRepository) Verify() (*client.Target, error) {
-	target, err := getTrustedTarget(repo.ref, repo.notaryAuth, repo.config)
+// ExportTrustBundle writes the trust state of the repository to w as a tar
+// bundle, e.g. to back it up or move it to another trust directory with
+// ImportTrustBundle: the TUF metadata of the repository, root, targets,
+// snapshot, timestamp and delegations, brought up to date with the notary
+// server first. No keys are included; see ExportTrustBundleWithKeys. The same
+// trust state always gives the same bundle.
+func (repo *TrustedGcrRepository) ExportTrustBundle(w io.Writer) error {
+	defer repo.lock()()
+	return repo.exportTrustBundle(w, "")
+}
+
+// ExportTrustBundleWithKeys is like ExportTrustBundle but also includes the
+// signing keys of the repository and its root keys found in the local key
+// store, encrypted with passphrase, which must not be empty.
+func (repo *TrustedGcrRepository) ExportTrustBundleWithKeys(w io.Writer, passphrase string) error {
+	defer repo.lock()()
+	if passphrase == "" {
+		return errors.New("a passphrase is required to export the keys of the repository")
+	}
+	return repo.exportTrustBundle(w, passphrase)
+}
+
+// exportTrustBundle writes the trust bundle of the repository to w, with the
+// keys encrypted with passphrase unless it is empty.
+func (repo *TrustedGcrRepository) exportTrustBundle(w io.Writer, passphrase string) error {
+	if err := repo.updateMetadata(context.Background()); err != nil {
+		return err
+	}
+	registry := repo.ref.Context().Registry
+	meta, err := trust.GetCachedMetadata(repo.ref, &registry, repo.config)
 	if err != nil {
-		log.Errorf("failed to verify repository: %s", err)
+		repo.logger.Errorf("failed to read trust metadata: %s", err)
+		return err
+	}
+	var keys map[string][]byte
+	if passphrase != "" {
+		notaryRepo, err := repo.notaryRepository(context.Background())
+		if err != nil {
+			repo.logger.Errorf("failed to get notary repository: %s", err)
+			return err
+		}
+		root, err := trust.GetCachedRoot(repo.ref, &registry, repo.config)
+		if err != nil {
+			repo.logger.Errorf("failed to read root metadata: %s", err)
+			return err
+		}
+		if keys, err = exportGUNKeys(notaryRepo.GetCryptoService(), notaryRepo.GetGUN(), root, passphrase); err != nil {
+			repo.logger.Errorf("failed to export signing keys: %s", err)
+			return err
+		}
+	}
+	if err := writeTrustBundle(w, meta, keys); err != nil {
+		repo.logger.Errorf("failed to write trust bundle: %s", err)
+		return err
+	}
+	return nil
+}
+
+// ImportTrustBundle reads a bundle written by ExportTrustBundle from r and
+// replaces the cached trust metadata of the repository with the metadata it
+// holds, which must verify against its own root metadata. Nothing is imported
+// if the metadata does not verify, or if the bundle holds keys, which only
+// ImportTrustBundleWithKeys imports.
+func (repo *TrustedGcrRepository) ImportTrustBundle(r io.Reader) error {
+	defer repo.lock()()
+	return repo.importTrustBundle(r, "")
+}
+
+// ImportTrustBundleWithKeys is like ImportTrustBundle but also adds the keys
+// the bundle holds, e.g. one written by ExportTrustBundleWithKeys, decrypted
+// with passphrase, to the local key store. Nothing is imported if a key
+// cannot be decrypted.
+func (repo *TrustedGcrRepository) ImportTrustBundleWithKeys(r io.Reader, passphrase string) error {
+	defer repo.lock()()
+	if passphrase == "" {
+		return errors.New("a passphrase is required to import the keys of the trust bundle")
+	}
+	return repo.importTrustBundle(r, passphrase)
+}
+
+// importTrustBundle imports the trust bundle read from r, decrypting its keys
+// with passphrase.
+func (repo *TrustedGcrRepository) importTrustBundle(r io.Reader, passphrase string) error {
+	meta, encrypted, err := readTrustBundle(r)
+	if err == nil && len(encrypted) > 0 && passphrase == "" {
+		err = errors.New("a passphrase is required to import the keys of the trust bundle; use ImportTrustBundleWithKeys")
+	}
+	var keys []bundleKey
+	if err == nil {
+		keys, err = decryptKeys(encrypted, passphrase)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to read trust bundle: %s", err)
+		return err
+	}
+	registry := repo.ref.Context().Registry
+	if err := trust.SetCachedMetadata(repo.ref, &registry, repo.config, meta); err != nil {
+		repo.logger.Errorf("failed to import trust metadata: %s", err)
+		return err
+	}
+	// the cached handle holds the metadata that was replaced
+	repo.notary = nil
+	if len(keys) == 0 {
+		return nil
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	for _, k := range keys {
+		if err := notaryRepo.GetCryptoService().AddKey(k.role, k.gun, k.key); err != nil {
+			repo.logger.Errorf("failed to import %s key %s: %s", k.role, k.key.ID(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTrustData removes the cached trust metadata, the changelist and the
+// signing keys of the repository from the local trust directory, leaving the
+// root key alone. When deleteRemote is set the trust data is deleted from the
+// notary server as well, which requires admin access to the repository. It
+// reports whether any trust data was deleted; it is not an error if there was
+// none.
+func (repo *TrustedGcrRepository) DeleteTrustData(deleteRemote bool) (bool, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return false, err
+	}
+	removed, err := removeGUNKeys(notaryRepo.GetCryptoService(), notaryRepo.GetGUN())
+	if err != nil {
+		repo.logger.Errorf("failed to delete signing keys: %s", err)
+		return removed > 0, err
+	}
+	// the cached handle holds the metadata that is about to be deleted
+	repo.notary = nil
+	repo.forgetVerifications()
+
+	registry := repo.ref.Context().Registry
+	deleted, err := trust.DeleteTrustData(context.Background(), repo.ref, repo.notaryAuth, &registry, repo.config, deleteRemote)
+	if err != nil {
+		err = notaryError(repo.ref.Context().Name(), err)
+		repo.logger.Errorf("failed to delete trust data: %s", err)
+		return deleted || removed > 0, err
+	}
+	return deleted || removed > 0, nil
+}
+
+// AddDelegation creates the delegation role, e.g. targets/releases, with the
+// given public keys and path prefixes and publishes it, unless publishing is
+// deferred.
+func (repo *TrustedGcrRepository) AddDelegation(role data.RoleName, pubKeys []data.PublicKey, paths []string) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return addDelegation(repo.logger, notaryRepo, repo.ref.Context().Name(), role, pubKeys, paths)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to add delegation: %s", err)
+		return err
+	}
+	return nil
+}
+
+// AddDelegationWithThreshold is like AddDelegation but makes the delegation
+// require signatures of threshold distinct keys among pubKeys, e.g. 2 for
+// release signing needing two approvers. threshold must be between 1 and the
+// number of keys.
+func (repo *TrustedGcrRepository) AddDelegationWithThreshold(role data.RoleName, pubKeys []data.PublicKey, paths []string, threshold int) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return addDelegationWithThreshold(repo.logger, notaryRepo, repo.ref.Context().Name(), role, pubKeys, paths, threshold)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to add delegation: %s", err)
+		return err
+	}
+	return nil
+}
+
+// ListDelegations returns all delegation roles configured on the repository.
+// It is read-only and does not need any local signing key.
+func (repo *TrustedGcrRepository) ListDelegations() ([]data.Role, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
 		return nil, err
 	}
-	return target, nil
+	roles, err := listDelegations(repo.logger, notaryRepo, repo.ref.Context().Name())
+	if err != nil {
+		repo.logger.Errorf("failed to list delegations: %s", err)
+		return nil, err
+	}
+	return roles, nil
 }
 
-func (repo *TrustedGcrRepository) SignImage(img v1.Image) error {
-	err := signImage(repo.ref, img, repo.notaryAuth, repo.config)
+// RemoveDelegation removes the delegation role and publishes the change,
+// unless publishing is deferred.
+// ErrNoSuchDelegation is returned if the delegation does not exist.
+func (repo *TrustedGcrRepository) RemoveDelegation(role data.RoleName) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return removeDelegation(repo.logger, notaryRepo, repo.ref.Context().Name(), role)
+	})
 	if err != nil {
-		log.Errorf("failed to sign image: %s", err)
+		repo.logger.Errorf("failed to remove delegation: %s", err)
 		return err
 	}
 	return nil
 }
 
-func (repo *TrustedGcrRepository) RevokeTag(tag string) error {
-	err := revokeImage(repo.ref, tag, repo.notaryAuth, repo.config)
+// RemoveDelegationKeys removes the given keys from the delegation role and
+// publishes the change, unless publishing is deferred. Removing the last key
+// of a delegation removes the delegation as RemoveDelegation does.
+// ErrNoSuchDelegation is returned if the delegation does not exist.
+func (repo *TrustedGcrRepository) RemoveDelegationKeys(role data.RoleName, keyIDs []string) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
 	if err != nil {
-		log.Errorf("failed to revoke trusted repository: %s", err)
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return removeDelegationKeys(repo.logger, notaryRepo, repo.ref.Context().Name(), role, keyIDs)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to remove delegation keys: %s", err)
+		return err
+	}
+	return nil
+}
+
+// RotateDelegationKey replaces the keys removeKeyIDs of the delegation role
+// with addKeys, e.g. when a signer leaves the team, and publishes both in a
+// single change, unless publishing is deferred. The paths and threshold of the
+// delegation are kept, and the rotation fails if it would leave fewer keys
+// than the threshold. ErrNoSuchDelegation is returned if the delegation does
+// not exist.
+func (repo *TrustedGcrRepository) RotateDelegationKey(role data.RoleName, removeKeyIDs []string, addKeys []data.PublicKey) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return rotateDelegationKeys(repo.logger, notaryRepo, repo.ref.Context().Name(), role, removeKeyIDs, addKeys)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to rotate delegation key: %s", err)
+		return err
+	}
+	return nil
+}
+
+// WitnessDelegation signs the delegation role again with its current keys and
+// targets and publishes it, unless publishing is deferred. This recovers a
+// delegation whose metadata no longer verifies, e.g. because its keys were
+// rotated out of band, provided its threshold can be met with its current
+// keys. It fails with ErrNoSuchDelegation if the delegation does not exist,
+// and with an error from notary on publish if it cannot be witnessed.
+func (repo *TrustedGcrRepository) WitnessDelegation(role data.RoleName) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return witnessDelegation(repo.logger, notaryRepo, repo.ref.Context().Name(), role)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to witness delegation: %s", err)
+		return err
+	}
+	return nil
+}
+
+// RotateSnapshotToServer replaces the local snapshot key of a repository with
+// a key generated and held by the notary server and publishes the change. It
+// requires the root key to be available locally.
+//
+// Once the server holds the snapshot key, it signs every new snapshot on its
+// own, so publishing only needs the targets or delegation keys. A
+// compromised server can then sign snapshots of any combination of targets
+// metadata it has seen, e.g. to hold back a more recent targets version, but
+// it still cannot sign targets of its own.
+func (repo *TrustedGcrRepository) RotateSnapshotToServer() error {
+	return repo.RotateKey(data.CanonicalSnapshotRole, true)
+}
+
+// RotateTimestampToServer replaces a local timestamp key of a repository with
+// a key held by the notary server, the notary default, and publishes the
+// change. It requires the root key to be available locally. Nothing is done
+// and ErrAlreadyServerManaged is returned if the local key store holds none
+// of the published timestamp keys, i.e. the server already manages them.
+func (repo *TrustedGcrRepository) RotateTimestampToServer() error {
+	defer repo.lock()()
+	root, err := repo.publishedRoot(context.Background())
+	if err != nil {
+		return err
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	repoName := repo.ref.Context().Name()
+	if isServerManaged(notaryRepo.GetCryptoService(), root, data.CanonicalTimestampRole) {
+		repo.logger.Infof("The %s key of %s is already managed by the server\n", data.CanonicalTimestampRole, repoName)
+		return errors.Wrapf(ErrAlreadyServerManaged, "%s: %s", repoName, data.CanonicalTimestampRole)
+	}
+	if err := rotateKey(repo.logger, notaryRepo, repoName, data.CanonicalTimestampRole, true); err != nil {
+		repo.logger.Errorf("failed to rotate key: %s", err)
+		return err
+	}
+	return nil
+}
+
+// RootKeyInfo returns the ID and algorithm of the root key in effect, as
+// published in the root metadata of the repository. When the root role has
+// several keys, the first one by ID is returned. Only read access to the
+// notary server is needed.
+func (repo *TrustedGcrRepository) RootKeyInfo() (keyID string, algorithm string, err error) {
+	defer repo.lock()()
+	root, err := repo.publishedRoot(context.Background())
+	if err != nil {
+		return "", "", err
+	}
+	key, err := rootKey(root)
+	if err != nil {
+		repo.logger.Errorf("failed to get root key: %s", err)
+		return "", "", err
+	}
+	return key.ID(), key.Algorithm(), nil
+}
+
+// RoleKeys returns the public keys of the root, targets, snapshot and
+// timestamp roles, as published in the root metadata of the repository. Only
+// read access to the notary server is needed.
+func (repo *TrustedGcrRepository) RoleKeys() (map[data.RoleName][]data.PublicKey, error) {
+	defer repo.lock()()
+	root, err := repo.publishedRoot(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return roleKeys(root), nil
+}
+
+// KeyLocations reports for the root, targets, snapshot and timestamp roles
+// whether their private keys are held locally (KeyLocal), by the notary
+// server (KeyServer) or elsewhere (KeyOffline), based on the published root
+// metadata and the local key store, e.g. to decide which keys need a backup.
+// Nothing is changed and only read access to the notary server is needed.
+func (repo *TrustedGcrRepository) KeyLocations() (map[data.RoleName]string, error) {
+	defer repo.lock()()
+	root, err := repo.publishedRoot(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	return keyLocations(notaryRepo.GetCryptoService(), root), nil
+}
+
+// AllRoleKeyIDs returns the sorted IDs of the keys trusted for the root,
+// targets, snapshot and timestamp roles and for every delegation, as
+// published by the notary server, e.g. to check a key rotation before and
+// after. Only read access to the notary server is needed.
+func (repo *TrustedGcrRepository) AllRoleKeyIDs() (map[data.RoleName][]string, error) {
+	defer repo.lock()()
+	root, err := repo.publishedRoot(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	delegations, err := listDelegations(repo.logger, notaryRepo, repo.ref.Context().Name())
+	if err != nil {
+		repo.logger.Errorf("failed to list delegations: %s", err)
+		return nil, err
+	}
+	return roleKeyIDs(root, delegations), nil
+}
+
+// publishedRoot returns the root metadata of the repository after updating it
+// from the notary server.
+func (repo *TrustedGcrRepository) publishedRoot(ctx context.Context) (*data.SignedRoot, error) {
+	if err := repo.updateMetadata(ctx); err != nil {
+		return nil, err
+	}
+	registry := repo.ref.Context().Registry
+	root, err := trust.GetCachedRoot(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Errorf("failed to read root metadata: %s", err)
+		return nil, err
+	}
+	return root, nil
+}
+
+// HasTrustData reports whether the notary server has published trust data
+// for the repository, without verifying any particular target. It returns
+// false and no error when the repository was never initialized, and an error
+// when the notary server cannot be reached or its answer cannot be trusted.
+// No signing key is needed.
+func (repo *TrustedGcrRepository) HasTrustData() (bool, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return false, err
+	}
+	_, err = notaryRepo.ListTargets()
+	switch err.(type) {
+	case nil:
+		return true, nil
+	case client.ErrRepositoryNotExist, client.ErrRepoNotInitialized:
+		return false, nil
+	default:
+		err = notaryError(repo.ref.Context().Name(), err)
+		repo.logger.Errorf("failed to look up trust data: %s", err)
+		return false, err
+	}
+}
+
+// RefreshMetadata drops the notary repository handle reused across calls and
+// fetches the TUF metadata of the repository from the notary server again,
+// e.g. when the caller knows that the trust data was changed elsewhere.
+func (repo *TrustedGcrRepository) RefreshMetadata() error {
+	defer repo.lock()()
+	repo.notary = nil
+	return repo.updateMetadata(context.Background())
+}
+
+// MetadataExpiries returns the expiry time of the root, targets, snapshot and
+// timestamp metadata of the repository, after updating it from the notary
+// server.
+func (repo *TrustedGcrRepository) MetadataExpiries() (map[data.RoleName]time.Time, error) {
+	defer repo.lock()()
+	if err := repo.updateMetadata(context.Background()); err != nil {
+		return nil, err
+	}
+	registry := repo.ref.Context().Registry
+	expiries, err := trust.GetMetadataExpiries(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Errorf("failed to read metadata expiries: %s", err)
+		return nil, err
+	}
+	return expiries, nil
+}
+
+// RefreshSignatures signs the targets metadata of the repository again and
+// publishes it, unless publishing is deferred, without adding or removing any
+// target. This bumps the versions of the targets, snapshot and timestamp
+// metadata and pushes out their expiry, e.g. from a scheduled job. The
+// targets key must be available locally.
+func (repo *TrustedGcrRepository) RefreshSignatures() error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	repoName := repo.ref.Context().Name()
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return stageRefresh(notaryRepo, repoName)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to refresh signatures: %s", err)
+		return err
+	}
+	repo.logger.Infof("Successfully refreshed signatures of %s\n", repoName)
+	return nil
+}
+
+// DryRunChanges returns the changes the last sign, push or revoke operation
+// would have published, in dry-run mode. Target changes carry the name of the
+// target as path and its hashes and length as content.
+func (repo *TrustedGcrRepository) DryRunChanges() []changelist.Change {
+	defer repo.lock()()
+	return repo.dryRunChanges
+}
+
+// PendingChanges returns the changes staged in the changelist of the notary
+// repository that have not been published yet. See ClearPendingChanges to
+// discard them.
+func (repo *TrustedGcrRepository) PendingChanges() ([]changelist.Change, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	changes, err := pendingChanges(notaryRepo)
+	if err != nil {
+		repo.logger.Errorf("failed to read changelist: %s", err)
+		return nil, err
+	}
+	return changes, nil
+}
+
+// ClearPendingChanges discards the changes staged in the changelist of the
+// notary repository without publishing them, e.g. those left over by a run
+// that crashed before publishing, which the next publish would otherwise
+// include. The changelist is kept on disk in the directory returned by
+// ChangelistDirectory.
+func (repo *TrustedGcrRepository) ClearPendingChanges() error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	if err := clearChangeList(notaryRepo); err != nil {
+		repo.logger.Errorf("failed to clear changelist: %s", err)
+		return err
+	}
+	return nil
+}
+
+// ChangelistDirectory returns the directory holding the changes staged for
+// the notary repository that have not been published yet, by default
+// trust/tuf/<gun>/changelist in the config directory.
+func (repo *TrustedGcrRepository) ChangelistDirectory() (string, error) {
+	registry := repo.ref.Context().Registry
+	return trust.ChangelistDirectory(repo.ref, &registry, repo.config)
+}
+
+// Publish publishes every change staged in the changelist of the notary
+// repository to the notary server at once. It is how changes staged under
+// WithDeferredPublish are flushed.
+func (repo *TrustedGcrRepository) Publish() error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	if err := notaryRepo.Publish(); err != nil {
+		err = notaryError(repo.ref.Context().Name(), err)
+		repo.logger.Errorf("failed to publish: %s", err)
+		return err
+	}
+	repo.forgetVerifications()
+	return nil
+}
+
+// ListTarget returns every signed target of the repository, including the
+// custom metadata attached to it.
+func (repo *TrustedGcrRepository) ListTarget() ([]*client.Target, error) {
+	return repo.ListTargetContext(context.Background())
+}
+
+// ListTargetContext is like ListTarget but aborts the notary calls when ctx is
+// done.
+func (repo *TrustedGcrRepository) ListTargetContext(ctx context.Context) ([]*client.Target, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	targets, err := listTargets(repo.logger, notaryRepo, repo.ref.Context().Name())
+	if err != nil {
+		repo.logger.Errorf("failed to list targets: %s", err)
+		return nil, err
+	}
+	return targets, nil
+}
+
+// MetaInfo describes the freshness of the trust data targets were listed from.
+type MetaInfo struct {
+	// SnapshotExpires and TimestampExpires are the expiry times of the
+	// snapshot and timestamp metadata.
+	SnapshotExpires  time.Time
+	TimestampExpires time.Time
+	// TargetsVersion is the version of the top level targets metadata and
+	// TimestampVersion that of the timestamp metadata, which every publish
+	// bumps.
+	TargetsVersion   int
+	TimestampVersion int
+}
+
+// ListTargetWithMeta is like ListTarget but also returns the expiry and
+// version of the metadata the targets were listed from, e.g. to show its
+// freshness along with the targets.
+func (repo *TrustedGcrRepository) ListTargetWithMeta() ([]*client.Target, MetaInfo, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, MetaInfo{}, err
+	}
+	targets, err := listTargets(repo.logger, notaryRepo, repo.ref.Context().Name())
+	if err != nil {
+		repo.logger.Errorf("failed to list targets: %s", err)
+		return nil, MetaInfo{}, err
+	}
+	// listing the targets updated the cached metadata
+	registry := repo.ref.Context().Registry
+	expiries, err := trust.GetMetadataExpiries(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Errorf("failed to read metadata expiries: %s", err)
+		return nil, MetaInfo{}, err
+	}
+	versions, err := trust.GetMetadataVersions(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Errorf("failed to read metadata versions: %s", err)
+		return nil, MetaInfo{}, err
+	}
+	return targets, MetaInfo{
+		SnapshotExpires:  expiries[data.CanonicalSnapshotRole],
+		TimestampExpires: expiries[data.CanonicalTimestampRole],
+		TargetsVersion:   versions[data.CanonicalTargetsRole],
+		TimestampVersion: versions[data.CanonicalTimestampRole],
+	}, nil
+}
+
+// ForEachTarget calls fn with every signed target of the repository, as
+// ListTarget returns them, and stops at the first error fn returns, which is
+// returned as is, e.g. to stop once a target was found. Notary has no
+// pagination, so the targets metadata is still downloaded as a whole, but no
+// list of the targets is built and nothing is logged per target. fn is called
+// without the repository lock held and may call the methods of repo.
+func (repo *TrustedGcrRepository) ForEachTarget(fn func(*client.Target) error) error {
+	unlock := repo.lock()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		unlock()
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	targets, err := notaryRepo.ListTargets()
+	unlock()
+	if err != nil {
+		err = notaryError(repo.ref.Context().Name(), err)
+		repo.logger.Errorf("failed to list targets: %s", err)
+		return err
+	}
+	return forEachTarget(targets, fn)
+}
+
+// ListTrustedTargets is like ListTarget but returns the targets as
+// TrustedTargets, which marshal to stable JSON, along with the role each was
+// resolved from.
+func (repo *TrustedGcrRepository) ListTrustedTargets() ([]*TrustedTarget, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	rawTargets, err := notaryRepo.ListTargets()
+	if err != nil {
+		repo.logger.Errorf("failed to list targets: %s", err)
+		return nil, notaryError(repo.ref.Context().Name(), err)
+	}
+	targets := make([]*TrustedTarget, 0, len(rawTargets))
+	for _, t := range rawTargets {
+		targets = append(targets, NewTrustedTarget(&t.Target, t.Role))
+	}
+	return targets, nil
+}
+
+// ListTargetsInRole returns the targets signed into role, e.g. the targets
+// role or a delegation such as targets/releases, without those of the
+// delegations below it. A role without targets yields an empty slice, and
+// ErrNoSuchDelegation is returned if the delegation does not exist.
+func (repo *TrustedGcrRepository) ListTargetsInRole(role data.RoleName) ([]*client.Target, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	targets, err := listRoleTargets(notaryRepo, repo.ref.Context().Name(), role)
+	if err != nil {
+		repo.logger.Errorf("failed to list targets of %s: %s", role, err)
+		return nil, err
+	}
+	return targets, nil
+}
+
+// TrustedTags returns the digest signed for each tag of the repository in the
+// top level targets role or the releases delegation role, i.e. the tags
+// VerifyTag trusts. Targets without a valid sha256 hash are left out.
+func (repo *TrustedGcrRepository) TrustedTags() (map[string]v1.Hash, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	targets, err := listTrustedTargets(notaryRepo, repo.ref.Context().Name())
+	if err != nil {
+		repo.logger.Errorf("failed to list targets: %s", err)
+		return nil, err
+	}
+	return tagDigests(repo.logger, targets), nil
+}
+
+// LatestSignedSemver returns the trusted tag that is the highest semantic
+// version, e.g. 1.10.0 over 1.9.2, and its target, e.g. to deploy the latest
+// release. Tags may carry a leading v, as in v1.2.3, and tags that are not
+// semantic versions are ignored, as are prerelease versions unless
+// WithPrereleases is used. ErrNoTrustData is returned when no such tag is
+// signed.
+func (repo *TrustedGcrRepository) LatestSignedSemver() (tag string, target *client.Target, err error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return "", nil, err
+	}
+	repoName := repo.ref.Context().Name()
+	targets, err := notaryRepo.ListTargets(trust.ReleasesRole, data.CanonicalTargetsRole)
+	if err != nil {
+		err = notaryError(repoName, err)
+		repo.logger.Errorf("failed to list targets: %s", err)
+		return "", nil, err
+	}
+	target = latestSemver(targets, repo.prereleases)
+	if target == nil {
+		return "", nil, errors.Wrapf(ErrNoTrustData, "no semantic version tag signed in %s", repoName)
+	}
+	return target.Name, target, nil
+}
+
+// UnsignedTags returns the tags of registryTags, e.g. those listed in the
+// registry, that have no trusted target and would fail verification, with a
+// single fetch of the trust data. Every tag is returned when the repository
+// has no trust data at all.
+func (repo *TrustedGcrRepository) UnsignedTags(registryTags []string) ([]string, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return nil, err
+	}
+	unsigned, err := unsignedTags(notaryRepo, repo.ref.Context().Name(), registryTags)
+	if err != nil {
+		repo.logger.Errorf("failed to look up unsigned tags: %s", err)
+		return nil, err
+	}
+	return unsigned, nil
+}
+
+// TrustDiff compares the targets cached in the trust directory with those the
+// notary server currently publishes: added are only on the server, removed
+// only in the cache, and changed are the server version of targets whose
+// digest or length differ. The server metadata is fetched afresh but not
+// written to the cache, which is left as it was for inspection.
+func (repo *TrustedGcrRepository) TrustDiff() (added, removed, changed []client.Target, err error) {
+	added, removed, changed, err = repo.trustDiff(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to diff trust data: %s", err)
+		return nil, nil, nil, err
+	}
+	return added, removed, changed, nil
+}
+
+func (repo *TrustedGcrRepository) TrustPush(img v1.Image) error {
+	return repo.TrustPushContext(context.Background(), img)
+}
+
+// TrustPushContext is like TrustPush but aborts both the registry upload and
+// the notary calls when ctx is done.
+func (repo *TrustedGcrRepository) TrustPushContext(ctx context.Context, img v1.Image) error {
+	defer repo.lock()()
+	_, err := repo.trustPush(ctx, img, nil)
+	return err
+}
+
+// TrustPushResult is like TrustPush but also returns the target that was
+// signed and published, with the tag as name and the hashes and length of
+// the image manifest.
+func (repo *TrustedGcrRepository) TrustPushResult(img v1.Image) (*client.Target, error) {
+	defer repo.lock()()
+	return repo.trustPush(context.Background(), img, nil)
+}
+
+// TrustPushDetailed is like TrustPush but also reports what the push
+// uploaded, e.g. to tell a new build from a re-push of an image the registry
+// already had, in which case nothing is uploaded.
+func (repo *TrustedGcrRepository) TrustPushDetailed(img v1.Image) (*PushResult, error) {
+	defer repo.lock()()
+	rec := &uploadRecorder{base: repo.registryTransport}
+	target, err := repo.trustPush(context.Background(), img, rec)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := targetDigest(target)
+	if err != nil {
+		return nil, err
+	}
+	return rec.result(digest, img)
+}
+
+// trustPush pushes and signs img under the tag of the reference. The
+// registry traffic of the push goes through rec, if set.
+func (repo *TrustedGcrRepository) trustPush(ctx context.Context, img v1.Image, rec *uploadRecorder) (_ *client.Target, err error) {
+	defer func(start time.Time) { repo.observer.ObservePush(time.Since(start), err) }(time.Now())
+	// If it is a trusted push we would like to find the target entry which match the
+	// tag provided in the function and then do an AddTarget later.
+	target, err := imageTarget(repo.logger, repo.ref.Identifier(), img)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.checkAutoInit(ctx); err != nil {
+		repo.logger.Errorf("failed to push image: %s", err)
+		return nil, err
+	}
+	// do not move an immutable tag in the registry either
+	if err := repo.checkImmutableTags(ctx, target); err != nil {
+		repo.logger.Errorf("failed to push image: %s", err)
+		return nil, err
+	}
+	err = repo.upload(ctx, repo.ref, func(options ...remote.Option) error {
+		if rec != nil {
+			options = append(options, remote.WithTransport(rec))
+		}
+		return pushImage(repo.logger, repo.ref, img, options...)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to push image: %s", err)
+		return nil, err
+	}
+	if err := repo.signTargets(ctx, target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// TrustPushAll pushes each of images to the tag it is keyed by in the
+// repository of the reference, uploading up to concurrency images at a time,
+// and then signs all the pushed images in a single notary publish. Images
+// that fail are left out and their errors are returned together as
+// TagErrors; the push progress callback may be called concurrently.
+func (repo *TrustedGcrRepository) TrustPushAll(images map[string]v1.Image, concurrency int) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObservePush(time.Since(start), err) }(time.Now())
+	if err := repo.trustPushAll(context.Background(), images, concurrency); err != nil {
+		repo.logger.Errorf("failed to push images: %s", err)
+		return err
+	}
+	return nil
+}
+
+// TrustPushIndex pushes the image index idx, e.g. a multi-arch manifest list,
+// and signs the index digest as the target for the tag of the reference.
+func (repo *TrustedGcrRepository) TrustPushIndex(idx v1.ImageIndex) error {
+	defer repo.lock()()
+	return repo.trustPushIndex(context.Background(), idx, false)
+}
+
+// TrustPushIndexWithChildren is like TrustPushIndex but additionally signs the
+// digest of every child manifest of idx, so that each platform image can be
+// verified on its own with VerifyDigest.
+func (repo *TrustedGcrRepository) TrustPushIndexWithChildren(idx v1.ImageIndex) error {
+	defer repo.lock()()
+	return repo.trustPushIndex(context.Background(), idx, true)
+}
+
+func (repo *TrustedGcrRepository) trustPushIndex(ctx context.Context, idx v1.ImageIndex, withChildren bool) (err error) {
+	defer func(start time.Time) { repo.observer.ObservePush(time.Since(start), err) }(time.Now())
+	targets, err := indexTargets(repo.logger, repo.ref, idx, withChildren)
+	if err != nil {
+		repo.logger.Errorf("failed to compute index targets: %s", err)
+		return err
+	}
+	if err := repo.checkAutoInit(ctx); err != nil {
+		repo.logger.Errorf("failed to push index: %s", err)
+		return err
+	}
+	if err := repo.checkImmutableTags(ctx, targets...); err != nil {
+		repo.logger.Errorf("failed to push index: %s", err)
+		return err
+	}
+	err = repo.upload(ctx, repo.ref, func(options ...remote.Option) error {
+		return pushIndex(repo.logger, repo.ref, idx, options...)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to push index: %s", err)
+		return err
+	}
+	return repo.signTargets(ctx, targets...)
+}
+
+// Verify returns the trusted target of the tag of the reference, including
+// the custom metadata attached to it.
+func (repo *TrustedGcrRepository) Verify() (*client.Target, error) {
+	return repo.VerifyContext(context.Background())
+}
+
+// VerifyContext is like Verify but aborts the notary calls when ctx is done.
+func (repo *TrustedGcrRepository) VerifyContext(ctx context.Context) (*client.Target, error) {
+	defer repo.lock()()
+	tag, err := name.NewTag(repo.ref.String(), name.StrictValidation)
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "couldn't parse tag from repository name")
+	}
+	return repo.verifyTag(ctx, tag.Identifier())
+}
+
+// VerifyTrustedTarget is like Verify but returns the trusted target as a
+// TrustedTarget, which marshals to stable JSON, along with the role it was
+// resolved from.
+func (repo *TrustedGcrRepository) VerifyTrustedTarget() (*TrustedTarget, error) {
+	defer repo.lock()()
+	tag, err := name.NewTag(repo.ref.String(), name.StrictValidation)
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "couldn't parse tag from repository name")
+	}
+	target, err := repo.verifyTagWithRole(context.Background(), tag.Identifier())
+	if err != nil {
+		return nil, err
+	}
+	return NewTrustedTarget(&target.Target, target.Role), nil
+}
+
+// VerifyOffline is like Verify but reads the trust data solely from the local
+// cache in the trust directory, without any network call. The cached TUF
+// metadata is still verified, and ErrExpiredMetadata is returned when the
+// cached snapshot or timestamp has expired.
+func (repo *TrustedGcrRepository) VerifyOffline() (verified *client.Target, err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveVerify(time.Since(start), err) }(time.Now())
+	tag, err := name.NewTag(repo.ref.String(), name.StrictValidation)
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "couldn't parse tag from repository name")
+	}
+	defer func() { repo.notifyVerify(tag.Identifier(), verified, err) }()
+	registry := repo.ref.Context().Registry
+	notaryRepo, err := trust.GetOfflineNotaryRepository(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "error opening local trust data")
+	}
+	target, err := getTrustedTarget(repo.logger, notaryRepo, repo.ref.Context().Name(), tag.Identifier())
+	if err == nil {
+		err = repo.checkRootPin()
+	}
+	if err == nil {
+		err = repo.checkRequiredSigners(notaryRepo, target)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository offline: %s", err)
+		return nil, err
+	}
+	return target, nil
+}
+
+// VerifyTag returns the trusted target of tag in the repository of the
+// reference. The notary repository is set up once and reused by later calls,
+// so verifying many tags of one repository does not repeat the setup cost.
+// ErrNoTrustData is returned when tag is not signed.
+func (repo *TrustedGcrRepository) VerifyTag(tag string) (*client.Target, error) {
+	defer repo.lock()()
+	return repo.verifyTag(context.Background(), tag)
+}
+
+// VerifyTagIgnoringExpiry is like VerifyTag, but with WithIgnoreExpiry it
+// still returns the target of tag when the trust data has expired, verified
+// against the root the cache holds, and reports so with expired. It is meant
+// for reconstructing what was trusted at a past point: an expired target must
+// not be trusted to run. Without WithIgnoreExpiry it fails like VerifyTag.
+func (repo *TrustedGcrRepository) VerifyTagIgnoringExpiry(tag string) (_ *client.Target, expired bool, err error) {
+	defer repo.lock()()
+	target, err := repo.verifyTag(context.Background(), tag)
+	if !repo.ignoreExpiry || !errors.Is(err, ErrExpiredMetadata) {
+		return target, false, err
+	}
+	log := trust.WithFields(repo.logger, map[string]interface{}{"tag": tag})
+	log.Warnf("UNSAFE: ignoring the expiry of the trust data of %s: %s", repo.ref.Context().Name(), err)
+	registry := repo.ref.Context().Registry
+	t, expired, err := trust.GetTargetIgnoringExpiry(context.Background(), repo.ref, repo.notaryAuth, &registry, repo.config, tag, trust.ReleasesRole, data.CanonicalTargetsRole)
+	if err == nil && t.Role != trust.ReleasesRole && t.Role != data.CanonicalTargetsRole {
+		err = client.ErrNoSuchTarget(tag)
+	}
+	if err != nil {
+		if _, ok := err.(client.ErrNoSuchTarget); ok {
+			err = errors.Wrapf(ErrNoTrustData, "%s:%s", repo.ref.Context().Name(), tag)
+		} else {
+			err = notaryError(repo.ref.Context().Name(), err)
+		}
+		log.Errorf("failed to read expired trust data: %s", err)
+		return nil, false, err
+	}
+	if expired {
+		repo.targetLogger(&t.Target).Warnf("UNSAFE: %s is signed by %s in EXPIRED trust data", tag, t.Role)
+	}
+	return &t.Target, expired, nil
+}
+
+// VerifyAtVersion returns the target of tag as of version targetsVersion of
+// the top level targets metadata, rather than the latest, e.g. to prove what
+// was trusted at the time of a past deployment. Like VerifyTag, the releases
+// delegation takes precedence over the top level targets role; it is read as
+// listed by the last snapshot listing that targets version. The notary server
+// must still have the metadata needed; ErrVersionNotAvailable is returned
+// otherwise, and ErrNoTrustData when tag is not signed in it. The metadata is
+// verified against the cached root, which must match the pinned root keys,
+// and must be signed by the required signers, if any, but its expiry is not
+// checked, as past versions have usually expired: the target must not be
+// trusted to run.
+func (repo *TrustedGcrRepository) VerifyAtVersion(tag string, targetsVersion int) (verified *client.Target, err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveVerify(time.Since(start), err) }(time.Now())
+	defer func() { repo.notifyVerify(tag, verified, err) }()
+	if targetsVersion < 1 {
+		return nil, errors.Errorf("invalid targets metadata version %d", targetsVersion)
+	}
+	log := trust.WithFields(repo.logger, map[string]interface{}{"tag": tag, "version": targetsVersion})
+	registry := repo.ref.Context().Registry
+	repoName := repo.ref.Context().Name()
+	t, root, err := trust.GetTargetAtVersion(context.Background(), repo.ref, repo.notaryAuth, &registry, repo.config, tag, targetsVersion)
+	if err != nil {
+		switch err.(type) {
+		case client.ErrNoSuchTarget:
+			err = errors.Wrapf(ErrNoTrustData, "%s:%s", repoName, tag)
+		case storage.ErrMetaNotFound:
+			err = errors.Wrapf(ErrVersionNotAvailable, "%s: version %d", repoName, targetsVersion)
+		default:
+			err = notaryError(repoName, err)
+		}
+		log.Errorf("failed to verify at version: %s", err)
+		return nil, err
+	}
+	if t.Role.Name != trust.ReleasesRole && t.Role.Name != data.CanonicalTargetsRole {
+		err = errors.Wrapf(ErrNoTrustData, "%s:%s", repoName, tag)
+		log.Errorf("failed to verify at version: %s", err)
+		return nil, err
+	}
+	if err = repo.checkRootPinOf(root); err != nil {
+		log.Errorf("failed to verify at version: %s", err)
+		return nil, err
+	}
+	if len(repo.requiredSigners) > 0 {
+		if err = checkSignedBy([]client.TargetSignedStruct{*t}, repoName, &t.Target, repo.requiredSigners); err != nil {
+			log.Errorf("failed to verify at version: %s", err)
+			return nil, err
+		}
+	}
+	return &t.Target, nil
+}
+
+// TrustedDigest returns the sha256 digest signed for tag, e.g. to rewrite
+// repo:tag into repo@sha256:... before deployment. ErrNoTrustData is returned
+// when tag is not signed.
+func (repo *TrustedGcrRepository) TrustedDigest(tag string) (v1.Hash, error) {
+	defer repo.lock()()
+	target, err := repo.verifyTag(context.Background(), tag)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	digest, err := targetDigest(target)
+	if err != nil {
+		repo.logger.Errorf("failed to get trusted digest: %s", err)
+		return v1.Hash{}, err
+	}
+	return digest, nil
+}
+
+// PinnedReference verifies tag and returns the repository of the reference
+// pinned to the trusted digest, as in gcr.io/project/image@sha256:..., to hand
+// to a container runtime in place of the mutable tag. The registry and
+// repository are kept as parsed from the reference. ErrNoTrustData is returned
+// when tag is not signed.
+func (repo *TrustedGcrRepository) PinnedReference(tag string) (string, error) {
+	defer repo.lock()()
+	target, err := repo.verifyTag(context.Background(), tag)
+	if err != nil {
+		return "", err
+	}
+	digest, err := targetDigest(target)
+	if err != nil {
+		repo.logger.Errorf("failed to get trusted digest: %s", err)
+		return "", err
+	}
+	return pinnedReference(repo.ref.Context(), digest), nil
+}
+
+// pinnedReference returns the reference to digest in repository.
+func pinnedReference(repository name.Repository, digest v1.Hash) string {
+	return repository.Digest(digest.String()).String()
+}
+
+// VerifyImage verifies tag in the repository of the reference and fetches the
+// image with the trusted digest from the registry, so that it is returned only
+// if it is both signed and served. ErrNoTrustData is returned when tag is not
+// signed, ErrDigestNotInRegistry when the registry does not serve the
+// trusted digest, and ErrSizeMismatch when the manifest it serves is not as
+// long as the signed target.
+func (repo *TrustedGcrRepository) VerifyImage(tag string) (v1.Image, error) {
+	defer repo.lock()()
+	return repo.verifyImage(tag)
+}
+
+// PullTrusted is like VerifyImage but also downloads the config and every
+// layer of the trusted image, each verified against its digest, and returns
+// the image served from memory, ready to be pushed elsewhere or inspected
+// without another registry request. The image is always fetched by its
+// trusted digest, never by tag, so that the tag cannot be moved between
+// verification and fetch. The whole image is held in memory.
+func (repo *TrustedGcrRepository) PullTrusted(tag string) (v1.Image, error) {
+	defer repo.lock()()
+	img, err := repo.verifyImage(tag)
+	if err != nil {
+		return nil, err
+	}
+	pulled, err := pullImage(img)
+	if err != nil {
+		repo.logger.Errorf("failed to pull trusted image: %s", err)
+		return nil, err
+	}
+	return pulled, nil
+}
+
+func (repo *TrustedGcrRepository) verifyImage(tag string) (v1.Image, error) {
+	target, err := repo.verifyTag(context.Background(), tag)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := targetDigest(target)
+	if err != nil {
+		repo.logger.Errorf("failed to get trusted digest: %s", err)
+		return nil, err
+	}
+	img, err := fetchImage(repo.ref.Context(), digest, repo.remoteOptions(context.Background())...)
+	if err != nil {
+		repo.logger.Errorf("failed to fetch trusted image: %s", err)
+		return nil, err
+	}
+	if err := checkManifestSize(img, target); err != nil {
+		repo.logger.Errorf("failed to verify trusted image: %s", err)
+		return nil, err
+	}
+	return img, nil
+}
+
+// PingNotary checks that the notary server of the repository is reachable and
+// accepts the notary credentials, with a single authenticated request for the
+// timestamp metadata and without caching anything, e.g. to delay a batch while
+// the trust infrastructure is down. The error wraps ErrNotaryUnreachable,
+// ErrNotaryUnauthorized or ErrNotaryServerError depending on what failed. A
+// repository without trust data is not an error.
+func (repo *TrustedGcrRepository) PingNotary(ctx context.Context) error {
+	registry := repo.ref.Context().Registry
+	err := trust.PingNotary(ctx, repo.ref, repo.notaryAuth, &registry, repo.config)
+	if err != nil {
+		err = pingError(err)
+		repo.logger.Errorf("failed to ping notary server: %s", err)
+		return err
+	}
+	return nil
+}
+
+// GUN returns the notary GUN the trust data of the repository of the
+// reference is published under, e.g. docker.io/library/alpine on the Docker
+// Hub notary server, as used by every sign and verify call.
+func (repo *TrustedGcrRepository) GUN() (data.GUN, error) {
+	registry := repo.ref.Context().Registry
+	return trust.GUN(repo.ref, &registry, repo.config)
+}
+
+// RegistryDigest returns the digest of the manifest tag currently points to in
+// the registry, regardless of what is signed, e.g. to compare it with
+// TrustedDigest and detect a tag that was moved without being signed.
+func (repo *TrustedGcrRepository) RegistryDigest(tag string) (v1.Hash, error) {
+	ref, err := name.NewTag(repo.ref.Context().String()+":"+tag, name.StrictValidation)
+	if err != nil {
+		repo.logger.Errorf("failed to get registry digest: %s", err)
+		return v1.Hash{}, errors.Wrap(err, "couldn't parse tag")
+	}
+	desc, err := remote.Head(ref, repo.remoteOptions(context.Background())...)
+	if err != nil {
+		repo.logger.Errorf("failed to get registry digest of %s: %s", ref, err)
+		return v1.Hash{}, err
+	}
+	return desc.Digest, nil
+}
+
+// AuditTargets recomputes the digest of every signed tag from the registry,
+// with a HEAD request per tag, and returns the tags whose registry manifest is
+// not the signed one or is missing, sorted by tag. Targets without a valid
+// sha256 hash are left out, as in TrustedTags. An empty slice means every
+// signed tag matches the registry.
+func (repo *TrustedGcrRepository) AuditTargets() ([]TargetMismatch, error) {
+	tags, err := repo.TrustedTags()
+	if err != nil {
+		return nil, err
+	}
+	options := repo.remoteOptions(context.Background())
+	mismatches, err := auditTags(repo.ref.Context(), tags, func(ref name.Reference) (v1.Hash, error) {
+		desc, err := remote.Head(ref, options...)
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		return desc.Digest, nil
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to audit targets: %s", err)
+		return nil, err
+	}
+	for _, m := range mismatches {
+		if m.Missing {
+			repo.logger.Warnf("tag %s is signed as %s but missing from the registry", m.Tag, m.Signed)
+			continue
+		}
+		repo.logger.Warnf("tag %s is signed as %s but the registry serves %s", m.Tag, m.Signed, m.Actual)
+	}
+	return mismatches, nil
+}
+
+// VerifyWithRoles is like VerifyTag but also returns every trusted role that
+// signed the trusted target, the top level targets role, targets/releases or
+// a delegation below it, so that a target signed only by the top level
+// targets role can be told apart from one signed by a delegation. Other
+// delegations are not trusted and never returned.
+func (repo *TrustedGcrRepository) VerifyWithRoles(tag string) (*client.Target, []data.RoleName, error) {
+	defer repo.lock()()
+	target, err := repo.verifyTag(context.Background(), tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a target verified from the cache leaves the notary repository unset
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, nil, errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	roles, err := getSigningRoles(notaryRepo, repo.ref.Context().Name(), tag, target)
+	if err != nil {
+		repo.logger.Errorf("failed to get signing roles: %s", err)
+		return nil, nil, err
+	}
+	return target, roles, nil
+}
+
+// VerifyMerged is like VerifyTag but returns the trusted target as signed by
+// the most specific role, so that custom data recorded by a delegation, e.g.
+// provenance, is returned rather than that of the top level targets role.
+// The trusted digest is resolved as by VerifyTag, then among the trusted
+// roles, the top level targets role, targets/releases and the delegations
+// below it, that signed that same digest under the name of tag the
+// precedence is:
+//
+//  1. the deepest delegation, e.g. targets/releases/ci over targets/releases
+//  2. among delegations of the same depth, the first in notary's walk order,
+//     that is the order their parent delegates to them
+//  3. the top level targets role
+//
+// This is the reverse of notary, which returns the first role of its walk,
+// starting at the top level targets role, and so would never return the
+// custom data of a delegation when the targets role signs the tag too.
+func (repo *TrustedGcrRepository) VerifyMerged(tag string) (*client.Target, error) {
+	defer repo.lock()()
+	target, err := repo.verifyTag(context.Background(), tag)
+	if err != nil {
+		return nil, err
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	signed, err := notaryRepo.GetAllTargetMetadataByName(tag)
+	if err != nil {
+		err = notaryError(repo.ref.Context().Name(), err)
+		repo.logger.Errorf("failed to get signed targets: %s", err)
+		return nil, err
+	}
+	return mostSpecificTarget(signed, target), nil
+}
+
+// VerifyWithThreshold is like VerifyTag but fails with ErrThresholdNotMet
+// unless at least threshold distinct keys of role, e.g. targets/releases,
+// signed the trusted target. Only the keys whose signatures are present on
+// the metadata of role are counted, regardless of the threshold configured
+// for the role.
+func (repo *TrustedGcrRepository) VerifyWithThreshold(tag string, role data.RoleName, threshold int) (*client.Target, error) {
+	defer repo.lock()()
+	if threshold < 1 {
+		return nil, errors.Errorf("invalid signature threshold %d", threshold)
+	}
+	target, err := repo.verifyTag(context.Background(), tag)
+	if err != nil {
+		return nil, err
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	if err := checkThreshold(notaryRepo, repo.ref.Context().Name(), tag, role, threshold, target); err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, err
+	}
+	return target, nil
+}
+
+// VerifyDigest returns the signed target whose hash matches digest, such as
+// the digest of a reference pinned with repo@sha256:... ErrDigestNotSigned
+// is returned when no signed target matches.
+func (repo *TrustedGcrRepository) VerifyDigest(digest v1.Hash) (*client.Target, error) {
+	defer repo.lock()()
+	return repo.verifyDigest(context.Background(), digest)
+}
+
+func (repo *TrustedGcrRepository) verifyDigest(ctx context.Context, digest v1.Hash) (verified *client.Target, err error) {
+	defer func(start time.Time) { repo.observer.ObserveVerify(time.Since(start), err) }(time.Now())
+	defer func() { repo.notifyVerify(digest.String(), verified, err) }()
+	if cached, ok := repo.cachedVerification("", digest.String()); ok {
+		return &cached.Target, nil
+	}
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		repo.logger.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	defer repo.watchRootKey()()
+	target, err := getTrustedTargetByDigest(repo.logger, notaryRepo, repo.ref.Context().Name(), digest)
+	if err == nil {
+		err = repo.checkRootPin()
+	}
+	if err == nil {
+		err = repo.checkRequiredSigners(notaryRepo, target)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to verify digest: %s", err)
+		return nil, err
+	}
+	repo.cacheVerification("", digest.String(), &client.TargetWithRole{Target: *target})
+	return target, nil
+}
+
+// IsRevoked reports whether digest is no longer trusted, i.e. no target of the
+// top level targets role or the releases delegation maps to it under any tag.
+// A digest stays trusted until every tag signed with it is revoked, and none
+// is trusted in a repository without trust data.
+func (repo *TrustedGcrRepository) IsRevoked(digest v1.Hash) (bool, error) {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return false, err
+	}
+	_, err = getTrustedTargetByDigest(repo.logger, notaryRepo, repo.ref.Context().Name(), digest)
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.Is(err, ErrDigestNotSigned), errors.Is(err, ErrUninitialized):
+		return true, nil
+	default:
+		repo.logger.Errorf("failed to check digest: %s", err)
+		return false, err
+	}
+}
+
+func (repo *TrustedGcrRepository) verifyTag(ctx context.Context, tag string) (*client.Target, error) {
+	target, err := repo.verifyTagWithRole(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &target.Target, nil
+}
+
+// verifyTagWithRole is like verifyTag but also returns the role the trusted
+// target was resolved from.
+func (repo *TrustedGcrRepository) verifyTagWithRole(ctx context.Context, tag string) (verified *client.TargetWithRole, err error) {
+	defer func(start time.Time) { repo.observer.ObserveVerify(time.Since(start), err) }(time.Now())
+	defer func() {
+		var target *client.Target
+		if verified != nil {
+			target = &verified.Target
+		}
+		repo.notifyVerify(tag, target, err)
+	}()
+	if target, ok := repo.cachedVerification(tag, ""); ok {
+		return target, nil
+	}
+	if repo.maxStaleness > 0 {
+		if target, ok := repo.verifyCachedTag(tag); ok {
+			return target, nil
+		}
+	}
+	log := trust.WithFields(repo.logger, map[string]interface{}{"tag": tag})
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		log.Errorf("failed to verify repository: %s", err)
+		return nil, errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	defer repo.watchRootKey()()
+	target, err := getTrustedTargetWithRole(log, notaryRepo, repo.ref.Context().Name(), tag)
+	if err == nil {
+		err = repo.checkRootPin()
+	}
+	if err == nil {
+		err = repo.checkRequiredSigners(notaryRepo, &target.Target)
+	}
+	if err != nil {
+		log.Errorf("failed to verify repository: %s", err)
+		return nil, err
+	}
+	if repo.expiryWarning > 0 {
+		repo.warnExpiringMetadata(time.Now())
+	}
+	repo.cacheVerification(tag, "", target)
+	repo.targetLogger(&target.Target).Debugf("verified %s signed by %s", tag, target.Role)
+	return target, nil
+}
+
+// notifyVerify passes the outcome of verifying tag to the verify hook, if any.
+func (repo *TrustedGcrRepository) notifyVerify(tag string, target *client.Target, err error) {
+	if repo.verifyHook != nil {
+		repo.verifyHook(tag, target, err)
+	}
+}
+
+// verifyCachedTag returns the trusted target of tag from the cached metadata,
+// without any network call, if it was fetched within the maximum staleness
+// and verifies. Otherwise it reports false, and the metadata must be fetched
+// from the notary server.
+func (repo *TrustedGcrRepository) verifyCachedTag(tag string) (*client.TargetWithRole, bool) {
+	registry := repo.ref.Context().Registry
+	age, err := trust.GetCacheAge(repo.ref, &registry, repo.config)
+	if err != nil || age > repo.maxStaleness {
+		return nil, false
+	}
+	notaryRepo, err := trust.GetOfflineNotaryRepository(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Debugf("failed to open cached trust data: %s", err)
+		return nil, false
+	}
+	target, err := getTrustedTargetWithRole(repo.logger, notaryRepo, repo.ref.Context().Name(), tag)
+	if err == nil {
+		err = repo.checkRootPin()
+	}
+	if err == nil {
+		err = repo.checkRequiredSigners(notaryRepo, &target.Target)
+	}
+	if err != nil {
+		repo.logger.Debugf("cached trust data of %s fetched %s ago does not verify, fetching it again: %s", tag, age, err)
+		return nil, false
+	}
+	repo.logger.Debugf("verified %s with trust data fetched %s ago", tag, age)
+	return target, true
+}
+
+// watchRootKey records the root key of the cached root metadata and returns
+// a function calling the root change hook, if any, when the root key of the
+// cached root metadata is then another one, e.g. after notary fetched a
+// rotated root during verification.
+func (repo *TrustedGcrRepository) watchRootKey() func() {
+	if repo.rootChangeHook == nil {
+		return func() {}
+	}
+	oldKeyID := repo.cachedRootKeyID()
+	return func() {
+		if oldKeyID == "" {
+			// nothing was seen before
+			return
+		}
+		if newKeyID := repo.cachedRootKeyID(); newKeyID != "" && newKeyID != oldKeyID {
+			repo.logger.Warnf("root key of %s changed from %s to %s", repo.ref.Context().Name(), oldKeyID, newKeyID)
+			repo.rootChangeHook(oldKeyID, newKeyID)
+		}
+	}
+}
+
+// cachedRootKeyID returns the ID of the root key of the cached root metadata,
+// as returned by RootKeyInfo, or an empty string if none is cached.
+func (repo *TrustedGcrRepository) cachedRootKeyID() string {
+	registry := repo.ref.Context().Registry
+	root, err := trust.GetCachedRoot(repo.ref, &registry, repo.config)
+	if err != nil {
+		return ""
+	}
+	key, err := rootKey(root)
+	if err != nil {
+		return ""
+	}
+	return key.ID()
+}
+
+// checkRequiredSigners returns ErrRequiredSignerMissing unless one of the
+// required signer keys, if any, validly signed target in notaryRepo.
+func (repo *TrustedGcrRepository) checkRequiredSigners(notaryRepo client.Repository, target *client.Target) error {
+	if len(repo.requiredSigners) == 0 {
+		return nil
+	}
+	return checkRequiredSigners(notaryRepo, repo.ref.Context().Name(), target, repo.requiredSigners)
+}
+
+// checkRootPin returns ErrRootKeyMismatch if the root key of the cached root
+// metadata, just verified by notary, is not the pinned one.
+func (repo *TrustedGcrRepository) checkRootPin() error {
+	if len(repo.pinnedRoots) == 0 && repo.rootPinStore == "" {
+		return nil
+	}
+	registry := repo.ref.Context().Registry
+	root, err := trust.GetCachedRoot(repo.ref, &registry, repo.config)
+	if err != nil {
+		return errors.Wrap(err, "error reading root metadata")
+	}
+	return repo.checkRootPinOf(root)
+}
+
+// checkRootPinOf returns ErrRootKeyMismatch if the root key of root, just
+// verified against, is not the pinned one.
+func (repo *TrustedGcrRepository) checkRootPinOf(root *data.SignedRoot) error {
+	if len(repo.pinnedRoots) == 0 && repo.rootPinStore == "" {
+		return nil
+	}
+	gun := repo.ref.Context().Name()
+	if len(repo.pinnedRoots) > 0 {
+		if err := checkPinnedRoot(root, gun, repo.pinnedRoots...); err != nil {
+			return err
+		}
+	}
+	if repo.rootPinStore != "" {
+		return pinRootOnFirstUse(root, gun, repo.rootPinStore)
+	}
+	return nil
+}
+
+// warnExpiringMetadata logs a warning for every base role whose cached
+// metadata expires within the expiry warning window from now.
+func (repo *TrustedGcrRepository) warnExpiringMetadata(now time.Time) {
+	registry := repo.ref.Context().Registry
+	expiries, err := trust.GetMetadataExpiries(repo.ref, &registry, repo.config)
+	if err != nil {
+		repo.logger.Debugf("failed to read metadata expiries: %s", err)
+		return
+	}
+	for _, role := range trust.BaseRoles {
+		if expires, ok := expiries[role]; ok && expires.Before(now.Add(repo.expiryWarning)) {
+			repo.logger.Warnf("%s metadata of %s expires at %s\n", role, repo.ref.Context().Name(), expires.Format(time.RFC3339))
+		}
+	}
+}
+
+func (repo *TrustedGcrRepository) SignImage(img v1.Image) error {
+	return repo.SignImageContext(context.Background(), img)
+}
+
+// SignImageContext is like SignImage but aborts the notary calls when ctx is
+// done.
+func (repo *TrustedGcrRepository) SignImageContext(ctx context.Context, img v1.Image) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveSign(time.Since(start), err) }(time.Now())
+	target, err := imageTarget(repo.logger, repo.ref.Identifier(), img)
+	if err == nil {
+		err = repo.signTargets(ctx, target)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to sign image: %s", err)
+		return err
+	}
+	return nil
+}
+
+// ComputeTarget returns the target that signing img under tag would publish,
+// with the digest and length of its manifest, without any network call. It
+// allows checking what is about to be signed before pushing.
+func (repo *TrustedGcrRepository) ComputeTarget(img v1.Image, tag string) (*client.Target, error) {
+	targets, err := imageTargets(repo.logger, repo.ref, img, []string{tag})
+	if err != nil {
+		repo.logger.Errorf("failed to compute target: %s", err)
+		return nil, err
+	}
+	return targets[0], nil
+}
+
+// SignImageWithCustom is like SignImage but attaches custom, e.g. build
+// provenance or a pointer to an attestation, to the signed target. The custom
+// data is returned unchanged in the Custom field of the targets returned by
+// Verify and ListTarget.
+func (repo *TrustedGcrRepository) SignImageWithCustom(img v1.Image, custom json.RawMessage) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveSign(time.Since(start), err) }(time.Now())
+	target, err := imageTarget(repo.logger, repo.ref.Identifier(), img)
+	if err == nil {
+		err = setTargetCustom(target, custom)
+	}
+	if err == nil {
+		err = repo.signTargets(context.Background(), target)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to sign image: %s", err)
+		return err
+	}
+	return nil
+}
+
+// SignImageToRole signs img under the tag of the reference into the
+// delegation role, e.g. targets/releases, instead of the top level targets
+// role, and publishes it unless publishing is deferred. The delegation must
+// exist, allow the tag and have one of its keys in the local key store.
+// ErrPathNotAllowed is returned when the paths of the delegation do not
+// cover the tag.
+func (repo *TrustedGcrRepository) SignImageToRole(img v1.Image, role data.RoleName) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveSign(time.Since(start), err) }(time.Now())
+	target, err := imageTarget(repo.logger, repo.ref.Identifier(), img)
+	if err != nil {
+		repo.logger.Errorf("failed to sign image: %s", err)
+		return err
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository %s", err)
+		return err
+	}
+	repoName := repo.ref.Context().Name()
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		if repo.immutableTags {
+			if err := checkTargetsUnchanged(notaryRepo, repoName, target); err != nil {
+				return err
+			}
+		}
+		return stageTargetToRole(notaryRepo, repoName, role, target)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to sign image into %s: %s", role, err)
+		return err
+	}
+	repo.logger.Infof("Successfully signed %s:%s into %s\n", repoName, target.Name, role)
+	return nil
+}
+
+// SignImageTags signs img under each of tags, e.g. v1.2.3, v1.2 and latest,
+// and publishes all the targets at once. Nothing is published if any of the
+// tags fails to be staged.
+func (repo *TrustedGcrRepository) SignImageTags(img v1.Image, tags []string) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveSign(time.Since(start), err) }(time.Now())
+	targets, err := imageTargets(repo.logger, repo.ref, img, tags)
+	if err == nil {
+		err = repo.signTargets(context.Background(), targets...)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to sign image tags: %s", err)
+		return err
+	}
+	return nil
+}
+
+// SignImageWithPlatform signs img under tag, recording platform in the custom
+// metadata of the target as described by PlatformMetadata, and publishes it
+// unless publishing is deferred. Verifiers of multi-arch images can then pick
+// the target of their platform with FilterTargetsByPlatform.
+func (repo *TrustedGcrRepository) SignImageWithPlatform(img v1.Image, platform v1.Platform, tag string) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveSign(time.Since(start), err) }(time.Now())
+	targets, err := imageTargets(repo.logger, repo.ref, img, []string{tag})
+	var custom json.RawMessage
+	if err == nil {
+		custom, err = platformCustom(platform)
+	}
+	if err == nil {
+		err = setTargetCustom(targets[0], custom)
+	}
+	if err == nil {
+		err = repo.signTargets(context.Background(), targets...)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to sign image for %s/%s: %s", platform.OS, platform.Architecture, err)
+		return err
+	}
+	return nil
+}
+
+// SignDigest signs the manifest with the given digest and size under tag,
+// without fetching the image, and publishes it unless publishing is deferred.
+// The digest must be a sha256 one, as that is what targets carry, and size,
+// the length of the manifest in bytes, must be positive. A wrong size is not
+// detected here, but VerifyImage rejects the manifest with ErrSizeMismatch.
+func (repo *TrustedGcrRepository) SignDigest(digest v1.Hash, size int64, tag string) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveSign(time.Since(start), err) }(time.Now())
+	target, err := digestTarget(repo.logger, repo.ref, digest, size, tag)
+	if err == nil {
+		err = repo.signTargets(context.Background(), target)
+	}
+	if err != nil {
+		repo.logger.Errorf("failed to sign digest: %s", err)
+		return err
+	}
+	return nil
+}
+
+// CopyTrustedImage verifies the tag of the reference, copies the signed image
+// or index to dstRef and signs the same digest under the tag of dstRef in the
+// notary repository of the destination, initializing it if needed. Nothing is
+// copied if the source fails verification. The destination signature is
+// always published at once, even with WithDeferredPublish.
+func (repo *TrustedGcrRepository) CopyTrustedImage(dstRef name.Reference, dstRegistryAuth, dstNotaryAuth authn.Authenticator) error {
+	defer repo.lock()()
+	dst := repo.forReference(dstRef, dstRegistryAuth, dstNotaryAuth)
+	// nothing could publish the changes staged for the destination later on
+	dst.deferPublish = false
+	if err := repo.copyTrustedImage(context.Background(), dst); err != nil {
+		repo.logger.Errorf("failed to copy trusted image to %s: %s", dstRef, err)
+		return err
+	}
+	return nil
+}
+
+// ReplicateTrust publishes the trust data of the repository, that is its
+// delegations and the targets signed by each role, to the notary server at
+// dstServer for the same image, e.g. a disaster recovery mirror, without
+// copying any image. The destination is accessed with dstNotaryAuth and
+// initialized, if it has no trust data yet, with keys of its own, so its
+// targets and delegations are signed anew: the delegation keys of every
+// delegation holding targets must be in the local key store. The trust data
+// of the destination is cached in a temporary directory, leaving the cache
+// of the repository alone.
+func (repo *TrustedGcrRepository) ReplicateTrust(dstNotaryAuth authn.Authenticator, dstServer string) error {
+	defer repo.lock()()
+	dst, err := repo.ForTrustServer(dstServer)
+	if err != nil {
+		return err
+	}
+	dst.notaryAuth = dstNotaryAuth
+	// nothing could publish the changes staged for the destination later on
+	dst.deferPublish = false
+	cacheDir, err := ioutil.TempDir("", "notary-gcr-replica")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(cacheDir)
+	dst.config.CacheDir = cacheDir
+
+	repoName := repo.ref.Context().Name()
+	src, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository %s", err)
+		return err
+	}
+	if cl, err := src.GetChangelist(); err != nil {
+		return err
+	} else if len(cl.List()) > 0 {
+		return errors.Errorf("%s has unpublished changes, publish them before replicating its trust data", repoName)
+	}
+	dstRepo, err := dst.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository of %s: %s", dstServer, err)
+		return err
+	}
+	var replicated int
+	err = dst.stageAndPublish(dstRepo, func() error {
+		replicated, err = replicateTrust(repo.logger, src, dstRepo, repoName, repo.serverManagedRoles)
+		return err
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to replicate trust data to %s: %s", dstServer, err)
+		return err
+	}
+	repo.logger.Infof("Successfully replicated %d targets of %s to %s\n", replicated, repoName, dstServer)
+	return nil
+}
+
+func (repo *TrustedGcrRepository) RevokeTag(tag string) error {
+	return repo.RevokeTagContext(context.Background(), tag)
+}
+
+// RevokeTagContext is like RevokeTag but aborts the notary calls when ctx is
+// done.
+func (repo *TrustedGcrRepository) RevokeTagContext(ctx context.Context, tag string) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveRevoke(time.Since(start), err) }(time.Now())
+	log := trust.WithFields(repo.logger, map[string]interface{}{"tag": tag})
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		log.Errorf("failed to revoke trusted repository: %s", err)
+		return errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		if err := revokeSignature(notaryRepo, tag); err != nil {
+			return errors.Wrapf(err, "could not remove signature for %s", tag)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("failed to revoke trusted repository: %s", err)
+		return err
+	}
+	log.Infof("Successfully deleted signature for %s\n", tag)
+	return nil
+}
+
+// DeleteTarget removes the target called name from the top level targets
+// role and publishes the change, unless publishing is deferred, e.g. to clean
+// up a target signed by mistake. Unlike RevokeTag, which removes the target
+// from every role that signed it and is reported to the observer as a
+// revocation, only the targets role is changed, and a delegation signing the
+// same name is left as it is. ErrNoSuchTarget is returned if the targets role
+// has no such target.
+func (repo *TrustedGcrRepository) DeleteTarget(name string) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		return deleteTarget(notaryRepo, repo.ref.Context().Name(), name)
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to delete target: %s", err)
+		return err
+	}
+	repo.logger.Infof("Successfully deleted target %s\n", name)
+	return nil
+}
+
+// MigrateTargetsToRole copies every target of the top level targets role into
+// the delegation role, e.g. when introducing targets/releases, and with
+// removeFromTargets also removes them from the targets role, in a single
+// publish unless publishing is deferred. The delegation must already exist,
+// see AddDelegation, allow the name of every target and have one of its keys
+// in the local key store; otherwise nothing is changed. Delegations keep their
+// targets.
+func (repo *TrustedGcrRepository) MigrateTargetsToRole(role data.RoleName, removeFromTargets bool) error {
+	defer repo.lock()()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	var migrated int
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		migrated, err = migrateTargets(notaryRepo, repo.ref.Context().Name(), role, removeFromTargets)
+		return err
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to migrate targets to %s: %s", role, err)
+		return err
+	}
+	repo.logger.Infof("Successfully migrated %d targets to %s\n", migrated, role)
+	return nil
+}
+
+// RevokeTags revokes the signatures of all tags at once, publishing a single
+// time unless publishing is deferred. Tags without a signed target do not
+// abort the batch: the others are still revoked and the missing tags are
+// reported in an error wrapping ErrNoTrustData.
+func (repo *TrustedGcrRepository) RevokeTags(tags []string) (err error) {
+	defer repo.lock()()
+	defer func(start time.Time) { repo.observer.ObserveRevoke(time.Since(start), err) }(time.Now())
+	repoName := repo.ref.Context().Name()
+	if len(tags) == 0 {
+		return errors.Errorf("no tags given to revoke in %s", repoName)
+	}
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	if err != nil {
+		repo.logger.Errorf("failed to revoke trusted repository: %s", err)
+		return errors.Wrap(err, "error establishing connection to trust repository")
+	}
+	var missing []string
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		var err error
+		missing, err = revokeTags(notaryRepo, tags)
+		if err == nil && len(missing) == len(tags) {
+			// there is nothing to publish
+			return errors.Wrapf(ErrNoTrustData, "%s:%s", repoName, strings.Join(missing, ","))
+		}
+		return err
+	})
+	if err != nil {
+		repo.logger.Errorf("failed to revoke trusted repository: %s", err)
+		return err
+	}
+	if len(missing) > 0 {
+		err = errors.Wrapf(ErrNoTrustData, "%s:%s", repoName, strings.Join(missing, ","))
+		repo.logger.Warnf("Deleted signatures of %d of %d tags: %s\n", len(tags)-len(missing), len(tags), err)
 		return err
 	}
+	repo.logger.Infof("Successfully deleted signatures for %s\n", strings.Join(tags, ", "))
 	return nil
 }
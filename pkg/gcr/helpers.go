@@ -1,8 +1,12 @@
 package gcr
 
 import (
+	"time"
+
 	// "github.com/sirupsen/logrus"
+	"github.com/simonshyu/notary-gcr/trust"
 	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
 )
 
 // clearChangelist clears the notary staging changelist.
@@ -13,3 +17,23 @@ func clearChangeList(notaryRepo client.Repository) error {
 	}
 	return cl.Clear("")
 }
+
+// pendingChanges returns the changes staged in the notary changelist that
+// have not been published yet.
+func pendingChanges(notaryRepo client.Repository) ([]changelist.Change, error) {
+	cl, err := notaryRepo.GetChangelist()
+	if err != nil {
+		return nil, err
+	}
+	return cl.List(), nil
+}
+
+// PruneCache removes the TUF metadata cached in the trust directory of the
+// default config directory for the repositories whose cached timestamp has
+// expired and that were not used within olderThan, e.g. to keep the trust
+// directory of CI runners from growing unbounded. Signing keys are never
+// removed. It returns the number of repositories whose cache was pruned. See
+// trust.PruneCache for other config directories.
+func PruneCache(olderThan time.Duration) (removed int, err error) {
+	return trust.PruneCache("", olderThan)
+}
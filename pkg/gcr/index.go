@@ -0,0 +1,82 @@
+package gcr
+
+import (
+	"encoding/hex"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+func pushIndex(log trust.Logger, ref name.Reference, idx v1.ImageIndex, options ...remote.Option) error {
+	err := remote.WriteIndex(ref, idx, options...)
+	if err != nil {
+		log.Errorf("failed to push index: %s", err)
+		return err
+	}
+	return nil
+}
+
+// indexTargets computes the notary targets for idx pushed as ref.
+//
+// The first target is named after the tag of ref and describes the index
+// manifest itself: its length is the size in bytes of the raw index manifest
+// and its sha256 hash is the index digest, exactly as for a single image.
+//
+// When withChildren is set, one target is added per child manifest. A child
+// target is named after the child digest (e.g. "sha256:...") so it is unique
+// and stable across tags, and its length and hash are taken from the child
+// descriptor in the index manifest, i.e. the size and digest of the child
+// manifest as stored in the registry. The child manifests are not fetched.
+func indexTargets(log trust.Logger, ref name.Reference, idx v1.ImageIndex, withChildren bool) ([]*client.Target, error) {
+	digest, err := idx.Digest()
+	if err != nil {
+		log.Errorf("failed to get idx.Digest: %s", err)
+		return nil, err
+	}
+	manifest, err := idx.RawManifest()
+	if err != nil {
+		log.Errorf("failed to get idx.RawManifest: %s", err)
+		return nil, err
+	}
+	target, err := newTarget(log, ref.Identifier(), digest, int64(len(manifest)))
+	if err != nil {
+		return nil, err
+	}
+	targets := []*client.Target{target}
+	if !withChildren {
+		return targets, nil
+	}
+
+	indexManifest, err := idx.IndexManifest()
+	if err != nil {
+		log.Errorf("failed to get idx.IndexManifest: %s", err)
+		return nil, err
+	}
+	for _, desc := range indexManifest.Manifests {
+		child, err := newTarget(log, desc.Digest.String(), desc.Digest, desc.Size)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, child)
+	}
+	return targets, nil
+}
+
+// newTarget returns a notary target named targetName for the manifest with
+// the given digest and size.
+func newTarget(log trust.Logger, targetName string, digest v1.Hash, size int64) (*client.Target, error) {
+	h, err := hex.DecodeString(digest.Hex)
+	if err != nil {
+		log.Errorf("failed to decode digest.Hex: %s", err)
+		return nil, err
+	}
+	return &client.Target{
+		Name:   targetName,
+		Hashes: data.Hashes{digest.Algorithm: h},
+		Length: size,
+	}, nil
+}
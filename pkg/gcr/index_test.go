@@ -0,0 +1,72 @@
+package gcr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/simonshyu/notary-gcr/trust"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// rawIndex is a v1.ImageIndex backed only by its serialized manifest.
+type rawIndex []byte
+
+func (r rawIndex) MediaType() (types.MediaType, error) { return types.OCIImageIndex, nil }
+func (r rawIndex) Size() (int64, error)                { return int64(len(r)), nil }
+func (r rawIndex) RawManifest() ([]byte, error)        { return r, nil }
+func (r rawIndex) Image(v1.Hash) (v1.Image, error)     { return nil, nil }
+func (r rawIndex) ImageIndex(v1.Hash) (v1.ImageIndex, error) {
+	return nil, nil
+}
+func (r rawIndex) Digest() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(r))
+	return h, err
+}
+func (r rawIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return v1.ParseIndexManifest(bytes.NewReader(r))
+}
+
+func newRawIndex(t *testing.T, children ...string) rawIndex {
+	m := v1.IndexManifest{SchemaVersion: 2, MediaType: types.OCIImageIndex}
+	for _, c := range children {
+		h, size, err := v1.SHA256(bytes.NewReader([]byte(c)))
+		assert.NilError(t, err)
+		m.Manifests = append(m.Manifests, v1.Descriptor{MediaType: types.OCIManifestSchema1, Digest: h, Size: size})
+	}
+	raw, err := json.Marshal(m)
+	assert.NilError(t, err)
+	return rawIndex(raw)
+}
+
+func TestIndexTargets(t *testing.T) {
+	ref, _ := name.ParseReference("gcr.io/foo/image:latest", name.WeakValidation)
+	idx := newRawIndex(t, "amd64", "arm64")
+
+	digest, err := idx.Digest()
+	assert.NilError(t, err)
+
+	targets, err := indexTargets(trust.DefaultLogger(), ref, idx, false)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(targets, 1))
+	assert.Check(t, is.Equal(targets[0].Name, "latest"))
+	assert.Check(t, is.Equal(targets[0].Length, int64(len(idx))))
+	assert.Check(t, is.Equal(hex.EncodeToString(targets[0].Hashes["sha256"]), digest.Hex))
+
+	targets, err = indexTargets(trust.DefaultLogger(), ref, idx, true)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(targets, 3))
+	indexManifest, err := idx.IndexManifest()
+	assert.NilError(t, err)
+	for i, desc := range indexManifest.Manifests {
+		child := targets[i+1]
+		assert.Check(t, is.Equal(child.Name, desc.Digest.String()))
+		assert.Check(t, is.Equal(child.Length, desc.Size))
+		assert.Check(t, is.Equal(hex.EncodeToString(child.Hashes["sha256"]), desc.Digest.Hex))
+	}
+}
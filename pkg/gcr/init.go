@@ -0,0 +1,148 @@
+package gcr
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// initTrust initializes the notary repository and publishes its initial
+// metadata. ErrAlreadyInitialized is returned if the repository already has
+// trust data.
+func initTrust(log trust.Logger, notaryRepo client.Repository, repoName string, serverManagedRoles []data.RoleName) error {
+	if err := checkUninitialized(notaryRepo, repoName); err != nil {
+		return err
+	}
+
+	if err := initializeRepository(notaryRepo, serverManagedRoles); err != nil {
+		return notaryError(repoName, err)
+	}
+	if err := notaryRepo.Publish(); err != nil {
+		return notaryError(repoName, err)
+	}
+	log.Infof("Finished initializing %s\n", repoName)
+	return nil
+}
+
+// initTrustWithKeys is like initTrust but makes rootKeys and targetsKeys the
+// keys of the root and targets roles instead of generating them.
+func initTrustWithKeys(log trust.Logger, notaryRepo client.Repository, repoName string, serverManagedRoles []data.RoleName, rootKeys, targetsKeys []data.PublicKey) error {
+	if err := checkUninitialized(notaryRepo, repoName); err != nil {
+		return err
+	}
+
+	generated, err := initializeRepositoryWithKeys(notaryRepo, serverManagedRoles, rootKeys, targetsKeys)
+	if err != nil {
+		return notaryError(repoName, err)
+	}
+	err = notaryRepo.Publish()
+	// the targets key notary generated is not used by the published metadata
+	for _, keyID := range generated {
+		if err := notaryRepo.GetCryptoService().RemoveKey(keyID); err != nil {
+			log.Warnf("failed to remove unused targets key %s: %s\n", keyID, err)
+		}
+	}
+	if err != nil {
+		return notaryError(repoName, err)
+	}
+	log.Infof("Finished initializing %s with the given keys\n", repoName)
+	return nil
+}
+
+// checkUninitialized returns ErrAlreadyInitialized if the notary repository
+// already has trust data.
+func checkUninitialized(notaryRepo client.Repository, repoName string) error {
+	_, err := notaryRepo.ListTargets()
+	switch err.(type) {
+	case client.ErrRepoNotInitialized, client.ErrRepositoryNotExist:
+		return nil
+	case nil:
+		return errors.Wrapf(ErrAlreadyInitialized, "%s", repoName)
+	default:
+		return notaryError(repoName, err)
+	}
+}
+
+// initializeRepositoryWithKeys stages the initial metadata of a new notary
+// repository with rootKeys, e.g. root certificates, as root keys and
+// targetsKeys as targets keys. The private keys of all root keys and of at
+// least one targets key must be reachable through the crypto service of the
+// repository, e.g. in an HSM backed key store, to sign the initial metadata.
+// Notary always generates a targets key when initializing a repository, which
+// is replaced by targetsKeys in the staged changes; its ID is returned so that
+// it can be removed once the changes are published.
+func initializeRepositoryWithKeys(notaryRepo client.Repository, serverManagedRoles []data.RoleName, rootKeys, targetsKeys []data.PublicKey) ([]string, error) {
+	if len(rootKeys) == 0 || len(targetsKeys) == 0 {
+		return nil, errors.New("at least one root key and one targets key are required")
+	}
+	cs := notaryRepo.GetCryptoService()
+	if !hasPrivateKey(cs, targetsKeys) {
+		return nil, errors.New("no private key of the targets keys found in the key store")
+	}
+	before := make(map[string]bool)
+	for _, keyID := range cs.ListKeys(data.CanonicalTargetsRole) {
+		before[keyID] = true
+	}
+
+	if err := notaryRepo.InitializeWithCertificate(nil, rootKeys, serverManagedRoles...); err != nil {
+		return nil, err
+	}
+	var generated []string
+	for _, keyID := range cs.ListKeys(data.CanonicalTargetsRole) {
+		if !before[keyID] {
+			generated = append(generated, keyID)
+		}
+	}
+
+	content, err := json.Marshal(&changelist.TUFRootData{RoleName: data.CanonicalTargetsRole, Keys: targetsKeys})
+	if err != nil {
+		return generated, err
+	}
+	cl, err := notaryRepo.GetChangelist()
+	if err != nil {
+		return generated, err
+	}
+	change := changelist.NewTUFChange(changelist.ActionCreate, changelist.ScopeRoot, changelist.TypeBaseRole, data.CanonicalTargetsRole.String(), content)
+	return generated, cl.Add(change)
+}
+
+// hasPrivateKey reports whether cs holds the private key of one of keys.
+func hasPrivateKey(cs signed.CryptoService, keys []data.PublicKey) bool {
+	for _, key := range keys {
+		keyID, err := utils.CanonicalKeyID(key)
+		if err != nil {
+			continue
+		}
+		if _, _, err := cs.GetPrivateKey(keyID); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// initializeRepository generates the keys of a new notary repository and
+// stages its initial metadata, reusing an existing root key if there is one.
+// The keys of serverManagedRoles are generated and held by the notary server.
+func initializeRepository(notaryRepo client.Repository, serverManagedRoles []data.RoleName) error {
+	keys := notaryRepo.GetCryptoService().ListKeys(data.CanonicalRootRole)
+	var rootKeyID string
+	// always select the first root key
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		rootKeyID = keys[0]
+	} else {
+		rootPublicKey, err := notaryRepo.GetCryptoService().Create(data.CanonicalRootRole, "", data.ECDSAKey)
+		if err != nil {
+			return err
+		}
+		rootKeyID = rootPublicKey.ID()
+	}
+	return notaryRepo.Initialize([]string{rootKeyID}, serverManagedRoles...)
+}
@@ -0,0 +1,94 @@
+package gcr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestInitializeRepositoryWithKeys(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t, WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")))
+	defer cleanup()
+	notaryRepo, err := repo.notaryRepository(context.Background())
+	assert.NilError(t, err)
+	cs := notaryRepo.GetCryptoService()
+	rootKey, err := cs.Create(data.CanonicalRootRole, "", data.ECDSAKey)
+	assert.NilError(t, err)
+	targetsKey, err := cs.Create(data.CanonicalTargetsRole, data.GUN(repo.ref.Context().Name()), data.ECDSAKey)
+	assert.NilError(t, err)
+
+	// the private key of a targets key must be held
+	other := data.NewPublicKey(data.ECDSAKey, []byte("not held"))
+	_, err = initializeRepositoryWithKeys(notaryRepo, nil, []data.PublicKey{rootKey}, []data.PublicKey{other})
+	assert.Check(t, is.ErrorContains(err, "no private key of the targets keys"))
+
+	generated, err := initializeRepositoryWithKeys(notaryRepo, nil, []data.PublicKey{rootKey}, []data.PublicKey{targetsKey})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(generated, 1))
+	assert.Check(t, generated[0] != targetsKey.ID())
+
+	cl, err := notaryRepo.GetChangelist()
+	assert.NilError(t, err)
+	changes := cl.List()
+	assert.Assert(t, is.Len(changes, 1))
+	assert.Check(t, is.Equal(changes[0].Scope().String(), changelist.ScopeRoot))
+	assert.Check(t, is.Equal(changes[0].Type(), changelist.TypeBaseRole))
+	var rd changelist.TUFRootData
+	assert.NilError(t, json.Unmarshal(changes[0].Content(), &rd))
+	assert.Check(t, is.Equal(rd.RoleName, data.CanonicalTargetsRole))
+	assert.Assert(t, is.Len(rd.Keys, 1))
+	assert.Check(t, is.Equal(rd.Keys[0].ID(), targetsKey.ID()))
+}
+
+// initializingRepository is a notary repository without trust data that
+// records the server managed roles it is initialized with.
+type initializingRepository struct {
+	*migratingRepository
+	serverManaged []data.RoleName
+}
+
+func (r *initializingRepository) ListTargets(roles ...data.RoleName) ([]*client.TargetWithRole, error) {
+	return nil, client.ErrRepoNotInitialized{}
+}
+
+func (r *initializingRepository) Initialize(rootKeyIDs []string, serverManagedRoles ...data.RoleName) error {
+	r.serverManaged = serverManagedRoles
+	return nil
+}
+
+func (r *initializingRepository) Publish() error {
+	return nil
+}
+
+func TestWithServerManagedSnapshot(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected []data.RoleName
+	}{
+		{name: "default"},
+		{name: "server managed", opts: []Option{WithServerManagedSnapshot()}, expected: []data.RoleName{data.CanonicalSnapshotRole}},
+		{name: "repeated", opts: []Option{WithServerManagedSnapshot(), WithServerManagedSnapshot()}, expected: []data.RoleName{data.CanonicalSnapshotRole}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, _, cleanup := newUninitializedRepository(t, tc.opts...)
+			defer cleanup()
+			notaryRepo := &initializingRepository{migratingRepository: &migratingRepository{
+				cs: cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase"))),
+			}}
+			repo.notary = notaryRepo
+
+			assert.NilError(t, repo.InitTrust())
+			assert.Check(t, is.DeepEqual(notaryRepo.serverManaged, tc.expected))
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package gcr
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// exportRootKey writes the root key of cs to w as a PEM block encrypted with
+// passphrase. When there are several root keys, the one initializeRepository
+// would pick is exported.
+func exportRootKey(cs signed.CryptoService, w io.Writer, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("a passphrase is required to export the root key")
+	}
+	keyIDs := cs.ListKeys(data.CanonicalRootRole)
+	if len(keyIDs) == 0 {
+		return errors.New("no root key found")
+	}
+	sort.Strings(keyIDs)
+	key, _, err := cs.GetPrivateKey(keyIDs[0])
+	if err != nil {
+		return errors.Wrapf(err, "could not read root key %s", keyIDs[0])
+	}
+	pemBytes, err := utils.ConvertPrivateKeyToPKCS8(key, data.CanonicalRootRole, "", passphrase)
+	if err != nil {
+		return errors.Wrapf(err, "could not encrypt root key %s", keyIDs[0])
+	}
+	_, err = w.Write(pemBytes)
+	return err
+}
+
+// importRootKey reads a root key PEM block encrypted with passphrase from r
+// and adds the key to cs.
+func importRootKey(cs signed.CryptoService, r io.Reader, passphrase string) error {
+	pemBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	role, _, err := utils.ExtractPrivateKeyAttributes(pemBytes)
+	if err != nil {
+		return errors.Wrap(err, "invalid root key")
+	}
+	if role != "" && role != data.CanonicalRootRole {
+		return errors.Errorf("cannot import a %s key as root key", role)
+	}
+	key, err := utils.ParsePEMPrivateKey(pemBytes, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "could not decrypt root key")
+	}
+	return cs.AddKey(data.CanonicalRootRole, "", key)
+}
+
+// keyInfoService is implemented by crypto services that know the GUN and
+// role each key was created for.
+type keyInfoService interface {
+	GetKeyInfo(keyID string) (trustmanager.KeyInfo, error)
+}
+
+// removeGUNKeys removes the keys of cs that belong to gun, leaving root keys,
+// which are not bound to a GUN, alone. It returns the number of keys removed.
+func removeGUNKeys(cs signed.CryptoService, gun data.GUN) (int, error) {
+	infos, ok := cs.(keyInfoService)
+	if !ok {
+		return 0, errors.New("the key store does not record the repository of its keys")
+	}
+	removed := 0
+	for keyID, role := range cs.ListAllKeys() {
+		if role == data.CanonicalRootRole {
+			continue
+		}
+		info, err := infos.GetKeyInfo(keyID)
+		if err != nil || info.Gun != gun {
+			continue
+		}
+		if err := cs.RemoveKey(keyID); err != nil {
+			return removed, errors.Wrapf(err, "could not remove %s key %s", role, keyID)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// rootKey returns the root key of root, the first one by ID if there are
+// several.
+func rootKey(root *data.SignedRoot) (data.PublicKey, error) {
+	role, ok := root.Signed.Roles[data.CanonicalRootRole]
+	if !ok || len(role.KeyIDs) == 0 {
+		return nil, errors.New("root metadata has no root key")
+	}
+	keyIDs := append([]string(nil), role.KeyIDs...)
+	sort.Strings(keyIDs)
+	key, ok := root.Signed.Keys[keyIDs[0]]
+	if !ok {
+		return nil, errors.Errorf("root metadata is missing root key %s", keyIDs[0])
+	}
+	return key, nil
+}
+
+// roleKeys returns the public keys of each base role of root.
+func roleKeys(root *data.SignedRoot) map[data.RoleName][]data.PublicKey {
+	keys := make(map[data.RoleName][]data.PublicKey, len(root.Signed.Roles))
+	for name, role := range root.Signed.Roles {
+		for _, keyID := range role.KeyIDs {
+			if key, ok := root.Signed.Keys[keyID]; ok {
+				keys[name] = append(keys[name], key)
+			}
+		}
+	}
+	return keys
+}
+
+// roleKeyIDs returns the sorted key IDs of each base role of root and of each
+// of delegations.
+func roleKeyIDs(root *data.SignedRoot, delegations []data.Role) map[data.RoleName][]string {
+	keyIDs := make(map[data.RoleName][]string, len(root.Signed.Roles)+len(delegations))
+	for name, role := range root.Signed.Roles {
+		keyIDs[name] = append([]string(nil), role.KeyIDs...)
+	}
+	for _, role := range delegations {
+		keyIDs[role.Name] = append([]string(nil), role.KeyIDs...)
+	}
+	for _, ids := range keyIDs {
+		sort.Strings(ids)
+	}
+	return keyIDs
+}
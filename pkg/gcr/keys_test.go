@@ -0,0 +1,109 @@
+package gcr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestExportImportRootKey(t *testing.T) {
+	retriever := passphrase.ConstantRetriever("store-passphrase")
+	src := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(retriever))
+	rootKey, err := src.Create(data.CanonicalRootRole, "", data.ECDSAKey)
+	assert.NilError(t, err)
+
+	var backup bytes.Buffer
+	assert.Check(t, is.ErrorContains(exportRootKey(src, &backup, ""), "passphrase is required"))
+	assert.NilError(t, exportRootKey(src, &backup, "escrow-passphrase"))
+
+	dst := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(retriever))
+	err = importRootKey(dst, bytes.NewReader(backup.Bytes()), "wrong-passphrase")
+	assert.Check(t, is.ErrorContains(err, "could not decrypt root key"))
+	assert.Check(t, is.Len(dst.ListKeys(data.CanonicalRootRole), 0))
+
+	assert.NilError(t, importRootKey(dst, bytes.NewReader(backup.Bytes()), "escrow-passphrase"))
+	assert.Check(t, is.DeepEqual(dst.ListKeys(data.CanonicalRootRole), []string{rootKey.ID()}))
+}
+
+func TestRemoveGUNKeys(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	rootKey, err := cs.Create(data.CanonicalRootRole, "", data.ECDSAKey)
+	assert.NilError(t, err)
+	_, err = cs.Create(data.CanonicalTargetsRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	otherKey, err := cs.Create(data.CanonicalTargetsRole, "gcr.io/project/other", data.ECDSAKey)
+	assert.NilError(t, err)
+
+	removed, err := removeGUNKeys(cs, "gcr.io/project/image")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(removed, 1))
+	assert.Check(t, is.DeepEqual(cs.ListAllKeys(), map[string]data.RoleName{
+		rootKey.ID():  data.CanonicalRootRole,
+		otherKey.ID(): data.CanonicalTargetsRole,
+	}))
+
+	removed, err = removeGUNKeys(cs, "gcr.io/project/image")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(removed, 0))
+}
+
+func TestRootKeyAndRoleKeys(t *testing.T) {
+	rootA := data.NewPublicKey(data.ECDSAx509Key, []byte("root a"))
+	rootB := data.NewPublicKey(data.ECDSAx509Key, []byte("root b"))
+	targets := data.NewPublicKey(data.ED25519Key, []byte("targets"))
+	root, err := data.NewRoot(
+		map[string]data.PublicKey{rootA.ID(): rootA, rootB.ID(): rootB, targets.ID(): targets},
+		map[data.RoleName]*data.RootRole{
+			data.CanonicalRootRole:    {KeyIDs: []string{rootB.ID(), rootA.ID()}, Threshold: 1},
+			data.CanonicalTargetsRole: {KeyIDs: []string{targets.ID()}, Threshold: 1},
+		},
+		false)
+	assert.NilError(t, err)
+
+	key, err := rootKey(root)
+	assert.NilError(t, err)
+	first := rootA
+	if rootB.ID() < rootA.ID() {
+		first = rootB
+	}
+	assert.Check(t, is.Equal(key.ID(), first.ID()))
+	assert.Check(t, is.Equal(key.Algorithm(), data.ECDSAx509Key))
+
+	keys := roleKeys(root)
+	assert.Check(t, is.Len(keys[data.CanonicalRootRole], 2))
+	assert.Check(t, is.Len(keys[data.CanonicalTargetsRole], 1))
+	assert.Check(t, is.Equal(keys[data.CanonicalTargetsRole][0].ID(), targets.ID()))
+
+	releases := data.Role{Name: "targets/releases", RootRole: data.RootRole{KeyIDs: []string{"b", "a"}}}
+	keyIDs := roleKeyIDs(root, []data.Role{releases})
+	assert.Check(t, is.Len(keyIDs[data.CanonicalRootRole], 2))
+	assert.Check(t, keyIDs[data.CanonicalRootRole][0] < keyIDs[data.CanonicalRootRole][1])
+	assert.Check(t, is.DeepEqual(keyIDs[data.CanonicalTargetsRole], []string{targets.ID()}))
+	assert.Check(t, is.DeepEqual(keyIDs["targets/releases"], []string{"a", "b"}))
+	assert.Check(t, is.DeepEqual(releases.KeyIDs, []string{"b", "a"}))
+}
+
+func TestIsServerManaged(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	localKey, err := cs.Create(data.CanonicalTimestampRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	serverKey, err := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase"))).
+		Create(data.CanonicalTimestampRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+
+	root := func(keyIDs ...string) *data.SignedRoot {
+		return &data.SignedRoot{Signed: data.Root{Roles: map[data.RoleName]*data.RootRole{
+			data.CanonicalTimestampRole: {KeyIDs: keyIDs},
+		}}}
+	}
+	assert.Check(t, isServerManaged(cs, root(serverKey.ID()), data.CanonicalTimestampRole))
+	assert.Check(t, !isServerManaged(cs, root(localKey.ID()), data.CanonicalTimestampRole))
+	assert.Check(t, !isServerManaged(cs, root(serverKey.ID(), localKey.ID()), data.CanonicalTimestampRole))
+	assert.Check(t, !isServerManaged(cs, root(), data.CanonicalSnapshotRole))
+}
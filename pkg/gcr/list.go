@@ -4,25 +4,18 @@ import (
 	"encoding/hex"
 	"fmt"
 
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 
 	"github.com/simonshyu/notary-gcr/trust"
-	log "github.com/sirupsen/logrus"
 	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
 )
 
-func listTargets(ref name.Reference, auth authn.Authenticator, config *trust.Config) ([]*client.Target, error) {
-	registry := ref.Context().Registry
-	repo, err := trust.GetNotaryRepository(ref, auth, &registry, config)
-	if err != nil {
-		log.Errorf("failed to get notary repository %s", err)
-		return nil, err
-	}
-	rawTargets, err := repo.ListTargets()
+func listTargets(log trust.Logger, notaryRepo client.Repository, repoName string) ([]*client.Target, error) {
+	rawTargets, err := notaryRepo.ListTargets()
 	if err != nil {
 		log.Errorf("failed to list targets %s", err)
-		return nil, err
+		return nil, notaryError(repoName, err)
 	}
 
 	var targets []*client.Target
@@ -38,3 +31,94 @@ func listTargets(ref name.Reference, auth authn.Authenticator, config *trust.Con
 	}
 	return targets, nil
 }
+
+// listTrustedTargets returns the targets of the top level targets role and
+// the releases delegation role, the ones tags are verified against, leaving
+// out those only other delegations signed.
+func listTrustedTargets(notaryRepo client.Repository, repoName string) ([]*client.Target, error) {
+	rawTargets, err := notaryRepo.ListTargets(trust.ReleasesRole, data.CanonicalTargetsRole)
+	if err != nil {
+		return nil, notaryError(repoName, err)
+	}
+	var targets []*client.Target
+	for _, t := range rawTargets {
+		if t.Role == trust.ReleasesRole || t.Role == data.CanonicalTargetsRole {
+			targets = append(targets, &t.Target)
+		}
+	}
+	return targets, nil
+}
+
+// forEachTarget calls fn with each of targets in turn, stopping at the first
+// error fn returns.
+func forEachTarget(targets []*client.TargetWithRole, fn func(*client.Target) error) error {
+	for _, t := range targets {
+		if err := fn(&t.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagDigests maps the name of each of targets to its sha256 digest, leaving
+// out the targets without a valid sha256 hash.
+func tagDigests(log trust.Logger, targets []*client.Target) map[string]v1.Hash {
+	tags := make(map[string]v1.Hash, len(targets))
+	for _, t := range targets {
+		digest, err := targetDigest(t)
+		if err != nil {
+			log.Debugf("skipping target: %s", err)
+			continue
+		}
+		tags[t.Name] = digest
+	}
+	return tags
+}
+
+// listRoleTargets returns the targets signed directly into role, leaving out
+// those of the delegations below it.
+func listRoleTargets(notaryRepo client.Repository, repoName string, role data.RoleName) ([]*client.Target, error) {
+	if role != data.CanonicalTargetsRole {
+		if _, err := findDelegation(notaryRepo, repoName, role); err != nil {
+			return nil, err
+		}
+	}
+	rawTargets, err := notaryRepo.ListTargets(role)
+	if err != nil {
+		return nil, notaryError(repoName, err)
+	}
+	targets := make([]*client.Target, 0, len(rawTargets))
+	for _, t := range rawTargets {
+		if t.Role == role {
+			targets = append(targets, &t.Target)
+		}
+	}
+	return targets, nil
+}
+
+// unsignedTags returns the tags, in the order given, that have no target in
+// the trusted roles of notaryRepo, i.e. those verification would reject. All
+// tags are unsigned in a repository without trust data.
+func unsignedTags(notaryRepo client.Repository, repoName string, tags []string) ([]string, error) {
+	targets, err := notaryRepo.ListTargets(trust.ReleasesRole, data.CanonicalTargetsRole)
+	switch err.(type) {
+	case nil:
+	case client.ErrRepositoryNotExist, client.ErrRepoNotInitialized:
+		targets = nil
+	default:
+		return nil, notaryError(repoName, err)
+	}
+	signed := make(map[string]struct{}, len(targets))
+	for _, t := range targets {
+		if t.Role == trust.ReleasesRole || t.Role == data.CanonicalTargetsRole {
+			signed[t.Name] = struct{}{}
+		}
+	}
+	unsigned := []string{}
+	for _, tag := range tags {
+		if _, ok := signed[tag]; !ok {
+			unsigned = append(unsigned, tag)
+		}
+	}
+	return unsigned, nil
+}
@@ -0,0 +1,147 @@
+package gcr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestTagDigests(t *testing.T) {
+	latest := sha256.Sum256([]byte("latest"))
+	stable := sha256.Sum256([]byte("stable"))
+	targets := []*client.Target{
+		{Name: "latest", Hashes: data.Hashes{"sha256": latest[:]}},
+		{Name: "stable", Hashes: data.Hashes{"sha256": stable[:], "sha512": make([]byte, 64)}},
+		{Name: "legacy", Hashes: data.Hashes{"sha512": make([]byte, 64)}},
+	}
+
+	tags := tagDigests(trust.DefaultLogger(), targets)
+	assert.Check(t, is.DeepEqual(tags, map[string]v1.Hash{
+		"latest": {Algorithm: "sha256", Hex: hex.EncodeToString(latest[:])},
+		"stable": {Algorithm: "sha256", Hex: hex.EncodeToString(stable[:])},
+	}))
+}
+
+func TestListTrustedTargets(t *testing.T) {
+	notaryRepo := &removingRepository{targets: []*client.TargetWithRole{
+		{Target: client.Target{Name: "latest"}, Role: data.CanonicalTargetsRole},
+		{Target: client.Target{Name: "stable"}, Role: trust.ReleasesRole},
+		{Target: client.Target{Name: "candidate"}, Role: "targets/qa"},
+	}}
+	targets, err := listTrustedTargets(notaryRepo, "gcr.io/project/image")
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(targets, []*client.Target{&notaryRepo.targets[0].Target, &notaryRepo.targets[1].Target}))
+}
+
+func TestForEachTarget(t *testing.T) {
+	targets := []*client.TargetWithRole{
+		{Target: client.Target{Name: "latest"}, Role: data.CanonicalTargetsRole},
+		{Target: client.Target{Name: "stable"}, Role: trust.ReleasesRole},
+		{Target: client.Target{Name: "v1"}, Role: trust.ReleasesRole},
+	}
+	var names []string
+	assert.NilError(t, forEachTarget(targets, func(target *client.Target) error {
+		names = append(names, target.Name)
+		return nil
+	}))
+	assert.Check(t, is.DeepEqual(names, []string{"latest", "stable", "v1"}))
+
+	found := errors.New("found")
+	names = nil
+	err := forEachTarget(targets, func(target *client.Target) error {
+		names = append(names, target.Name)
+		if target.Name == "stable" {
+			return found
+		}
+		return nil
+	})
+	assert.Check(t, is.Equal(err, found))
+	assert.Check(t, is.DeepEqual(names, []string{"latest", "stable"}))
+}
+
+func TestDiffTargets(t *testing.T) {
+	target := func(name string, content string) *client.TargetWithRole {
+		sum := sha256.Sum256([]byte(content))
+		return &client.TargetWithRole{
+			Target: client.Target{Name: name, Hashes: data.Hashes{"sha256": sum[:]}, Length: int64(len(content))},
+			Role:   data.CanonicalTargetsRole,
+		}
+	}
+	local := []*client.TargetWithRole{target("v1", "one"), target("v2", "two"), target("latest", "two")}
+	remote := []*client.TargetWithRole{target("v3", "three"), target("v2", "two"), target("latest", "three"), target("beta", "four")}
+
+	added, removed, changed := diffTargets(local, remote)
+	assert.Check(t, is.DeepEqual(added, []client.Target{remote[3].Target, remote[0].Target}))
+	assert.Check(t, is.DeepEqual(removed, []client.Target{local[0].Target}))
+	assert.Check(t, is.DeepEqual(changed, []client.Target{remote[2].Target}))
+}
+
+func TestUnsignedTagsWithoutTrustData(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+
+	unsigned, err := repo.UnsignedTags([]string{"v1", "latest"})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(unsigned, []string{"v1", "latest"}))
+}
+
+func TestFilterTargetsByPlatform(t *testing.T) {
+	var targets []*client.Target
+	for _, p := range []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "windows", Architecture: "amd64"},
+	} {
+		custom, err := platformCustom(p)
+		assert.NilError(t, err)
+		target := &client.Target{Name: p.OS + "-" + p.Architecture}
+		assert.NilError(t, setTargetCustom(target, custom))
+		targets = append(targets, target)
+	}
+	targets = append(targets, &client.Target{Name: "latest"})
+
+	names := func(targets []*client.Target) []string {
+		var names []string
+		for _, t := range targets {
+			names = append(names, t.Name)
+		}
+		return names
+	}
+	assert.Check(t, is.DeepEqual(names(FilterTargetsByPlatform(targets, v1.Platform{OS: "linux", Architecture: "amd64"})), []string{"linux-amd64"}))
+	assert.Check(t, is.DeepEqual(names(FilterTargetsByPlatform(targets, v1.Platform{OS: "linux", Architecture: "arm64"})), []string{"linux-arm64"}))
+	assert.Check(t, is.Len(FilterTargetsByPlatform(targets, v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v7"}), 0))
+
+	p, err := TargetPlatform(targets[1])
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(p.Variant, "v8"))
+	p, err = TargetPlatform(targets[3])
+	assert.NilError(t, err)
+	assert.Check(t, p == nil)
+
+	_, err = platformCustom(v1.Platform{OS: "linux"})
+	assert.ErrorContains(t, err, "must have an os and an architecture")
+}
+
+func TestNewTrustedTarget(t *testing.T) {
+	sum := sha256.Sum256([]byte("latest"))
+	target := &client.Target{Name: "latest", Hashes: data.Hashes{"sha256": sum[:]}, Length: 42}
+	assert.NilError(t, setTargetCustom(target, []byte(`{"commit":"abc"}`)))
+
+	out, err := json.Marshal(NewTrustedTarget(target, trust.ReleasesRole))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(out), `{"name":"latest","digest":"sha256:`+hex.EncodeToString(sum[:])+
+		`","size":42,"role":"targets/releases","custom":{"commit":"abc"}}`))
+
+	out, err = json.Marshal(NewTrustedTarget(&client.Target{Name: "v1", Hashes: data.Hashes{"sha512": []byte{1}}}, ""))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(out), `{"name":"v1","digest":"sha512:01","size":0}`))
+}
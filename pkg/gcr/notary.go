@@ -0,0 +1,158 @@
+package gcr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+)
+
+// lock acquires the lock serializing the operations of repo that use its
+// notary handle and returns the function releasing it.
+func (repo *TrustedGcrRepository) lock() func() {
+	if repo.mu == nil {
+		// not built by a constructor, so not meant to be shared
+		return func() {}
+	}
+	repo.mu.Lock()
+	return repo.mu.Unlock
+}
+
+// notaryRepository returns the notary repository of repo, creating it on first
+// use. The handle is reused by later calls; requests sent through it are bound
+// to the ctx of the call currently using it. Reusing it spares every call the
+// notary token handshake, i.e. a ping of the server and a token request, so
+// that e.g. verifying and then signing a tag authenticates once instead of
+// twice.
+func (repo *TrustedGcrRepository) notaryRepository(ctx context.Context) (client.Repository, error) {
+	if repo.notaryCtx == nil {
+		repo.notaryCtx = &operationContext{}
+	}
+	repo.notaryCtx.set(ctx)
+	if repo.notary != nil {
+		return repo.notary, nil
+	}
+
+	registry := repo.ref.Context().Registry
+	notaryRepo, err := trust.GetNotaryRepositoryContext(repo.notaryCtx, repo.ref, repo.notaryAuth, &registry, repo.config)
+	if err != nil {
+		return nil, err
+	}
+	repo.notary = notaryRepo
+	return notaryRepo, nil
+}
+
+// dryRunStage runs stage, records and logs the changes it adds to cl and
+// removes them again, leaving the changes staged before alone.
+func (repo *TrustedGcrRepository) dryRunStage(cl changelist.Changelist, stage func() error) error {
+	staged := len(cl.List())
+	stageErr := stage()
+	changes := cl.List()[staged:]
+	added := make([]int, 0, len(changes))
+	for i := range changes {
+		added = append(added, staged+i)
+	}
+	if err := cl.Remove(added); err != nil {
+		return err
+	}
+	if stageErr != nil {
+		return stageErr
+	}
+	repo.dryRunChanges = changes
+	for _, c := range changes {
+		repo.logger.Infof("Dry run: would %s %s %s in %s\n", c.Action(), c.Type(), c.Path(), c.Scope())
+	}
+	return nil
+}
+
+// updateMetadata brings the TUF metadata cached in the trust directory up to
+// date with the notary server.
+func (repo *TrustedGcrRepository) updateMetadata(ctx context.Context) error {
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository: %s", err)
+		return err
+	}
+	// listing the targets updates the cached metadata
+	if _, err := notaryRepo.ListTargets(); err != nil {
+		err = notaryError(repo.ref.Context().Name(), err)
+		repo.logger.Errorf("failed to update trust metadata: %s", err)
+		return err
+	}
+	return nil
+}
+
+// operationContext is a context.Context that forwards to the context of the
+// operation currently using a cached notary repository, so that a handle
+// created once can still be cancelled per call.
+type operationContext struct {
+	mu  sync.RWMutex
+	ctx context.Context
+}
+
+func (c *operationContext) set(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+}
+
+func (c *operationContext) current() context.Context {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *operationContext) Deadline() (time.Time, bool) { return c.current().Deadline() }
+func (c *operationContext) Done() <-chan struct{}       { return c.current().Done() }
+func (c *operationContext) Err() error                  { return c.current().Err() }
+func (c *operationContext) Value(key interface{}) interface{} {
+	return c.current().Value(key)
+}
+
+// stageAndPublish runs stage, which adds changes to the changelist of
+// notaryRepo, and publishes them. With deferred publishing the changes are
+// kept staged for a later Publish instead; if stage fails, only the changes it
+// added are dropped so that earlier staged work survives.
+func (repo *TrustedGcrRepository) stageAndPublish(notaryRepo client.Repository, stage func() error) error {
+	cl, err := notaryRepo.GetChangelist()
+	if err != nil {
+		return err
+	}
+	if repo.dryRun {
+		return repo.dryRunStage(cl, stage)
+	}
+	if repo.deferPublish {
+		staged := len(cl.List())
+		if err := stage(); err != nil {
+			added := make([]int, 0)
+			for i := staged; i < len(cl.List()); i++ {
+				added = append(added, i)
+			}
+			if removeErr := cl.Remove(added); removeErr != nil {
+				return errors.Wrapf(removeErr, "error dropping the changes staged before failing with %q", err)
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := cl.Clear(""); err != nil {
+		return err
+	}
+	defer clearChangeList(notaryRepo)
+	if err := stage(); err != nil {
+		return err
+	}
+	if err := notaryRepo.Publish(); err != nil {
+		return notaryError(repo.ref.Context().Name(), err)
+	}
+	repo.forgetVerifications()
+	return nil
+}
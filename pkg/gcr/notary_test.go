@@ -0,0 +1,58 @@
+package gcr
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// changelistRepository is a client.Repository staging into cl.
+type changelistRepository struct {
+	client.Repository
+	cl changelist.Changelist
+}
+
+func (r *changelistRepository) GetChangelist() (changelist.Changelist, error) {
+	return r.cl, nil
+}
+
+// stuckChangelist is a changelist whose changes cannot be removed.
+type stuckChangelist struct {
+	changelist.Changelist
+}
+
+func (cl *stuckChangelist) Remove(idxs []int) error {
+	return errors.New("changelist is read-only")
+}
+
+func TestStageAndPublishDeferred(t *testing.T) {
+	repo := &TrustedGcrRepository{deferPublish: true, logger: trust.DefaultLogger()}
+	change := changelist.NewTUFChange(changelist.ActionCreate, "targets", changelist.TypeTargetsTarget, "latest", nil)
+	failing := func(cl changelist.Changelist) func() error {
+		return func() error {
+			if err := cl.Add(change); err != nil {
+				return err
+			}
+			return errors.New("stage failed")
+		}
+	}
+
+	// only the changes of the failed stage are dropped
+	cl := changelist.NewMemChangelist()
+	assert.NilError(t, cl.Add(change))
+	err := repo.stageAndPublish(&changelistRepository{cl: cl}, failing(cl))
+	assert.Check(t, is.ErrorContains(err, "stage failed"))
+	assert.Check(t, is.Len(cl.List(), 1))
+
+	// changes that cannot be dropped are reported rather than left for the
+	// next Publish unnoticed
+	stuck := &stuckChangelist{changelist.NewMemChangelist()}
+	err = repo.stageAndPublish(&changelistRepository{cl: stuck}, failing(stuck))
+	assert.Check(t, is.ErrorContains(err, "changelist is read-only"))
+	assert.Check(t, is.ErrorContains(err, "stage failed"))
+}
@@ -0,0 +1,25 @@
+package gcr
+
+import "time"
+
+// Observer is notified at the end of every trust operation of a repository,
+// with how long it took and the error it returned, if any, e.g. to export
+// latency and error rate metrics. Its methods may be called concurrently.
+type Observer interface {
+	// ObservePush is called by TrustPush, TrustPushAll and TrustPushIndex.
+	ObservePush(d time.Duration, err error)
+	// ObserveSign is called by SignImage and its variants.
+	ObserveSign(d time.Duration, err error)
+	// ObserveVerify is called by Verify and its variants.
+	ObserveVerify(d time.Duration, err error)
+	// ObserveRevoke is called by RevokeTag and RevokeTags.
+	ObserveRevoke(d time.Duration, err error)
+}
+
+// nopObserver is the Observer of repositories without one.
+type nopObserver struct{}
+
+func (nopObserver) ObservePush(time.Duration, error)   {}
+func (nopObserver) ObserveSign(time.Duration, error)   {}
+func (nopObserver) ObserveVerify(time.Duration, error) {}
+func (nopObserver) ObserveRevoke(time.Duration, error) {}
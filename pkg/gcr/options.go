@@ -0,0 +1,704 @@
+package gcr
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// Option configures a TrustedGcrRepository at construction time.
+type Option func(*options) error
+
+type options struct {
+	configDir    string
+	cacheDir     string
+	registryAuth authn.Authenticator
+	notaryAuth   authn.Authenticator
+	keychain     authn.Keychain
+	tokenRefresh func() (authn.Authenticator, error)
+	trustServer  string
+
+	logger        trust.Logger
+	passRetriever notary.PassRetriever
+	retry         trust.RetryPolicy
+
+	registryTransport  http.RoundTripper
+	notaryTransport    http.RoundTripper
+	notaryRootCAs      *x509.CertPool
+	insecureSkipVerify *bool
+
+	deferPublish       bool
+	serverManagedRoles []data.RoleName
+	expiryWarning      time.Duration
+	maxStaleness       time.Duration
+	pushProgress       func(v1.Update)
+	pushChunkSize      int64
+	pushRetries        *int
+	immutableTags      bool
+	keyAlgorithm       string
+	keyGenHook         func(role data.RoleName, keyID string)
+	trustAnchor        []byte
+	keyStore           trustmanager.KeyStore
+	inMemoryKeyStore   bool
+	pinnedRoots        []string
+	rootPinStore       string
+	observer           Observer
+	verifyHook         func(tag string, target *client.Target, err error)
+	dryRun             bool
+	noAutoInit         bool
+	ignoreExpiry       bool
+	prereleases        bool
+	verifyCache        *verifyCache
+	requiredSigners    []string
+	rootChangeHook     func(oldKeyID, newKeyID string)
+	metadataExpiry     map[data.RoleName]time.Duration
+}
+
+func makeOptions(opts ...Option) (*options, error) {
+	o := &options{
+		logger:   trust.DefaultLogger(),
+		observer: nopObserver{},
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// resolveAuth fills in the authenticators of repo that were not given
+// explicitly from the keychain, if any, falling back to anonymous access.
+func (o *options) resolveAuth(repo name.Repository) error {
+	if o.keychain != nil && (o.registryAuth == nil || o.notaryAuth == nil) {
+		auth, err := o.keychain.Resolve(repo)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve credentials of %s", repo)
+		}
+		if o.registryAuth == nil {
+			o.registryAuth = auth
+		}
+		// the notary server of the registry accepts the registry credentials
+		if o.notaryAuth == nil {
+			o.notaryAuth = auth
+		}
+	}
+	if o.registryAuth == nil {
+		o.registryAuth = authn.Anonymous
+	}
+	if o.notaryAuth == nil {
+		o.notaryAuth = authn.Anonymous
+	}
+	return nil
+}
+
+// WithConfigDir makes the repository read its trust config from dir and keep
+// its trust data under it. Without this option NOTARY_CONFIG_DIR is used,
+// falling back to ~/.notary.
+func WithConfigDir(dir string) Option {
+	return func(o *options) error {
+		if dir == "" {
+			return errors.New("config directory must not be empty")
+		}
+		o.configDir = dir
+		return nil
+	}
+}
+
+// WithCacheDir makes the repository cache the TUF metadata it downloads from
+// the notary server under dir, which is created if missing, instead of under
+// the trust directory of the config directory, e.g. to keep the cache on a
+// writable tmpfs next to a read-only config directory.
+func WithCacheDir(dir string) Option {
+	return func(o *options) error {
+		if dir == "" {
+			return errors.New("cache directory must not be empty")
+		}
+		o.cacheDir = dir
+		return nil
+	}
+}
+
+// WithRegistryAuth makes registry calls authenticate with auth instead of
+// anonymously.
+func WithRegistryAuth(auth authn.Authenticator) Option {
+	return func(o *options) error {
+		if auth == nil {
+			return errors.New("registry authenticator must not be nil")
+		}
+		o.registryAuth = auth
+		return nil
+	}
+}
+
+// WithTokenRefresh makes registry uploads that are rejected with 401
+// Unauthorized, e.g. because the access token of the registry authenticator
+// expired in the middle of a multi-gigabyte push, call refresh for a fresh
+// authenticator and retry the upload once with it. The retry only uploads the
+// blobs the registry does not have yet, and later calls keep using the fresh
+// authenticator.
+func WithTokenRefresh(refresh func() (authn.Authenticator, error)) Option {
+	return func(o *options) error {
+		if refresh == nil {
+			return errors.New("token refresh function must not be nil")
+		}
+		o.tokenRefresh = refresh
+		return nil
+	}
+}
+
+// WithDefaultKeychain makes the registry and notary server calls authenticate
+// with the credentials the docker config, or one of its credential helpers,
+// holds for the registry of the reference, e.g. those of gcloud for GCR.
+// Authenticators given with WithRegistryAuth or WithNotaryAuth take
+// precedence.
+func WithDefaultKeychain() Option {
+	return func(o *options) error {
+		o.keychain = authn.DefaultKeychain
+		return nil
+	}
+}
+
+// WithNotaryAuth makes notary server calls authenticate with auth instead of
+// anonymously.
+func WithNotaryAuth(auth authn.Authenticator) Option {
+	return func(o *options) error {
+		if auth == nil {
+			return errors.New("notary authenticator must not be nil")
+		}
+		o.notaryAuth = auth
+		return nil
+	}
+}
+
+// WithTrustServer makes every notary call of the repository go to the notary
+// server at serverURL, which must be an https URL, instead of the server_url
+// of the trust config or the one derived from the registry.
+//
+// A repository uses a single trust server for all of its metadata, including
+// that of delegations, as notary does not federate trust data across
+// servers. Giving WithTrustServer several times with different URLs is an
+// error; use ForTrustServer to verify against a mirror.
+func WithTrustServer(serverURL string) Option {
+	return func(o *options) error {
+		if err := checkTrustServer(serverURL); err != nil {
+			return err
+		}
+		if o.trustServer != "" && o.trustServer != serverURL {
+			return errors.Errorf("conflicting trust servers %s and %s, a repository uses a single trust server", o.trustServer, serverURL)
+		}
+		o.trustServer = serverURL
+		return nil
+	}
+}
+
+// checkTrustServer returns an error unless serverURL is an https URL.
+func checkTrustServer(serverURL string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.Errorf("valid https URL required for trust server, got %s", serverURL)
+	}
+	return nil
+}
+
+// WithLogger routes the diagnostics of the repository, including those of the
+// underlying notary repository setup, to logger instead of the standard
+// logrus logger.
+func WithLogger(logger trust.Logger) Option {
+	return func(o *options) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithPassphraseRetriever makes the repository obtain the passphrases of its
+// signing keys from retriever, e.g. backed by a secrets manager, whenever keys
+// are generated, used for signing or rotated. Without this option the
+// passphrases of the trust config are used, falling back to a prompt.
+func WithPassphraseRetriever(retriever notary.PassRetriever) Option {
+	return func(o *options) error {
+		if retriever == nil {
+			return errors.New("passphrase retriever must not be nil")
+		}
+		o.passRetriever = retriever
+		return nil
+	}
+}
+
+// WithRetryPolicy retries notary server calls up to maxRetries times when the
+// server answers 502, 503 or 504 or the connection is reset, waiting backoff
+// before the first retry and doubling it after each attempt. Only metadata
+// downloads and publishes are retried. Retries are reported as warnings to
+// the logger. By default no call is retried.
+func WithRetryPolicy(maxRetries int, backoff time.Duration) Option {
+	return func(o *options) error {
+		if maxRetries < 0 || backoff < 0 {
+			return errors.Errorf("invalid retry policy: %d retries with %s backoff", maxRetries, backoff)
+		}
+		o.retry = trust.RetryPolicy{MaxRetries: maxRetries, Backoff: backoff}
+		return nil
+	}
+}
+
+// WithPushChunkSize uploads blobs to the registry in chunks of at most size
+// bytes instead of in a single request, e.g. for large layers behind proxies
+// limiting the request size or dropping long running connections.
+func WithPushChunkSize(size int64) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return errors.Errorf("invalid push chunk size %d", size)
+		}
+		o.pushChunkSize = size
+		return nil
+	}
+}
+
+// WithPushRetry retries the upload of each blob up to n times when it fails
+// with a temporary error, such as a dropped connection or a 5xx answer, with
+// an exponential backoff starting at one second. Blobs are retried one by one
+// and the registry is asked for each blob before it is uploaded, so a failed
+// push never uploads a completed layer again, while a chunked blob is
+// uploaded again from its first chunk. By default a blob is retried twice.
+// Registry pushes are not subject to WithRetryPolicy, which only governs
+// notary server calls.
+func WithPushRetry(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return errors.Errorf("invalid push retry count %d", n)
+		}
+		o.pushRetries = &n
+		return nil
+	}
+}
+
+// WithRegistryTransport makes registry pushes use transport, e.g. one with a
+// custom TLS config or proxy. Authentication is added on top of it.
+func WithRegistryTransport(transport http.RoundTripper) Option {
+	return func(o *options) error {
+		if transport == nil {
+			return errors.New("registry transport must not be nil")
+		}
+		o.registryTransport = transport
+		return nil
+	}
+}
+
+// WithNotaryTransport makes notary server calls use transport instead of the
+// default one built from the certificates of the trust directory.
+// Authentication is added on top of it.
+func WithNotaryTransport(transport http.RoundTripper) Option {
+	return func(o *options) error {
+		if transport == nil {
+			return errors.New("notary transport must not be nil")
+		}
+		o.notaryTransport = transport
+		return nil
+	}
+}
+
+// WithNotaryRootCAs makes notary server calls trust the certificate
+// authorities of pool, e.g. a private CA, instead of the system roots and the
+// CA certificates of the tls directory, and verify the certificate of the
+// server against them. It has no effect with WithNotaryTransport.
+func WithNotaryRootCAs(pool *x509.CertPool) Option {
+	return func(o *options) error {
+		if pool == nil {
+			return errors.New("notary root CAs must not be nil")
+		}
+		o.notaryRootCAs = pool
+		return nil
+	}
+}
+
+// WithNotaryInsecureSkipVerify decides whether notary server calls skip the
+// verification of the TLS certificate of the server, overriding the default
+// and WithNotaryRootCAs. Skipping it exposes the trust data to tampering by
+// anyone on the network path and is logged as a warning; it is only meant for
+// testing. It has no effect with WithNotaryTransport.
+func WithNotaryInsecureSkipVerify(skip bool) Option {
+	return func(o *options) error {
+		o.insecureSkipVerify = &skip
+		return nil
+	}
+}
+
+// WithDeferredPublish switches the repository into staging mode: SignImage,
+// SignImageTags, TrustPush, TrustPushIndex, RevokeTag, AddDelegation,
+// WitnessDelegation and the delegation removals only add their changes to
+// the local changelist, and nothing reaches the notary server until Publish
+// is called. Staged changes survive failed operations and are published
+// together, in one round trip. RotateKey and InitTrust always publish at
+// once.
+func WithDeferredPublish() Option {
+	return func(o *options) error {
+		o.deferPublish = true
+		return nil
+	}
+}
+
+// WithDryRun makes TrustPush, SignImage, RevokeTag and their variants compute
+// and log the changes they would publish without pushing anything to the
+// registry or publishing to the notary server. A repository without trust
+// data is not initialized either. The computed changes of the last operation
+// are returned by DryRunChanges, and TrustPushResult returns the target that
+// would be signed.
+func WithDryRun() Option {
+	return func(o *options) error {
+		o.dryRun = true
+		return nil
+	}
+}
+
+// WithMetadataExpiry makes the metadata of role, one of the root, targets,
+// snapshot and timestamp roles, valid for d after it is signed, e.g. short
+// for ephemeral repositories and long for stable base images, instead of
+// notary's defaults of a year, 90 days, 7 days and a day. The targets period
+// also applies to delegations. It only affects metadata signed locally, so
+// not the timestamp, nor the snapshot when it is managed by the notary
+// server, whose expiry the server decides.
+func WithMetadataExpiry(role data.RoleName, d time.Duration) Option {
+	return func(o *options) error {
+		if !data.IsBaseRole(role) {
+			return errors.Errorf("invalid role %s, only the expiry of root, targets, snapshot and timestamp metadata can be set", role)
+		}
+		if d <= 0 {
+			return errors.Errorf("invalid %s metadata expiry %s, must be positive", role, d)
+		}
+		expiry := make(map[data.RoleName]time.Duration, len(o.metadataExpiry)+1)
+		for r, d := range o.metadataExpiry {
+			expiry[r] = d
+		}
+		expiry[role] = d
+		o.metadataExpiry = expiry
+		return nil
+	}
+}
+
+// WithIgnoreExpiry is UNSAFE: it makes VerifyTagIgnoringExpiry return the
+// target of a tag even when the trust data has expired, for forensic
+// inspection of what the repository claimed. Signatures are still verified.
+// Every other verification keeps rejecting expired trust data, and it must
+// never be used to decide whether to run an image.
+func WithIgnoreExpiry() Option {
+	return func(o *options) error {
+		o.ignoreExpiry = true
+		return nil
+	}
+}
+
+// WithVerifyCache makes the repository remember, in memory, up to size
+// successful verifications of tags and digests, keyed by trust server, GUN,
+// tag or digest and verification policy, and answer repeated ones without
+// calling the notary server. An entry is kept for ttl at most, and never past
+// the expiry of the metadata it was verified with, so ttl bounds how long a
+// revocation made elsewhere may go unnoticed; the entries of a GUN are
+// dropped as soon as a repository sharing the cache publishes changes to it
+// or deletes its trust data. Failed verifications are not cached.
+//
+// The cache is safe for concurrent use and shared by all the repositories
+// created with the returned option, e.g. one per admission request. A
+// repository only uses the verifications of repositories with the same
+// pinned or accepted roots, root pin store, required signer keys and trust
+// anchor, so differently configured repositories may share the cache.
+func WithVerifyCache(size int, ttl time.Duration) Option {
+	var cache *verifyCache
+	if size > 0 && ttl > 0 {
+		cache = newVerifyCache(size, ttl)
+	}
+	return func(o *options) error {
+		if size <= 0 {
+			return errors.Errorf("verify cache size %d must be positive", size)
+		}
+		if ttl <= 0 {
+			return errors.Errorf("verify cache TTL %s must be positive", ttl)
+		}
+		o.verifyCache = cache
+		return nil
+	}
+}
+
+// WithPrereleases makes LatestSignedSemver consider prerelease versions, such
+// as 1.2.0-rc.1, which it leaves out by default. A prerelease still ranks
+// below the release of the same version, as semantic versioning requires.
+func WithPrereleases() Option {
+	return func(o *options) error {
+		o.prereleases = true
+		return nil
+	}
+}
+
+// WithNoAutoInit makes TrustPush, SignImage and their variants fail with
+// ErrUninitialized on a repository without trust data instead of generating
+// its root key and initializing it, so that initialization stays a separate,
+// privileged step, e.g. InitTrust run by whoever holds the root key. Pushes
+// check the repository before uploading anything to the registry. By default
+// the first signature initializes the repository.
+func WithNoAutoInit() Option {
+	return func(o *options) error {
+		o.noAutoInit = true
+		return nil
+	}
+}
+
+// WithServerManagedSnapshot makes the notary server generate and hold the
+// snapshot key of repositories initialized by InitTrust or by a first
+// signature, so that signers, e.g. CI runners, only need the root and
+// targets keys. Without it the snapshot key is generated locally and has to
+// be held by everyone publishing changes. See RotateSnapshotToServer for the
+// trust implications and for moving the snapshot key of a repository
+// initialized with a local one.
+func WithServerManagedSnapshot() Option {
+	return func(o *options) error {
+		for _, role := range o.serverManagedRoles {
+			if role == data.CanonicalSnapshotRole {
+				return nil
+			}
+		}
+		o.serverManagedRoles = append(o.serverManagedRoles, data.CanonicalSnapshotRole)
+		return nil
+	}
+}
+
+// WithExpiryWarning makes verification log a warning for every base role
+// whose metadata expires within window, so that it can be re-signed before
+// verification starts failing with ErrExpiredMetadata. By default no warning
+// is logged.
+func WithExpiryWarning(window time.Duration) Option {
+	return func(o *options) error {
+		if window < 0 {
+			return errors.Errorf("invalid expiry warning window %s", window)
+		}
+		o.expiryWarning = window
+		return nil
+	}
+}
+
+// WithMaxStaleness makes Verify, VerifyTag and the other tag verifications
+// use the cached trust data without a notary server round trip if it was
+// fetched within d, e.g. to spare the notary server in a high traffic
+// admission controller. The cached data must still verify and not be
+// expired; otherwise, or once it is older than d, it is fetched again. By
+// default it is fetched on every verification.
+func WithMaxStaleness(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.Errorf("invalid maximum staleness %s", d)
+		}
+		o.maxStaleness = d
+		return nil
+	}
+}
+
+// WithPushProgress makes registry pushes report their progress to progress,
+// e.g. to render an upload progress bar. Each update carries the bytes pushed
+// so far and the total, and a failed push ends with an update carrying the
+// error. Updates are delivered in order from a single goroutine.
+func WithPushProgress(progress func(update v1.Update)) Option {
+	return func(o *options) error {
+		if progress == nil {
+			return errors.New("push progress callback must not be nil")
+		}
+		o.pushProgress = progress
+		return nil
+	}
+}
+
+// WithImmutableTags makes signing fail with ErrTagAlreadySigned when the tag
+// is already signed with another digest, instead of overwriting its target.
+// TrustPush and TrustPushIndex check this before pushing to the registry, so
+// the tag is left untouched there too. Signing a tag again with the digest it
+// is already signed with is allowed.
+func WithImmutableTags() Option {
+	return func(o *options) error {
+		o.immutableTags = true
+		return nil
+	}
+}
+
+// WithKeyAlgorithm makes the repository generate its targets, snapshot and
+// delegation keys, e.g. when InitTrust or the first signature initializes the
+// repository or when a key is rotated, with algorithm, data.ECDSAKey or
+// data.ED25519Key. Root keys are always ECDSA keys, as notary only issues the
+// root certificate for those. Verification accepts keys of any algorithm.
+func WithKeyAlgorithm(algorithm string) Option {
+	return func(o *options) error {
+		switch algorithm {
+		case data.ECDSAKey, data.ED25519Key:
+		default:
+			return errors.Errorf("unsupported key algorithm %q, must be %s or %s", algorithm, data.ECDSAKey, data.ED25519Key)
+		}
+		o.keyAlgorithm = algorithm
+		return nil
+	}
+}
+
+// WithInMemoryKeyStore keeps the signing keys of the repository in memory
+// for the lifetime of the process instead of the trust directory, e.g. in
+// short-lived CI containers whose disks must not hold keys. The keys are
+// shared by the repositories derived from this one, such as those of
+// ForTrustServer, and lost with the process, so generated keys must be
+// escrowed, e.g. with WithKeyGenHook and ExportRootKey.
+func WithInMemoryKeyStore() Option {
+	return func(o *options) error {
+		o.inMemoryKeyStore = true
+		return nil
+	}
+}
+
+// WithTrustAnchor makes verification chain the trust data to rootJSON, root
+// metadata delivered out of band, instead of trusting the root metadata first
+// seen on the notary server. The cached root metadata is replaced by rootJSON
+// unless it is a later version signed by the root keys of rootJSON, so that
+// every root rotation since the anchor is verified, and verification fails
+// with ErrSignatureVerification when the trust data does not chain to it.
+// rootJSON must be signed by its own root keys.
+func WithTrustAnchor(rootJSON []byte) Option {
+	return func(o *options) error {
+		if _, err := trust.ParseTrustAnchor(rootJSON); err != nil {
+			return err
+		}
+		o.trustAnchor = rootJSON
+		return nil
+	}
+}
+
+// WithKeyGenHook makes the repository call hook with the role and ID of every
+// signing key it generates in the local key store, when InitTrust or the
+// first signature initializes the repository or when a key is rotated. The
+// hook runs synchronously right after the key is created, before any
+// metadata signed with it is published, e.g. to escrow the key in a KMS.
+func WithKeyGenHook(hook func(role data.RoleName, keyID string)) Option {
+	return func(o *options) error {
+		if hook == nil {
+			return errors.New("key generation hook must not be nil")
+		}
+		o.keyGenHook = hook
+		return nil
+	}
+}
+
+// WithPinnedRoot makes verification fail with ErrRootKeyMismatch unless keyID
+// is one of the root keys published for the repository, as returned by
+// RootKeyInfo.
+func WithPinnedRoot(keyID string) Option {
+	return func(o *options) error {
+		if keyID == "" {
+			return errors.New("pinned root key ID must not be empty")
+		}
+		o.pinnedRoots = []string{keyID}
+		return nil
+	}
+}
+
+// WithAcceptedRoots is like WithPinnedRoot but accepts any of keyIDs, e.g. the
+// old and the new root key during a root key rotation, so that clients need
+// not all switch at once. Verification fails with ErrRootKeyMismatch only
+// when none of them is a root key of the repository.
+func WithAcceptedRoots(keyIDs ...string) Option {
+	return func(o *options) error {
+		if len(keyIDs) == 0 {
+			return errors.New("at least one accepted root key ID is required")
+		}
+		for _, keyID := range keyIDs {
+			if keyID == "" {
+				return errors.New("accepted root key ID must not be empty")
+			}
+		}
+		o.pinnedRoots = append([]string(nil), keyIDs...)
+		return nil
+	}
+}
+
+// WithRequiredSignerKeys makes verification fail with
+// ErrRequiredSignerMissing unless a valid signature by one of keyIDs, e.g.
+// the release signing keys of a delegation, is on metadata of a trusted role
+// that signed the trusted target: the top level targets role, the releases
+// delegation or a delegation below it. Signatures by other delegations do not
+// count. Unlike WithPinnedRoot, it constrains who signed the target rather
+// than who controls the repository.
+func WithRequiredSignerKeys(keyIDs ...string) Option {
+	return func(o *options) error {
+		if len(keyIDs) == 0 {
+			return errors.New("at least one required signer key ID is required")
+		}
+		for _, keyID := range keyIDs {
+			if keyID == "" {
+				return errors.New("required signer key ID must not be empty")
+			}
+		}
+		o.requiredSigners = append([]string(nil), keyIDs...)
+		return nil
+	}
+}
+
+// WithRootChangeHook makes the repository call hook with the old and the new
+// root key ID, as returned by RootKeyInfo, when a verification finds the root
+// key of the repository to differ from the one of the root metadata cached
+// before, e.g. to alert on unexpected root key rotations without pinning the
+// root. The hook runs synchronously, after notary accepted the new root.
+func WithRootChangeHook(hook func(oldKeyID, newKeyID string)) Option {
+	return func(o *options) error {
+		if hook == nil {
+			return errors.New("root change hook must not be nil")
+		}
+		o.rootChangeHook = hook
+		return nil
+	}
+}
+
+// PinRootOnFirstUse gives verification trust on first use semantics for the
+// root key: the first verification of a repository records its root key in
+// the JSON file at storePath, and later verifications fail with
+// ErrRootKeyMismatch if that key is no longer a root key of the repository.
+// A legitimate root key rotation therefore requires removing the repository
+// from the file.
+func PinRootOnFirstUse(storePath string) Option {
+	return func(o *options) error {
+		if storePath == "" {
+			return errors.New("root pin store path must not be empty")
+		}
+		o.rootPinStore = storePath
+		return nil
+	}
+}
+
+// WithObserver makes the repository report the duration and outcome of its
+// push, sign, verify and revoke operations to observer, e.g. to wire them to
+// a metrics library. By default they are not reported.
+func WithObserver(observer Observer) Option {
+	return func(o *options) error {
+		if observer == nil {
+			return errors.New("observer must not be nil")
+		}
+		o.observer = observer
+		return nil
+	}
+}
+
+// WithVerifyHook makes the repository call hook with the tag and outcome of
+// every verification when it completes, whether it succeeds or fails, e.g. to
+// record each decision in an audit log. For VerifyDigest tag is the verified
+// digest. The hook runs synchronously, with the repository locked.
+func WithVerifyHook(hook func(tag string, target *client.Target, err error)) Option {
+	return func(o *options) error {
+		if hook == nil {
+			return errors.New("verify hook must not be nil")
+		}
+		o.verifyHook = hook
+		return nil
+	}
+}
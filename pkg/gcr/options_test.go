@@ -0,0 +1,278 @@
+package gcr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestNewTrustedGcrRepositoryWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "gcr-config.json"), []byte(`{"server_url":"https://notary.example.com"}`), 0600))
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+
+	registryAuth := &authn.Basic{Username: "registry"}
+	notaryAuth := &authn.Basic{Username: "notary"}
+	repo, err := NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir), WithRegistryAuth(registryAuth), WithNotaryAuth(notaryAuth))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(repo.config.RootPath, dir))
+	assert.Check(t, is.Equal(repo.config.ServerUrl, "https://notary.example.com"))
+	assert.Check(t, repo.registryAuth == registryAuth)
+	assert.Check(t, repo.notaryAuth == notaryAuth)
+
+	legacy, err := NewTrustedGcrRepository(dir, ref, registryAuth, notaryAuth)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(legacy.config.RootPath, dir))
+	assert.Check(t, legacy.registryAuth == registryAuth)
+	assert.Check(t, legacy.notaryAuth == notaryAuth)
+
+	repo, err = NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir))
+	assert.NilError(t, err)
+	assert.Check(t, repo.registryAuth == authn.Anonymous)
+	assert.Check(t, repo.notaryAuth == authn.Anonymous)
+
+	_, err = NewTrustedGcrRepositoryWithOptions(ref, WithRegistryAuth(nil))
+	assert.Check(t, is.ErrorContains(err, "registry authenticator must not be nil"))
+}
+
+type staticKeychain struct {
+	auth authn.Authenticator
+}
+
+func (k staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.auth, nil
+}
+
+func TestWithDefaultKeychain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "gcr-config.json"), []byte(`{}`), 0600))
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+
+	o, err := makeOptions(WithDefaultKeychain())
+	assert.NilError(t, err)
+	assert.Check(t, o.keychain == authn.DefaultKeychain)
+
+	// stand in for the docker config of the machine running the test
+	keychainAuth := &authn.Basic{Username: "oauth2accesstoken"}
+	withStaticKeychain := func(o *options) error {
+		o.keychain = staticKeychain{keychainAuth}
+		return nil
+	}
+	repo, err := NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir), withStaticKeychain)
+	assert.NilError(t, err)
+	assert.Check(t, repo.registryAuth == keychainAuth)
+	assert.Check(t, repo.notaryAuth == keychainAuth)
+
+	// explicit authenticators take precedence
+	notaryAuth := &authn.Basic{Username: "notary"}
+	repo, err = NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir), WithNotaryAuth(notaryAuth), withStaticKeychain)
+	assert.NilError(t, err)
+	assert.Check(t, repo.registryAuth == keychainAuth)
+	assert.Check(t, repo.notaryAuth == notaryAuth)
+}
+
+func TestWithTrustServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notary")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "gcr-config.json"), []byte(`{"server_url":"https://notary.example.com"}`), 0600))
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+
+	repo, err := NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir), WithTrustServer("https://notary.internal:4443"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(repo.config.ServerUrl, "https://notary.internal:4443"))
+
+	for _, serverURL := range []string{"http://notary.internal", "notary.internal", "https://"} {
+		_, err = NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir), WithTrustServer(serverURL))
+		assert.Check(t, is.ErrorContains(err, "valid https URL required"), serverURL)
+	}
+
+	_, err = NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir),
+		WithTrustServer("https://notary.internal:4443"), WithTrustServer("https://notary-mirror.internal"))
+	assert.Check(t, is.ErrorContains(err, "conflicting trust servers"))
+
+	mirror, err := repo.ForTrustServer("https://notary-mirror.internal")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(mirror.config.ServerUrl, "https://notary-mirror.internal"))
+	assert.Check(t, is.Equal(repo.config.ServerUrl, "https://notary.internal:4443"))
+	_, err = repo.ForTrustServer("http://notary-mirror.internal")
+	assert.Check(t, is.ErrorContains(err, "valid https URL required"))
+}
+
+func TestWithMetadataExpiry(t *testing.T) {
+	o, err := makeOptions(
+		WithMetadataExpiry(data.CanonicalTargetsRole, 24*time.Hour),
+		WithMetadataExpiry(data.CanonicalSnapshotRole, time.Hour),
+	)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(o.metadataExpiry, map[data.RoleName]time.Duration{
+		data.CanonicalTargetsRole:  24 * time.Hour,
+		data.CanonicalSnapshotRole: time.Hour,
+	}))
+
+	_, err = makeOptions(WithMetadataExpiry(data.CanonicalTargetsRole, 0))
+	assert.Check(t, is.ErrorContains(err, "must be positive"))
+	_, err = makeOptions(WithMetadataExpiry(trust.ReleasesRole, time.Hour))
+	assert.Check(t, is.ErrorContains(err, "invalid role targets/releases"))
+}
+
+func TestNewTrustedGcrRepositoryFromConfig(t *testing.T) {
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	cfg := &trust.Config{RootPath: "/var/lib/notary", ServerUrl: "https://notary.example.com"}
+	registryAuth := &authn.Basic{Username: "registry"}
+
+	repo, err := NewTrustedGcrRepositoryFromConfig(ref, cfg, registryAuth, nil, WithTrustServer("https://notary.internal"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(repo.config.RootPath, "/var/lib/notary"))
+	assert.Check(t, is.Equal(repo.config.ServerUrl, "https://notary.internal"))
+	assert.Check(t, repo.registryAuth == registryAuth)
+	assert.Check(t, repo.notaryAuth == authn.Anonymous)
+	// the config of the caller is left alone
+	assert.Check(t, is.Equal(cfg.ServerUrl, "https://notary.example.com"))
+	assert.Check(t, cfg.Logger == nil)
+
+	_, err = NewTrustedGcrRepositoryFromConfig(ref, nil, registryAuth, nil)
+	assert.Check(t, is.ErrorContains(err, "trust config must not be nil"))
+}
+
+func TestWithCacheDir(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "notary-cache")
+	assert.NilError(t, err)
+	defer os.RemoveAll(cacheDir)
+	cacheDir = filepath.Join(cacheDir, "tuf")
+
+	repo, _, cleanup := newUninitializedRepository(t,
+		WithCacheDir(cacheDir),
+		WithDeferredPublish(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+	repo.serverManagedRoles = nil
+	_, err = os.Stat(cacheDir)
+	assert.NilError(t, err)
+
+	// initializing the repository caches its new root metadata
+	assert.NilError(t, repo.SignImage(empty.Image))
+	_, err = os.Stat(filepath.Join(cacheDir, "gcr.io", "project", "never-signed", "metadata", "root.json"))
+	assert.Check(t, err)
+	_, err = os.Stat(filepath.Join(repo.config.RootPath, "trust", "tuf", "gcr.io", "project", "never-signed", "metadata"))
+	assert.Check(t, os.IsNotExist(err))
+}
+
+func TestPingNotary(t *testing.T) {
+	var status int
+	var s *httptest.Server
+	s = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/" && status == http.StatusUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+s.URL+`/token",service="notary"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/":
+		default:
+			w.WriteHeader(status)
+		}
+	}))
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "notary")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "gcr-config.json"), []byte(`{}`), 0600))
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	repo, err := NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir), WithTrustServer(s.URL), WithNotaryTransport(s.Client().Transport))
+	assert.NilError(t, err)
+
+	for _, tc := range []struct {
+		status int
+		want   error
+	}{
+		{status: http.StatusOK},
+		// a repository without trust data
+		{status: http.StatusNotFound},
+		{status: http.StatusUnauthorized, want: ErrNotaryUnauthorized},
+		{status: http.StatusInternalServerError, want: ErrNotaryServerError},
+	} {
+		status = tc.status
+		err := repo.PingNotary(context.Background())
+		if tc.want == nil {
+			assert.Check(t, err, "status %d", tc.status)
+		} else {
+			assert.Check(t, errors.Is(err, tc.want), "status %d: unexpected error: %v", tc.status, err)
+		}
+	}
+
+	s.Close()
+	err = repo.PingNotary(context.Background())
+	assert.Check(t, errors.Is(err, ErrNotaryUnreachable), "unexpected error: %v", err)
+}
+
+func TestReferenceLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.Formatter = &logrus.JSONFormatter{}
+	dir, err := ioutil.TempDir("", "notary")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "gcr-config.json"), []byte(`{}`), 0600))
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	repo, err := NewTrustedGcrRepositoryWithOptions(ref, WithConfigDir(dir), WithLogger(logger))
+	assert.NilError(t, err)
+
+	fields := func() map[string]interface{} {
+		var entry map[string]interface{}
+		assert.NilError(t, json.Unmarshal(buf.Bytes(), &entry))
+		buf.Reset()
+		return entry
+	}
+	repo.logger.Infof("message")
+	entry := fields()
+	assert.Check(t, is.Equal(entry["gun"], "gcr.io/project/image"))
+	assert.Check(t, is.Equal(entry["reference"], "gcr.io/project/image:latest"))
+	assert.Check(t, is.Equal(entry["tag"], "latest"))
+
+	manifest := sha256.Sum256([]byte("manifest"))
+	repo.targetLogger(&client.Target{Name: "stable", Hashes: data.Hashes{"sha256": manifest[:]}}).Infof("message")
+	entry = fields()
+	assert.Check(t, is.Equal(entry["tag"], "stable"))
+	assert.Check(t, is.Equal(entry["digest"], "sha256:"+hex.EncodeToString(manifest[:])))
+
+	other, err := name.ParseReference("gcr.io/project/other:v1")
+	assert.NilError(t, err)
+	repo.forReference(other, nil, nil).logger.Infof("message")
+	entry = fields()
+	assert.Check(t, is.Equal(entry["gun"], "gcr.io/project/other"))
+	assert.Check(t, is.Equal(entry["tag"], "v1"))
+}
@@ -0,0 +1,84 @@
+package gcr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// checkPinnedRoot returns ErrRootKeyMismatch unless one of pinned is one of
+// the root keys of root.
+func checkPinnedRoot(root *data.SignedRoot, gun string, pinned ...string) error {
+	role, ok := root.Signed.Roles[data.CanonicalRootRole]
+	if ok {
+		for _, keyID := range role.KeyIDs {
+			for _, p := range pinned {
+				if keyID == p {
+					return nil
+				}
+			}
+		}
+	}
+	if len(pinned) == 1 {
+		return errors.Wrapf(ErrRootKeyMismatch, "%s is pinned to root key %s", gun, pinned[0])
+	}
+	return errors.Wrapf(ErrRootKeyMismatch, "%s is pinned to root keys %s", gun, strings.Join(pinned, ", "))
+}
+
+// pinRootOnFirstUse checks root against the root key recorded for gun in the
+// pin store at storePath, recording the root key of root if there is none.
+func pinRootOnFirstUse(root *data.SignedRoot, gun string, storePath string) error {
+	pins, err := readRootPins(storePath)
+	if err != nil {
+		return err
+	}
+	if pinned, ok := pins[gun]; ok {
+		return checkPinnedRoot(root, gun, pinned)
+	}
+	key, err := rootKey(root)
+	if err != nil {
+		return err
+	}
+	pins[gun] = key.ID()
+	return writeRootPins(storePath, pins)
+}
+
+// readRootPins reads the root key pinned for each GUN from the pin store at
+// storePath, which does not need to exist yet.
+func readRootPins(storePath string) (map[string]string, error) {
+	pins := make(map[string]string)
+	raw, err := ioutil.ReadFile(storePath)
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &pins); err != nil {
+		return nil, errors.Wrapf(err, "invalid root pin store %s", storePath)
+	}
+	return pins, nil
+}
+
+// writeRootPins replaces the pin store at storePath with pins.
+func writeRootPins(storePath string, pins map[string]string) error {
+	raw, err := json.MarshalIndent(pins, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(storePath), 0700); err != nil {
+		return err
+	}
+	// write to a temporary file first so that a failed write does not lose
+	// the pins recorded so far
+	tmp := storePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, storePath)
+}
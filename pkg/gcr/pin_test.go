@@ -0,0 +1,65 @@
+package gcr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func testRoot(t *testing.T, rootKeys ...data.PublicKey) *data.SignedRoot {
+	keys := make(map[string]data.PublicKey)
+	var keyIDs []string
+	for _, key := range rootKeys {
+		keys[key.ID()] = key
+		keyIDs = append(keyIDs, key.ID())
+	}
+	root, err := data.NewRoot(keys, map[data.RoleName]*data.RootRole{
+		data.CanonicalRootRole: {KeyIDs: keyIDs, Threshold: 1},
+	}, false)
+	assert.NilError(t, err)
+	return root
+}
+
+func TestPinRootOnFirstUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pins")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	store := filepath.Join(dir, "pins", "roots.json")
+
+	original := data.NewPublicKey(data.ECDSAx509Key, []byte("original"))
+	swapped := data.NewPublicKey(data.ECDSAx509Key, []byte("swapped"))
+
+	// the first use records the root key
+	assert.NilError(t, pinRootOnFirstUse(testRoot(t, original), "gcr.io/project/image", store))
+	pins, err := readRootPins(store)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(pins, map[string]string{"gcr.io/project/image": original.ID()}))
+
+	assert.Check(t, pinRootOnFirstUse(testRoot(t, original), "gcr.io/project/image", store))
+	err = pinRootOnFirstUse(testRoot(t, swapped), "gcr.io/project/image", store)
+	assert.Check(t, errors.Is(err, ErrRootKeyMismatch))
+
+	// other repositories are pinned on their own
+	assert.NilError(t, pinRootOnFirstUse(testRoot(t, swapped), "gcr.io/project/other", store))
+}
+
+func TestCheckPinnedRoot(t *testing.T) {
+	a := data.NewPublicKey(data.ECDSAx509Key, []byte("a"))
+	b := data.NewPublicKey(data.ECDSAx509Key, []byte("b"))
+	assert.Check(t, checkPinnedRoot(testRoot(t, a, b), "gcr.io/project/image", b.ID()))
+	err := checkPinnedRoot(testRoot(t, a), "gcr.io/project/image", b.ID())
+	assert.Check(t, errors.Is(err, ErrRootKeyMismatch))
+
+	// during a rotation either the old or the new root key is accepted
+	c := data.NewPublicKey(data.ECDSAx509Key, []byte("c"))
+	assert.Check(t, checkPinnedRoot(testRoot(t, a), "gcr.io/project/image", a.ID(), b.ID()))
+	assert.Check(t, checkPinnedRoot(testRoot(t, b), "gcr.io/project/image", a.ID(), b.ID()))
+	err = checkPinnedRoot(testRoot(t, c), "gcr.io/project/image", a.ID(), b.ID())
+	assert.Check(t, errors.Is(err, ErrRootKeyMismatch))
+}
@@ -0,0 +1,62 @@
+package gcr
+
+import (
+	"encoding/json"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/client"
+)
+
+// PlatformMetadata is the custom metadata SignImageWithPlatform attaches to a
+// target to record the platform of the signed manifest. It is stored as
+//
+//	{"platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}}
+//
+// where the platform object has the shape of an OCI image index platform.
+type PlatformMetadata struct {
+	Platform *v1.Platform `json:"platform"`
+}
+
+// platformCustom returns the custom metadata recording platform.
+func platformCustom(platform v1.Platform) (json.RawMessage, error) {
+	if platform.OS == "" || platform.Architecture == "" {
+		return nil, errors.New("platform must have an os and an architecture")
+	}
+	return json.Marshal(PlatformMetadata{Platform: &platform})
+}
+
+// TargetPlatform returns the platform recorded in the custom metadata of
+// target by SignImageWithPlatform, or nil if it has none.
+func TargetPlatform(target *client.Target) (*v1.Platform, error) {
+	if target.Custom == nil {
+		return nil, nil
+	}
+	var meta PlatformMetadata
+	if err := json.Unmarshal(*target.Custom, &meta); err != nil {
+		return nil, errors.Wrapf(err, "invalid custom metadata of %s", target.Name)
+	}
+	return meta.Platform, nil
+}
+
+// FilterTargetsByPlatform returns the targets, e.g. those of ListTarget,
+// signed for platform. The os and architecture must match, and so must the
+// variant when platform has one. Targets without a recorded platform are
+// left out.
+func FilterTargetsByPlatform(targets []*client.Target, platform v1.Platform) []*client.Target {
+	var matching []*client.Target
+	for _, t := range targets {
+		p, err := TargetPlatform(t)
+		if err != nil || p == nil {
+			continue
+		}
+		if p.OS != platform.OS || p.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && p.Variant != platform.Variant {
+			continue
+		}
+		matching = append(matching, t)
+	}
+	return matching
+}
@@ -0,0 +1,106 @@
+package gcr
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+)
+
+// pullImage downloads the config and every layer of img, each verified
+// against its digest as it is read, and returns a copy of img served from
+// memory.
+func pullImage(img v1.Image) (v1.Image, error) {
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+	config, err := img.RawConfigFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch config")
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read layers")
+	}
+	pulled := &pulledImage{
+		manifest:  manifest,
+		mediaType: mediaType,
+		config:    config,
+		layers:    make(map[v1.Hash]*pulledLayer, len(layers)),
+	}
+	for _, layer := range layers {
+		l, err := pullLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		pulled.layers[l.digest] = l
+	}
+	return partial.CompressedToImage(pulled)
+}
+
+func pullLayer(layer v1.Layer) (*pulledLayer, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read layer digest")
+	}
+	mediaType, err := layer.MediaType()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read media type of layer %s", digest)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch layer %s", digest)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch layer %s", digest)
+	}
+	return &pulledLayer{data: data, digest: digest, mediaType: mediaType}, nil
+}
+
+// pulledImage is an image whose manifest, config and layers were downloaded.
+type pulledImage struct {
+	manifest  []byte
+	mediaType types.MediaType
+	config    []byte
+	layers    map[v1.Hash]*pulledLayer
+}
+
+func (i *pulledImage) RawManifest() ([]byte, error)        { return i.manifest, nil }
+func (i *pulledImage) MediaType() (types.MediaType, error) { return i.mediaType, nil }
+func (i *pulledImage) RawConfigFile() ([]byte, error)      { return i.config, nil }
+
+func (i *pulledImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	if l, ok := i.layers[digest]; ok {
+		return l, nil
+	}
+	if m, err := partial.Manifest(i); err == nil && digest == m.Config.Digest {
+		return &pulledLayer{data: i.config, digest: digest, mediaType: m.Config.MediaType}, nil
+	}
+	return nil, errors.Errorf("image has no layer %s", digest)
+}
+
+// pulledLayer is a downloaded layer in its compressed form.
+type pulledLayer struct {
+	data      []byte
+	digest    v1.Hash
+	mediaType types.MediaType
+}
+
+func (l *pulledLayer) Digest() (v1.Hash, error)            { return l.digest, nil }
+func (l *pulledLayer) Size() (int64, error)                { return int64(len(l.data)), nil }
+func (l *pulledLayer) MediaType() (types.MediaType, error) { return l.mediaType, nil }
+
+func (l *pulledLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.data)), nil
+}
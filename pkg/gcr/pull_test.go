@@ -0,0 +1,53 @@
+package gcr
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/simonshyu/notary-gcr/trust"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestPullImage(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+	content := []byte("layer content")
+	img, err := partial.CompressedToImage(layerImage{layer: static.NewLayer(content, types.DockerLayer)})
+	assert.NilError(t, err)
+	assert.NilError(t, pushImage(trust.DefaultLogger(), ref, img))
+	digest, err := img.Digest()
+	assert.NilError(t, err)
+
+	fetched, err := remote.Image(ref.Context().Digest(digest.String()))
+	assert.NilError(t, err)
+	pulled, err := pullImage(fetched)
+	assert.NilError(t, err)
+	// everything is served from memory once pulled
+	s.Close()
+
+	pulledDigest, err := pulled.Digest()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(pulledDigest, digest))
+	config, err := pulled.ConfigFile()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(config.RootFS.DiffIDs, 1))
+	layers, err := pulled.Layers()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(layers, 1))
+	rc, err := layers[0].Compressed()
+	assert.NilError(t, err)
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(data, content))
+}
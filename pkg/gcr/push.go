@@ -1,30 +1,39 @@
 package gcr
 
 import (
-	"encoding/hex"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/simonshyu/notary-gcr/trust"
 	"github.com/theupdateframework/notary/client"
 	"github.com/theupdateframework/notary/tuf/data"
 )
 
-func pushImage(ref name.Reference, img v1.Image, auth authn.Authenticator) error {
-	defaultRoundTripper := &http.Transport{
+// defaultRegistryTransport returns the transport used for registry traffic
+// when none is configured.
+func defaultRegistryTransport() http.RoundTripper {
+	return &http.Transport{
 		MaxIdleConns:       10,
 		IdleConnTimeout:    30 * time.Second,
 		DisableCompression: true,
 	}
-	err := remote.Write(ref, img, remote.WithAuth(auth), remote.WithTransport(defaultRoundTripper))
+}
+
+func pushImage(log trust.Logger, ref name.Reference, img v1.Image, options ...remote.Option) error {
+	err := remote.Write(ref, img, options...)
 	if err != nil {
 		log.Errorf("failed to push image: %s", err)
 		return err
@@ -32,78 +41,131 @@ func pushImage(ref name.Reference, img v1.Image, auth authn.Authenticator) error
 	return nil
 }
 
-func pushTrustedReference(ref name.Reference, img v1.Image, auth authn.Authenticator, config *trust.Config) error {
-	// If it is a trusted push we would like to find the target entry which match the
-	// tag provided in the function and then do an AddTarget later.
-	target := &client.Target{}
-
-	digest, err := img.Digest()
-	if err != nil {
-		log.Errorf("failed to get img.Digest: %s", err)
-		return err
+// trustPushAll pushes images to the tags they are keyed by, running up to
+// concurrency registry uploads at a time, and then signs the pushed images in
+// a single notary publish. Failures are returned as TagErrors.
+func (repo *TrustedGcrRepository) trustPushAll(ctx context.Context, images map[string]v1.Image, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
 	}
-	h, err := hex.DecodeString(digest.Hex)
-	if err != nil {
-		log.Errorf("failed to decode digest.Hex: %s", err)
+	if err := repo.checkAutoInit(ctx); err != nil {
 		return err
 	}
-	target.Name = ref.Identifier()
-	target.Hashes = data.Hashes{digest.Algorithm: h}
-	manifest, _ := img.RawManifest()
-	pushResultSize := len(manifest)
-	target.Length = int64(pushResultSize)
+	tags := make([]string, 0, len(images))
+	for tag := range images {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var targets []*client.Target
+	failed := make(TagErrors)
+	for _, tag := range tags {
+		// imageTargets also rejects invalid tags before anything is pushed
+		tagTargets, err := imageTargets(repo.logger, repo.ref, images[tag], []string{tag})
+		if err == nil {
+			// do not move an immutable tag in the registry either
+			err = repo.checkImmutableTags(ctx, tagTargets...)
+		}
+		if err != nil {
+			failed[tag] = err
+			continue
+		}
+		targets = append(targets, tagTargets...)
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		pushed []*client.Target
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(target *client.Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ref := repo.ref.Context().Tag(target.Name)
+			err := repo.upload(ctx, ref, func(options ...remote.Option) error {
+				return pushImage(repo.logger, ref, images[target.Name], options...)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[target.Name] = err
+				return
+			}
+			pushed = append(pushed, target)
+		}(target)
+	}
+	wg.Wait()
 
-	if target == nil {
-		return errors.Errorf("no targets found, please provide a specific tag in order to sign it")
+	if len(pushed) > 0 {
+		sort.Slice(pushed, func(i, j int) bool { return pushed[i].Name < pushed[j].Name })
+		if err := repo.signTargets(ctx, pushed...); err != nil {
+			for _, target := range pushed {
+				failed[target.Name] = err
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return failed
 	}
+	return nil
+}
 
-	repoInfo := ref.Context().Registry
-	repo, err := trust.GetNotaryRepository(ref, auth, &repoInfo, config)
+// imageTarget returns the notary target named targetName for img: the sha256
+// digest and size in bytes of its raw manifest.
+func imageTarget(log trust.Logger, targetName string, img v1.Image) (*client.Target, error) {
+	digest, err := img.Digest()
 	if err != nil {
-		log.Errorf("failed to get notary repository %s", err)
-		return err
+		log.Errorf("failed to get img.Digest: %s", err)
+		return nil, err
 	}
-	log.Info("Signing and pushing trust metadata")
-	_, err = repo.ListTargets()
+	manifest, err := img.RawManifest()
+	if err != nil {
+		log.Errorf("failed to get img.RawManifest: %s", err)
+		return nil, err
+	}
+	return newTarget(log, targetName, digest, int64(len(manifest)))
+}
+
+// stageTargets adds targets to the changelist of notaryRepo, initializing the
+// repository first if it has no trust data yet, unless dryRun is set. With
+// noAutoInit, ErrUninitialized is returned instead of initializing it.
+func stageTargets(log trust.Logger, notaryRepo client.Repository, repoName string, serverManagedRoles []data.RoleName, dryRun, noAutoInit bool, targets ...*client.Target) error {
+	log.Infof("Signing and pushing trust metadata")
+	_, err := notaryRepo.ListTargets()
 
 	switch err.(type) {
 	case client.ErrRepoNotInitialized, client.ErrRepositoryNotExist:
-		keys := repo.GetCryptoService().ListKeys(data.CanonicalRootRole)
-		var rootKeyID string
-		// always select the first root key
-		if len(keys) > 0 {
-			sort.Strings(keys)
-			rootKeyID = keys[0]
+		if noAutoInit {
+			return notaryError(repoName, err)
+		}
+		if dryRun {
+			log.Infof("Dry run: would initialize %s\n", repoName)
 		} else {
-			rootPublicKey, err := repo.GetCryptoService().Create(data.CanonicalRootRole, "", data.ECDSAKey)
-			if err != nil {
+			if err := initializeRepository(notaryRepo, serverManagedRoles); err != nil {
 				log.Errorf("error: %s", err)
+				return err
 			}
-			rootKeyID = rootPublicKey.ID()
+			log.Infof("Finished initializing %s\n", repoName)
 		}
-		// Initialize the notary repository with a remotely managed snapshot key
-		if err := repo.Initialize([]string{rootKeyID}, data.CanonicalSnapshotRole); err != nil {
-			log.Errorf("error: %s", err)
+		for _, target := range targets {
+			if err := notaryRepo.AddTarget(target, data.CanonicalTargetsRole); err != nil {
+				return err
+			}
 		}
-
-		log.Infof("Finished initializing %s\n", ref.Context().Name())
-		err = repo.AddTarget(target, data.CanonicalTargetsRole)
 	case nil:
 		// already initialized and we have successfully downloaded the latest metadata
-		err = addTargetToAllSignableRoles(repo, target)
+		for _, target := range targets {
+			if err := addTargetToAllSignableRoles(notaryRepo, target); err != nil {
+				return err
+			}
+		}
 	default:
-		return trust.NotaryError(repoInfo.Name(), err)
+		return notaryError(repoName, err)
 	}
-
-	if err == nil {
-		err = repo.Publish()
-	}
-
-	if err != nil {
-		log.Warnf("Failed to sign: %s:%s %s\n", ref.Context().Name(), ref.Identifier(), err)
-		return err
-	}
-	log.Infof("Successfully signed %s:%s\n", ref.Context().Name(), ref.Identifier())
 	return nil
 }
 
@@ -119,3 +181,146 @@ func addTargetToAllSignableRoles(repo client.Repository, target *client.Target)
 
 	return repo.AddTarget(target, signableRoles...)
 }
+
+// PushResult describes what pushing an image uploaded to the registry.
+// Layers and the config blob the registry already had, or mounted from
+// another repository, are not uploaded and not counted.
+type PushResult struct {
+	// Digest is the digest of the pushed manifest.
+	Digest v1.Hash
+	// LayersUploaded is the number of layers uploaded.
+	LayersUploaded int
+	// BytesUploaded is the number of blob bytes uploaded, including those
+	// of the config blob.
+	BytesUploaded int64
+}
+
+// uploadRecorder is a registry transport recording the blobs that are
+// uploaded through it.
+type uploadRecorder struct {
+	base http.RoundTripper
+
+	bytes     int64
+	mu        sync.Mutex
+	committed map[string]bool
+}
+
+func (r *uploadRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	upload := strings.Contains(req.URL.Path, "/blobs/uploads/")
+	var sent *countingReader
+	if upload && req.Body != nil && (req.Method == http.MethodPatch || req.Method == http.MethodPut) {
+		sent = &countingReader{ReadCloser: req.Body}
+		req = req.Clone(req.Context())
+		req.Body = sent
+	}
+	resp, err := r.base.RoundTrip(req)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return resp, err
+	}
+	if sent != nil {
+		atomic.AddInt64(&r.bytes, atomic.LoadInt64(&sent.n))
+	}
+	// a blob upload is committed by a PUT carrying its digest
+	if upload && req.Method == http.MethodPut && resp.StatusCode == http.StatusCreated {
+		if digest := req.URL.Query().Get("digest"); digest != "" {
+			r.mu.Lock()
+			if r.committed == nil {
+				r.committed = make(map[string]bool)
+			}
+			r.committed[digest] = true
+			r.mu.Unlock()
+		}
+	}
+	return resp, nil
+}
+
+// result returns the PushResult of pushing img, whose manifest has digest,
+// through r.
+func (r *uploadRecorder) result(digest v1.Hash, img v1.Image) (*PushResult, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := &PushResult{Digest: digest, BytesUploaded: atomic.LoadInt64(&r.bytes)}
+	for _, l := range layers {
+		h, err := l.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if r.committed[h.String()] {
+			res.LayersUploaded++
+		}
+	}
+	return res, nil
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// chunkingTransport is a registry transport splitting each blob upload into
+// PATCH requests of at most size bytes, so that a single request never
+// carries a whole large layer.
+type chunkingTransport struct {
+	base http.RoundTripper
+	size int64
+}
+
+func (t *chunkingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPatch || req.Body == nil || req.Header.Get("Content-Range") != "" ||
+		!strings.Contains(req.URL.Path, "/blobs/uploads/") {
+		return t.base.RoundTrip(req)
+	}
+	defer req.Body.Close()
+	location := req.URL
+	buf := make([]byte, t.size)
+	var offset int64
+	var resp *http.Response
+	for {
+		n, err := io.ReadFull(req.Body, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		chunk := req.Clone(req.Context())
+		chunk.URL = location
+		chunk.Body = ioutil.NopCloser(bytes.NewReader(buf[:n]))
+		chunk.ContentLength = int64(n)
+		chunk.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+		resp, err = t.base.RoundTrip(chunk)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+			// the caller reports the error of the failed chunk
+			return resp, nil
+		}
+		offset += int64(n)
+		if loc := resp.Header.Get("Location"); loc != "" {
+			if location, err = location.Parse(loc); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+	}
+	if resp == nil {
+		// an empty blob is uploaded as is
+		return t.base.RoundTrip(req)
+	}
+	return resp, nil
+}
@@ -0,0 +1,302 @@
+package gcr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/passphrase"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestPushProgress(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+
+	var updates []v1.Update
+	repo := &TrustedGcrRepository{
+		ref:               ref,
+		registryAuth:      authn.Anonymous,
+		registryTransport: defaultRegistryTransport(),
+		logger:            trust.DefaultLogger(),
+		pushProgress:      func(update v1.Update) { updates = append(updates, update) },
+	}
+	options, wait := repo.pushOptions(context.Background())
+	assert.NilError(t, pushImage(repo.logger, ref, empty.Image, options...))
+	wait()
+
+	assert.Assert(t, len(updates) > 0)
+	last := updates[len(updates)-1]
+	assert.Check(t, is.Nil(last.Error))
+	assert.Check(t, is.Equal(last.Complete, last.Total))
+}
+
+func TestRegistryDigest(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+	repo := &TrustedGcrRepository{
+		ref:               ref,
+		registryAuth:      authn.Anonymous,
+		registryTransport: defaultRegistryTransport(),
+		logger:            trust.DefaultLogger(),
+	}
+	assert.NilError(t, pushImage(repo.logger, ref.Context().Tag("v1"), empty.Image, repo.remoteOptions(context.Background())...))
+
+	digest, err := repo.RegistryDigest("v1")
+	assert.NilError(t, err)
+	want, err := empty.Image.Digest()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(digest, want))
+
+	_, err = repo.RegistryDigest("missing")
+	assert.Check(t, err != nil)
+}
+
+func TestTrustPushCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg := registry.New()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodPatch || r.Method == http.MethodPut) && strings.Contains(r.URL.Path, "/blobs/uploads/") {
+			// abort the deploy while a blob is being uploaded
+			cancel()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+
+	repo, _, cleanup := newUninitializedRepository(t,
+		WithDeferredPublish(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+	repo.serverManagedRoles = nil
+	repo.ref = ref
+	repo.registryTransport = defaultRegistryTransport()
+
+	// the config blob of the image is still uploaded
+	err = repo.TrustPushContext(ctx, empty.Image)
+	assert.Check(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+	pending, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(pending, 0))
+	_, err = remote.Head(ref)
+	assert.Check(t, err != nil, "manifest was pushed")
+}
+
+func TestTrustPushAll(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+
+	repo, _, cleanup := newUninitializedRepository(t, WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")))
+	defer cleanup()
+	staging, err := repo.NotaryRepository()
+	assert.NilError(t, err)
+	notaryRepo := &publishingRepository{Repository: staging}
+	repo.notary = notaryRepo
+	repo.ref = ref
+	repo.registryTransport = defaultRegistryTransport()
+
+	images := map[string]v1.Image{"not a tag": empty.Image}
+	for _, tag := range []string{"v1", "v2", "v3", "v4"} {
+		img, err := random.Image(64, 1)
+		assert.NilError(t, err)
+		images[tag] = img
+	}
+	err = repo.TrustPushAll(images, 2)
+	failed, ok := err.(TagErrors)
+	assert.Assert(t, ok, "unexpected error: %v", err)
+	assert.Check(t, is.Len(failed, 1))
+	assert.Check(t, is.ErrorContains(failed["not a tag"], "invalid tag"))
+
+	// the pushed images are signed in a single publish
+	assert.Assert(t, is.Len(notaryRepo.published, 1))
+	assert.Check(t, is.DeepEqual(stagedTargets(notaryRepo.published[0]), []string{"v1", "v2", "v3", "v4"}))
+	for _, tag := range []string{"v1", "v2", "v3", "v4"} {
+		_, err := remote.Head(ref.Context().Tag(tag))
+		assert.Check(t, err, "tag %s", tag)
+	}
+}
+
+func TestUploadTokenRefresh(t *testing.T) {
+	reg := registry.New()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); !ok || password != "fresh" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+
+	refreshes := 0
+	repo := &TrustedGcrRepository{
+		ref: ref,
+		registryAuth: &refreshingAuth{
+			auth: &authn.Basic{Username: "user", Password: "expired"},
+			refresh: func() (authn.Authenticator, error) {
+				refreshes++
+				return &authn.Basic{Username: "user", Password: "fresh"}, nil
+			},
+		},
+		registryTransport: defaultRegistryTransport(),
+		logger:            trust.DefaultLogger(),
+	}
+	push := func(options ...remote.Option) error { return pushImage(repo.logger, ref, empty.Image, options...) }
+	assert.NilError(t, repo.upload(context.Background(), ref, push))
+	assert.Check(t, is.Equal(refreshes, 1))
+	// the fresh credentials are kept
+	assert.NilError(t, repo.upload(context.Background(), ref, push))
+	assert.Check(t, is.Equal(refreshes, 1))
+
+	repo.registryAuth = &authn.Basic{Username: "user", Password: "expired"}
+	err = repo.upload(context.Background(), ref, push)
+	assert.Check(t, isUnauthorized(err), "unexpected error: %v", err)
+}
+
+// layerImage is an image with a single layer.
+type layerImage struct {
+	layer v1.Layer
+}
+
+func (i layerImage) MediaType() (types.MediaType, error) { return types.DockerManifestSchema2, nil }
+
+func (i layerImage) RawConfigFile() ([]byte, error) {
+	diffID, err := i.layer.DiffID()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v1.ConfigFile{RootFS: v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{diffID}}})
+}
+
+func (i layerImage) RawManifest() ([]byte, error) {
+	config, err := i.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+	layer, err := partial.Descriptor(i.layer)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.DockerManifestSchema2,
+		Config:        v1.Descriptor{MediaType: types.DockerConfigJSON, Size: configSize, Digest: configDigest},
+		Layers:        []v1.Descriptor{*layer},
+	})
+}
+
+func (i layerImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	config, err := i.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if configDigest, _, _ := v1.SHA256(bytes.NewReader(config)); h == configDigest {
+		return static.NewLayer(config, types.DockerConfigJSON), nil
+	}
+	return i.layer, nil
+}
+
+func TestUploadRecorder(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+	content := []byte("layer content")
+	img, err := partial.CompressedToImage(layerImage{layer: static.NewLayer(content, types.DockerLayer)})
+	assert.NilError(t, err)
+	digest, err := img.Digest()
+	assert.NilError(t, err)
+	config, err := img.RawConfigFile()
+	assert.NilError(t, err)
+
+	rec := &uploadRecorder{base: defaultRegistryTransport()}
+	assert.NilError(t, pushImage(trust.DefaultLogger(), ref, img, remote.WithTransport(rec)))
+	res, err := rec.result(digest, img)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(res, &PushResult{
+		Digest:         digest,
+		LayersUploaded: 1,
+		BytesUploaded:  int64(len(content) + len(config)),
+	}))
+
+	// a re-push uploads nothing
+	rec = &uploadRecorder{base: defaultRegistryTransport()}
+	assert.NilError(t, pushImage(trust.DefaultLogger(), ref, img, remote.WithTransport(rec)))
+	res, err = rec.result(digest, img)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(res, &PushResult{Digest: digest}))
+}
+
+func TestChunkingTransport(t *testing.T) {
+	var patches int32
+	handler := registry.New()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			atomic.AddInt32(&patches, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+	content := []byte("layer content")
+	img, err := partial.CompressedToImage(layerImage{layer: static.NewLayer(content, types.DockerLayer)})
+	assert.NilError(t, err)
+	config, err := img.RawConfigFile()
+	assert.NilError(t, err)
+
+	chunked := &chunkingTransport{base: defaultRegistryTransport(), size: 4}
+	assert.NilError(t, pushImage(trust.DefaultLogger(), ref, img, remote.WithTransport(chunked)))
+	chunks := func(n int) int32 { return int32((n + 3) / 4) }
+	assert.Check(t, is.Equal(atomic.LoadInt32(&patches), chunks(len(content))+chunks(len(config))))
+
+	pushed, err := remote.Image(ref)
+	assert.NilError(t, err)
+	layers, err := pushed.Layers()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(layers, 1))
+	rc, err := layers[0].Compressed()
+	assert.NilError(t, err)
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(got), string(content)))
+}
@@ -0,0 +1,35 @@
+package gcr
+
+import (
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// refreshTargetName names the placeholder target staged to re-sign a targets
+// role that has no target.
+const refreshTargetName = "notary-gcr-refresh"
+
+// stageRefresh stages changes that leave the targets of the top level targets
+// role as they are but make notary sign the role again on publish, with a new
+// version and expiry.
+func stageRefresh(notaryRepo client.Repository, repoName string) error {
+	targets, err := notaryRepo.ListTargets(data.CanonicalTargetsRole)
+	if err != nil {
+		return notaryError(repoName, err)
+	}
+	for _, t := range targets {
+		if t.Role == data.CanonicalTargetsRole {
+			// adding a target again unchanged still marks the role as modified
+			return notaryRepo.AddTarget(&t.Target, data.CanonicalTargetsRole)
+		}
+	}
+	// the role has no target to add again, so add a placeholder and remove it
+	placeholder := &client.Target{
+		Name:   refreshTargetName,
+		Hashes: data.Hashes{"sha256": make([]byte, 32)},
+	}
+	if err := notaryRepo.AddTarget(placeholder, data.CanonicalTargetsRole); err != nil {
+		return err
+	}
+	return notaryRepo.RemoveTarget(refreshTargetName, data.CanonicalTargetsRole)
+}
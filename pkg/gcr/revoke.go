@@ -3,48 +3,36 @@ package gcr
 import (
 	"fmt"
 
-	"github.com/simonshyu/notary-gcr/trust"
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
+	"github.com/simonshyu/notary-gcr/trust"
 	"github.com/theupdateframework/notary/client"
 	"github.com/theupdateframework/notary/tuf/data"
 )
 
-func revokeImage(ref name.Reference, tag string, auth authn.Authenticator, config *trust.Config) error {
-	repoInfo := ref.Context().Registry
-	notaryRepo, err := trust.GetNotaryRepository(ref, auth, &repoInfo, config)
-	if err != nil {
-		return errors.Wrap(err, "error establishing connection to trust repository")
-	}
-
-	if err = clearChangeList(notaryRepo); err != nil {
-		return err
-	}
-	defer clearChangeList(notaryRepo)
-	if err := revokeSignature(notaryRepo, tag); err != nil {
-		return errors.Wrapf(err, "could not remove signature for %s", tag)
-	}
-	log.Infof("Successfully deleted signature for %s\n", tag)
-	return nil
-}
-
 func revokeSignature(notaryRepo client.Repository, tag string) error {
 	if tag != "" {
 		// Revoke signature for the specified tag
-		if err := revokeSingleSig(notaryRepo, tag); err != nil {
-			return err
+		return revokeSingleSig(notaryRepo, tag)
+	}
+	// revoke all signatures for the image, as no tag was given
+	return revokeAllSigs(notaryRepo)
+}
+
+// revokeTags stages the revocation of every tag in tags. The tags that have no
+// signed target are skipped and returned.
+func revokeTags(notaryRepo client.Repository, tags []string) ([]string, error) {
+	var missing []string
+	for _, tag := range tags {
+		err := revokeSingleSig(notaryRepo, tag)
+		if _, ok := err.(client.ErrNoSuchTarget); ok {
+			missing = append(missing, tag)
+			continue
 		}
-	} else {
-		// revoke all signatures for the image, as no tag was given
-		if err := revokeAllSigs(notaryRepo); err != nil {
-			return err
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not remove signature for %s", tag)
 		}
 	}
-
-	//  Publish change
-	return notaryRepo.Publish()
+	return missing, nil
 }
 
 func revokeSingleSig(notaryRepo client.Repository, tag string) error {
@@ -85,3 +73,18 @@ func getSignableRolesForTargetAndRemove(releasedTarget client.Target, notaryRepo
 	// remove from all roles
 	return notaryRepo.RemoveTarget(releasedTarget.Name, signableRoles...)
 }
+
+// deleteTarget stages the removal of the target called name from the top
+// level targets role only, leaving the delegations as they are.
+func deleteTarget(notaryRepo client.Repository, repoName string, name string) error {
+	targets, err := listRoleTargets(notaryRepo, repoName, data.CanonicalTargetsRole)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if t.Name == name {
+			return notaryRepo.RemoveTarget(name, data.CanonicalTargetsRole)
+		}
+	}
+	return errors.Wrapf(ErrNoSuchTarget, "%s:%s in %s", repoName, name, data.CanonicalTargetsRole)
+}
@@ -0,0 +1,109 @@
+package gcr
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// removingRepository is a notary repository listing fixed targets and
+// recording the targets removed from it.
+type removingRepository struct {
+	client.Repository
+	targets []*client.TargetWithRole
+	removed map[string][]data.RoleName
+}
+
+func (r *removingRepository) ListTargets(roles ...data.RoleName) ([]*client.TargetWithRole, error) {
+	return r.targets, nil
+}
+
+func (r *removingRepository) RemoveTarget(name string, roles ...data.RoleName) error {
+	r.removed[name] = roles
+	return nil
+}
+
+func TestDeleteTarget(t *testing.T) {
+	notaryRepo := &removingRepository{
+		targets: []*client.TargetWithRole{
+			{Target: client.Target{Name: "latest"}, Role: data.CanonicalTargetsRole},
+			{Target: client.Target{Name: "stable"}, Role: trust.ReleasesRole},
+		},
+		removed: make(map[string][]data.RoleName),
+	}
+	assert.NilError(t, deleteTarget(notaryRepo, "gcr.io/project/image", "latest"))
+	assert.Check(t, is.DeepEqual(notaryRepo.removed, map[string][]data.RoleName{"latest": {data.CanonicalTargetsRole}}))
+
+	// only signed by a delegation
+	err := deleteTarget(notaryRepo, "gcr.io/project/image", "stable")
+	assert.Check(t, errors.Is(err, ErrNoSuchTarget), "unexpected error: %v", err)
+	err = deleteTarget(notaryRepo, "gcr.io/project/image", "missing")
+	assert.Check(t, errors.Is(err, ErrNoSuchTarget), "unexpected error: %v", err)
+}
+
+// revokingRepository is a publishingRepository whose top level targets role
+// signed the targets signed, keyed by name, and that has no delegations.
+type revokingRepository struct {
+	*publishingRepository
+	signed map[string]client.Target
+}
+
+func (r *revokingRepository) GetTargetByName(name string, roles ...data.RoleName) (*client.TargetWithRole, error) {
+	target, ok := r.signed[name]
+	if !ok {
+		return nil, client.ErrNoSuchTarget(name)
+	}
+	return &client.TargetWithRole{Target: target, Role: data.CanonicalTargetsRole}, nil
+}
+
+func (r *revokingRepository) GetDelegationRoles() ([]data.Role, error) {
+	return nil, nil
+}
+
+func TestRevokeTags(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+	staging, err := repo.NotaryRepository()
+	assert.NilError(t, err)
+	notaryRepo := &revokingRepository{
+		publishingRepository: &publishingRepository{Repository: staging},
+		signed: map[string]client.Target{
+			"latest": {Name: "latest", Length: 1},
+			"stable": {Name: "stable", Length: 1},
+		},
+	}
+	repo.notary = notaryRepo
+	revoked := func(changes []changelist.Change) []string {
+		var names []string
+		for _, c := range changes {
+			if c.Type() == changelist.TypeTargetsTarget && c.Action() == changelist.ActionDelete {
+				names = append(names, c.Path())
+			}
+		}
+		return names
+	}
+
+	// the missing tags are reported, and the others still revoked at once
+	err = repo.RevokeTags([]string{"latest", "missing", "stable", "gone"})
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+	assert.Check(t, is.ErrorContains(err, "missing,gone"))
+	assert.Assert(t, is.Len(notaryRepo.published, 1))
+	assert.Check(t, is.DeepEqual(revoked(notaryRepo.published[0]), []string{"latest", "stable"}))
+
+	// there is nothing to publish when every tag is missing
+	err = repo.RevokeTags([]string{"missing", "gone"})
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+	err = repo.RevokeTags(nil)
+	assert.Check(t, is.ErrorContains(err, "no tags given"))
+	assert.Check(t, is.Len(notaryRepo.published, 1))
+
+	assert.NilError(t, repo.RevokeTags([]string{"stable"}))
+	assert.Assert(t, is.Len(notaryRepo.published, 2))
+	assert.Check(t, is.DeepEqual(revoked(notaryRepo.published[1]), []string{"stable"}))
+}
@@ -0,0 +1,98 @@
+package gcr
+
+import (
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// Key locations reported by KeyLocations.
+const (
+	// KeyLocal is a role whose private key is in the local key store.
+	KeyLocal = "local"
+	// KeyServer is a snapshot or timestamp role whose key is held by the
+	// notary server.
+	KeyServer = "server"
+	// KeyOffline is a root or targets role whose private key is not in the
+	// local key store, e.g. a root key kept offline. The notary server never
+	// holds these keys.
+	KeyOffline = "offline"
+)
+
+// rotateKey replaces the key of a base role with a newly generated one, or
+// with a key held by the notary server when serverManaged is set, and
+// publishes the change.
+func rotateKey(log trust.Logger, notaryRepo client.Repository, repoName string, role data.RoleName, serverManaged bool) error {
+	switch role {
+	case data.CanonicalRootRole, data.CanonicalTargetsRole:
+		if serverManaged {
+			return errors.Errorf("the %s key of %s cannot be managed by the notary server", role, repoName)
+		}
+	case data.CanonicalSnapshotRole, data.CanonicalTimestampRole:
+	default:
+		return errors.Errorf("rotating the %s key is not supported, only root, targets, snapshot and timestamp keys can be rotated", role)
+	}
+
+	// RotateKey publishes the change right away
+	if err := notaryRepo.RotateKey(role, serverManaged, nil); err != nil {
+		return notaryError(repoName, err)
+	}
+	log.Infof("Successfully rotated %s key for %s\n", role, repoName)
+	return nil
+}
+
+// isServerManaged reports whether the keys of role in root are held by the
+// notary server, i.e. none of them is in the local key store of cs.
+func isServerManaged(cs signed.CryptoService, root *data.SignedRoot, role data.RoleName) bool {
+	r, ok := root.Signed.Roles[role]
+	if !ok {
+		return false
+	}
+	for _, keyID := range r.KeyIDs {
+		if _, _, err := cs.GetPrivateKey(keyID); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// keyLocations returns where the private keys of the base roles of root are
+// held, as seen from the local key store of cs.
+func keyLocations(cs signed.CryptoService, root *data.SignedRoot) map[data.RoleName]string {
+	locations := make(map[data.RoleName]string, len(trust.BaseRoles))
+	for _, role := range trust.BaseRoles {
+		switch {
+		case hasLocalKey(cs, root, role):
+			locations[role] = KeyLocal
+		case role == data.CanonicalSnapshotRole || role == data.CanonicalTimestampRole:
+			locations[role] = KeyServer
+		default:
+			locations[role] = KeyOffline
+		}
+	}
+	return locations
+}
+
+// hasLocalKey reports whether the private key of any of the keys of role in
+// root is in the local key store of cs. Root keys are published as
+// certificates, so they are looked up by the ID of their public key.
+func hasLocalKey(cs signed.CryptoService, root *data.SignedRoot, role data.RoleName) bool {
+	r, ok := root.Signed.Roles[role]
+	if !ok {
+		return false
+	}
+	for _, keyID := range r.KeyIDs {
+		if key, ok := root.Signed.Keys[keyID]; ok {
+			if canonicalID, err := utils.CanonicalKeyID(key); err == nil {
+				keyID = canonicalID
+			}
+		}
+		if _, _, err := cs.GetPrivateKey(keyID); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package gcr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/utils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestKeyLocations(t *testing.T) {
+	gun := data.GUN("gcr.io/project/image")
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	rootKey, err := cs.Create(data.CanonicalRootRole, gun, data.ECDSAKey)
+	assert.NilError(t, err)
+	priv, _, err := cs.GetPrivateKey(rootKey.ID())
+	assert.NilError(t, err)
+	cert, err := cryptoservice.GenerateCertificate(priv, gun, time.Now(), time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+	rootCert := utils.CertToKey(cert)
+	snapshot, err := cs.Create(data.CanonicalSnapshotRole, gun, data.ECDSAKey)
+	assert.NilError(t, err)
+	targets := data.NewPublicKey(data.ECDSAKey, []byte("offline targets"))
+	timestamp := data.NewPublicKey(data.ECDSAKey, []byte("server timestamp"))
+
+	keys := data.Keys{}
+	roles := map[data.RoleName]*data.RootRole{}
+	for role, key := range map[data.RoleName]data.PublicKey{
+		data.CanonicalRootRole:      rootCert,
+		data.CanonicalTargetsRole:   targets,
+		data.CanonicalSnapshotRole:  snapshot,
+		data.CanonicalTimestampRole: timestamp,
+	} {
+		keys[key.ID()] = key
+		roles[role] = &data.RootRole{KeyIDs: []string{key.ID()}, Threshold: 1}
+	}
+	root, err := data.NewRoot(keys, roles, false)
+	assert.NilError(t, err)
+
+	assert.Check(t, is.DeepEqual(keyLocations(cs, root), map[data.RoleName]string{
+		data.CanonicalRootRole:      KeyLocal,
+		data.CanonicalTargetsRole:   KeyOffline,
+		data.CanonicalSnapshotRole:  KeyLocal,
+		data.CanonicalTimestampRole: KeyServer,
+	}))
+}
+
+// rotatingRepository is a client.Repository recording the key rotations it is
+// asked for, by role, and whether the server was to manage the new key.
+type rotatingRepository struct {
+	client.Repository
+	rotated map[data.RoleName]bool
+	err     error
+}
+
+func (r *rotatingRepository) RotateKey(role data.RoleName, serverManagesKey bool, keyList []string) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.rotated[role] = serverManagesKey
+	return nil
+}
+
+func TestRotateKey(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+	notaryRepo := &rotatingRepository{rotated: make(map[data.RoleName]bool)}
+	repo.notary = notaryRepo
+
+	// notary never holds the root and targets keys
+	for _, role := range []data.RoleName{data.CanonicalRootRole, data.CanonicalTargetsRole} {
+		err := repo.RotateKey(role, true)
+		assert.Check(t, is.ErrorContains(err, "cannot be managed by the notary server"), "role %s", role)
+	}
+	err := repo.RotateKey("targets/releases", false)
+	assert.Check(t, is.ErrorContains(err, "rotating the targets/releases key is not supported"))
+	assert.Check(t, is.Len(notaryRepo.rotated, 0))
+
+	assert.NilError(t, repo.RotateKey(data.CanonicalRootRole, false))
+	assert.NilError(t, repo.RotateKey(data.CanonicalTargetsRole, false))
+	assert.NilError(t, repo.RotateKey(data.CanonicalSnapshotRole, true))
+	assert.NilError(t, repo.RotateKey(data.CanonicalTimestampRole, true))
+	assert.Check(t, is.DeepEqual(notaryRepo.rotated, map[data.RoleName]bool{
+		data.CanonicalRootRole:      false,
+		data.CanonicalTargetsRole:   false,
+		data.CanonicalSnapshotRole:  true,
+		data.CanonicalTimestampRole: true,
+	}))
+
+	notaryRepo.err = client.ErrRepositoryNotExist{}
+	err = repo.RotateKey(data.CanonicalSnapshotRole, false)
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+}
@@ -0,0 +1,149 @@
+package gcr
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// semver is a version parsed as by semantic versioning 2.0.0. The build
+// metadata is dropped, as it does not take part in precedence.
+type semver struct {
+	core       [3]uint64
+	prerelease []string
+}
+
+// parseSemver parses tag as a semantic version, with an optional leading v
+// as in v1.2.3, and reports whether it is one.
+func parseSemver(tag string) (semver, bool) {
+	version := strings.TrimPrefix(tag, "v")
+	if i := strings.IndexByte(version, '+'); i >= 0 {
+		if !validIdentifiers(version[i+1:], false) {
+			return semver{}, false
+		}
+		version = version[:i]
+	}
+	var v semver
+	if i := strings.IndexByte(version, '-'); i >= 0 {
+		if !validIdentifiers(version[i+1:], true) {
+			return semver{}, false
+		}
+		v.prerelease = strings.Split(version[i+1:], ".")
+		version = version[:i]
+	}
+	core := strings.Split(version, ".")
+	if len(core) != 3 {
+		return semver{}, false
+	}
+	for i, n := range core {
+		if !isNumeric(n) || (len(n) > 1 && n[0] == '0') {
+			return semver{}, false
+		}
+		var err error
+		if v.core[i], err = strconv.ParseUint(n, 10, 64); err != nil {
+			return semver{}, false
+		}
+	}
+	return v, true
+}
+
+// validIdentifiers reports whether s is a dot separated list of non-empty
+// alphanumeric identifiers, whose numeric identifiers have no leading zero
+// when noLeadingZero is set, as prerelease versions require.
+func validIdentifiers(s string, noLeadingZero bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" || strings.TrimLeft(id, "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-") != "" {
+			return false
+		}
+		if noLeadingZero && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// compare returns -1, 0 or 1 as v has lower, equal or higher precedence
+// than w.
+func (v semver) compare(w semver) int {
+	for i := range v.core {
+		if v.core[i] != w.core[i] {
+			return compareUint(v.core[i], w.core[i])
+		}
+	}
+	// a prerelease version has lower precedence than the release
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		if c := compareIdentifiers(v.prerelease[i], w.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.prerelease)), uint64(len(w.prerelease)))
+}
+
+// compareIdentifiers compares prerelease identifiers: numeric ones
+// numerically and below alphanumeric ones, which compare in ASCII order.
+func compareIdentifiers(a, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		if len(a) != len(b) {
+			return compareUint(uint64(len(a)), uint64(len(b)))
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// latestSemver returns the target, among those of the trusted roles in
+// targets, whose name is the highest semantic version, leaving out
+// prerelease versions unless includePrereleases is set. Of names of equal
+// precedence, e.g. 1.0.0 and v1.0.0, the first in lexical order is returned.
+// It returns nil when no name is a semantic version.
+func latestSemver(targets []*client.TargetWithRole, includePrereleases bool) *client.Target {
+	sorted := append([]*client.TargetWithRole(nil), targets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var latest *client.Target
+	var latestVersion semver
+	for _, t := range sorted {
+		if t.Role != trust.ReleasesRole && t.Role != data.CanonicalTargetsRole {
+			continue
+		}
+		v, ok := parseSemver(t.Name)
+		if !ok || (len(v.prerelease) > 0 && !includePrereleases) {
+			continue
+		}
+		if latest == nil || v.compare(latestVersion) > 0 {
+			latest, latestVersion = &t.Target, v
+		}
+	}
+	return latest
+}
@@ -0,0 +1,54 @@
+package gcr
+
+import (
+	"testing"
+
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseSemver(t *testing.T) {
+	for _, tag := range []string{"1.2.3", "v1.2.3", "0.0.0", "1.2.3-rc.1", "1.2.3-alpha-1.x", "1.2.3+build.5", "1.2.3-rc.1+001"} {
+		_, ok := parseSemver(tag)
+		assert.Check(t, ok, "%s is a semantic version", tag)
+	}
+	for _, tag := range []string{"latest", "1.2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-rc..1", "1.2.3-01", "v", "1.2.x", "1.2.3-rc_1"} {
+		_, ok := parseSemver(tag)
+		assert.Check(t, !ok, "%s is not a semantic version", tag)
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	// from lowest to highest precedence, as in the semantic versioning spec
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			v, _ := parseSemver(ordered[i])
+			w, _ := parseSemver(ordered[j])
+			want := compareUint(uint64(i), uint64(j))
+			assert.Check(t, is.Equal(v.compare(w), want), "%s vs %s", ordered[i], ordered[j])
+		}
+	}
+	v, _ := parseSemver("1.0.0+build.1")
+	w, _ := parseSemver("v1.0.0")
+	assert.Check(t, is.Equal(v.compare(w), 0))
+}
+
+func TestLatestSemver(t *testing.T) {
+	targets := []*client.TargetWithRole{
+		{Target: client.Target{Name: "latest"}, Role: data.CanonicalTargetsRole},
+		{Target: client.Target{Name: "v1.9.2"}, Role: data.CanonicalTargetsRole},
+		{Target: client.Target{Name: "1.10.0"}, Role: trust.ReleasesRole},
+		{Target: client.Target{Name: "2.0.0-rc.1"}, Role: trust.ReleasesRole},
+		{Target: client.Target{Name: "3.0.0"}, Role: "targets/ci"},
+	}
+	assert.Check(t, is.Equal(latestSemver(targets, false).Name, "1.10.0"))
+	assert.Check(t, is.Equal(latestSemver(targets, true).Name, "2.0.0-rc.1"))
+	assert.Check(t, is.Nil(latestSemver(targets[:1], true)))
+}
@@ -1,12 +1,138 @@
 package gcr
 
 import (
+	"context"
+	"encoding/json"
+
 	"github.com/simonshyu/notary-gcr/trust"
-	"github.com/google/go-containerregistry/pkg/authn"
+	canonicaljson "github.com/docker/go/canonical/json"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
 )
 
-func signImage(ref name.Reference, img v1.Image, auth authn.Authenticator, config *trust.Config) error {
-	return pushTrustedReference(ref, img, auth, config)
+// signTargets signs targets into the notary repository and publishes them at
+// once, unless publishing is deferred.
+func (repo *TrustedGcrRepository) signTargets(ctx context.Context, targets ...*client.Target) error {
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		repo.logger.Errorf("failed to get notary repository %s", err)
+		return err
+	}
+	repoName := repo.ref.Context().Name()
+	err = repo.stageAndPublish(notaryRepo, func() error {
+		if repo.immutableTags {
+			if err := checkTargetsUnchanged(notaryRepo, repoName, targets...); err != nil {
+				return err
+			}
+		}
+		return stageTargets(repo.logger, notaryRepo, repoName, repo.serverManagedRoles, repo.dryRun, repo.noAutoInit, targets...)
+	})
+	if err != nil {
+		for _, target := range targets {
+			repo.targetLogger(target).Warnf("Failed to sign: %s:%s %s\n", repoName, target.Name, err)
+		}
+		return err
+	}
+	for _, target := range targets {
+		repo.targetLogger(target).Infof("Successfully signed %s:%s\n", repoName, target.Name)
+	}
+	return nil
+}
+
+// checkAutoInit returns ErrUninitialized if the repository must not be
+// initialized by a signature and has no trust data, so that pushes fail
+// before uploading anything.
+func (repo *TrustedGcrRepository) checkAutoInit(ctx context.Context) error {
+	if !repo.noAutoInit {
+		return nil
+	}
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := notaryRepo.ListTargets(); err != nil {
+		return notaryError(repo.ref.Context().Name(), err)
+	}
+	return nil
+}
+
+// checkImmutableTags returns ErrTagAlreadySigned if the repository has
+// immutable tags and one of targets is already signed with another digest.
+func (repo *TrustedGcrRepository) checkImmutableTags(ctx context.Context, targets ...*client.Target) error {
+	if !repo.immutableTags {
+		return nil
+	}
+	notaryRepo, err := repo.notaryRepository(ctx)
+	if err != nil {
+		return err
+	}
+	return checkTargetsUnchanged(notaryRepo, repo.ref.Context().Name(), targets...)
+}
+
+// checkTargetsUnchanged returns ErrTagAlreadySigned if the name of one of
+// targets is already signed in the trusted roles with other hashes or length.
+// Signing a tag again with the same digest is allowed.
+func checkTargetsUnchanged(notaryRepo client.Repository, repoName string, targets ...*client.Target) error {
+	for _, target := range targets {
+		signed, err := notaryRepo.GetTargetByName(target.Name, trust.ReleasesRole, data.CanonicalTargetsRole)
+		switch err.(type) {
+		case nil:
+		case client.ErrNoSuchTarget, client.ErrRepoNotInitialized, client.ErrRepositoryNotExist:
+			continue
+		default:
+			return notaryError(repoName, err)
+		}
+		if signed.Length != target.Length || data.CompareMultiHashes(signed.Hashes, target.Hashes) != nil {
+			return errors.Wrapf(ErrTagAlreadySigned, "%s:%s", repoName, target.Name)
+		}
+	}
+	return nil
+}
+
+// imageTargets returns the notary targets of img for each of tags.
+func imageTargets(log trust.Logger, ref name.Reference, img v1.Image, tags []string) ([]*client.Target, error) {
+	if len(tags) == 0 {
+		return nil, errors.Errorf("no tags given to sign %s", ref.Context().Name())
+	}
+	targets := make([]*client.Target, 0, len(tags))
+	for _, tag := range tags {
+		if _, err := name.NewTag(ref.Context().Tag(tag).String(), name.StrictValidation); err != nil {
+			return nil, errors.Wrapf(err, "invalid tag %s", tag)
+		}
+		target, err := imageTarget(log, tag, img)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// digestTarget returns the notary target of the sha256 digest of a manifest
+// of size bytes under tag.
+func digestTarget(log trust.Logger, ref name.Reference, digest v1.Hash, size int64, tag string) (*client.Target, error) {
+	if digest.Algorithm != "sha256" {
+		return nil, errors.Errorf("unsupported digest algorithm %q, only sha256 can be signed", digest.Algorithm)
+	}
+	if size <= 0 {
+		return nil, errors.Errorf("invalid manifest size %d of %s", size, digest)
+	}
+	if _, err := name.NewTag(ref.Context().Tag(tag).String(), name.StrictValidation); err != nil {
+		return nil, errors.Wrapf(err, "invalid tag %s", tag)
+	}
+	return newTarget(log, tag, digest, size)
+}
+
+// setTargetCustom attaches custom, which must be valid JSON, to target as its
+// custom metadata.
+func setTargetCustom(target *client.Target, custom json.RawMessage) error {
+	if !json.Valid(custom) {
+		return errors.Errorf("custom metadata of %s is not valid JSON", target.Name)
+	}
+	raw := canonicaljson.RawMessage(custom)
+	target.Custom = &raw
+	return nil
 }
@@ -0,0 +1,345 @@
+package gcr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestSetTargetCustom(t *testing.T) {
+	target := &client.Target{Name: "latest"}
+	custom := json.RawMessage(`{"provenance":"https://example.com/build/42"}`)
+	assert.NilError(t, setTargetCustom(target, custom))
+	assert.Assert(t, target.Custom != nil)
+	assert.Check(t, is.Equal(string(*target.Custom), string(custom)))
+
+	// the custom data must survive the target being serialized into TUF metadata
+	blob, err := json.Marshal(target.Custom)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(blob), string(custom)))
+
+	err = setTargetCustom(&client.Target{Name: "latest"}, json.RawMessage(`{"provenance":`))
+	assert.Check(t, is.ErrorContains(err, "not valid JSON"))
+}
+
+func TestComputeTarget(t *testing.T) {
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	repo := &TrustedGcrRepository{ref: ref, logger: trust.DefaultLogger()}
+
+	target, err := repo.ComputeTarget(empty.Image, "v1.0.0")
+	assert.NilError(t, err)
+	digest, err := empty.Image.Digest()
+	assert.NilError(t, err)
+	manifest, err := empty.Image.RawManifest()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(target.Name, "v1.0.0"))
+	assert.Check(t, is.Equal(target.Length, int64(len(manifest))))
+	assert.Check(t, is.Equal(hex.EncodeToString(target.Hashes["sha256"]), digest.Hex))
+
+	_, err = repo.ComputeTarget(empty.Image, "not a tag")
+	assert.Check(t, is.ErrorContains(err, "invalid tag"))
+}
+
+func TestSignImageConcurrently(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t,
+		WithDeferredPublish(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+	// the test server holds no keys, so all of them are generated locally
+	repo.serverManagedRoles = nil
+
+	const signers = 8
+	errs := make([]error, signers)
+	var wg sync.WaitGroup
+	for i := 0; i < signers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = repo.SignImage(empty.Image)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.Check(t, err)
+	}
+
+	changes, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, len(changes) >= signers)
+}
+
+func TestNotaryHandleReused(t *testing.T) {
+	repo, pings, cleanup := newUninitializedRepository(t,
+		WithDeferredPublish(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+	repo.serverManagedRoles = nil
+
+	_, err := repo.Verify()
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+	assert.NilError(t, repo.SignImage(empty.Image))
+	// the signature is staged only, so nothing is published yet
+	_, err = repo.ListTarget()
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+	assert.Check(t, is.Equal(pings(), 1))
+
+	err = repo.RefreshMetadata()
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+	assert.Check(t, is.Equal(pings(), 2))
+}
+
+func TestClearPendingChanges(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t,
+		WithDeferredPublish(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+	repo.serverManagedRoles = nil
+
+	assert.NilError(t, repo.SignImage(empty.Image))
+	dir, err := repo.ChangelistDirectory()
+	assert.NilError(t, err)
+	staged, err := ioutil.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Check(t, len(staged) > 0)
+
+	assert.NilError(t, repo.ClearPendingChanges())
+	changes, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(changes, 0))
+	staged, err = ioutil.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(staged, 0))
+}
+
+func TestNoAutoInit(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t,
+		WithNoAutoInit(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+
+	err := repo.SignImage(empty.Image)
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+	changes, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(changes, 0))
+
+	// the push fails before reaching the registry
+	err = repo.TrustPush(empty.Image)
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+}
+
+func TestSignImageDryRun(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t, WithDryRun())
+	defer cleanup()
+
+	assert.NilError(t, repo.SignImage(empty.Image))
+	changes := repo.DryRunChanges()
+	assert.Assert(t, is.Len(changes, 1))
+	assert.Check(t, is.Equal(changes[0].Action(), changelist.ActionCreate))
+	assert.Check(t, is.Equal(changes[0].Path(), "latest"))
+
+	// nothing is left staged and the repository is not initialized
+	pending, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(pending, 0))
+	_, err = repo.ListTarget()
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+}
+
+func TestDigestTarget(t *testing.T) {
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	digest, err := empty.Image.Digest()
+	assert.NilError(t, err)
+	img, err := imageTarget(trust.DefaultLogger(), "v1", empty.Image)
+	assert.NilError(t, err)
+
+	target, err := digestTarget(trust.DefaultLogger(), ref, digest, img.Length, "v1")
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(target, img))
+
+	_, err = digestTarget(trust.DefaultLogger(), ref, v1.Hash{Algorithm: "sha512", Hex: "00"}, img.Length, "v1")
+	assert.ErrorContains(t, err, "unsupported digest algorithm")
+	_, err = digestTarget(trust.DefaultLogger(), ref, digest, 0, "v1")
+	assert.ErrorContains(t, err, "invalid manifest size")
+	_, err = digestTarget(trust.DefaultLogger(), ref, digest, img.Length, "not a tag")
+	assert.ErrorContains(t, err, "invalid tag")
+}
+
+func TestKeyGenHook(t *testing.T) {
+	generated := map[data.RoleName][]string{}
+	repo, _, cleanup := newUninitializedRepository(t,
+		WithDeferredPublish(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+		WithKeyGenHook(func(role data.RoleName, keyID string) { generated[role] = append(generated[role], keyID) }),
+	)
+	defer cleanup()
+	repo.serverManagedRoles = nil
+
+	// the keys are reported while the initialization is merely staged
+	assert.NilError(t, repo.SignImage(empty.Image))
+	for _, role := range []data.RoleName{data.CanonicalRootRole, data.CanonicalTargetsRole, data.CanonicalSnapshotRole} {
+		assert.Check(t, is.Len(generated[role], 1), "role %s", role)
+	}
+}
+
+func TestInMemoryKeyStore(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t,
+		WithDeferredPublish(),
+		WithInMemoryKeyStore(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+	repo.serverManagedRoles = nil
+
+	assert.NilError(t, repo.SignImage(empty.Image))
+	var exported bytes.Buffer
+	assert.NilError(t, repo.ExportRootKey(&exported, "passphrase"))
+	assert.Check(t, exported.Len() > 0)
+	err := filepath.Walk(repo.config.RootPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".key") {
+			t.Errorf("key written to disk: %s", path)
+		}
+		return err
+	})
+	assert.NilError(t, err)
+}
+
+// publishingRepository is a client.Repository recording the changes of every
+// publish rather than sending them, and failing to stage the target named
+// fail, if any.
+type publishingRepository struct {
+	client.Repository
+	fail      string
+	published [][]changelist.Change
+}
+
+func (r *publishingRepository) AddTarget(target *client.Target, roles ...data.RoleName) error {
+	if target.Name == r.fail {
+		return errors.Errorf("could not stage %s", target.Name)
+	}
+	return r.Repository.AddTarget(target, roles...)
+}
+
+func (r *publishingRepository) Publish() error {
+	cl, err := r.GetChangelist()
+	if err != nil {
+		return err
+	}
+	r.published = append(r.published, cl.List())
+	return nil
+}
+
+// stagedTargets returns the names of the targets changes adds.
+func stagedTargets(changes []changelist.Change) []string {
+	var names []string
+	for _, c := range changes {
+		if c.Type() == changelist.TypeTargetsTarget && c.Action() == changelist.ActionCreate {
+			names = append(names, c.Path())
+		}
+	}
+	return names
+}
+
+func TestSignImageTags(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t, WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")))
+	defer cleanup()
+	staging, err := repo.NotaryRepository()
+	assert.NilError(t, err)
+	notaryRepo := &publishingRepository{Repository: staging}
+	repo.notary = notaryRepo
+
+	assert.NilError(t, repo.SignImageTags(empty.Image, []string{"v1.2.3", "v1.2", "latest"}))
+	assert.Assert(t, is.Len(notaryRepo.published, 1))
+	assert.Check(t, is.DeepEqual(stagedTargets(notaryRepo.published[0]), []string{"v1.2.3", "v1.2", "latest"}))
+
+	// a tag failing midway publishes nothing and leaves nothing staged
+	notaryRepo.fail = "v1.2"
+	err = repo.SignImageTags(empty.Image, []string{"v1.2.3", "v1.2", "latest"})
+	assert.Check(t, is.ErrorContains(err, "could not stage v1.2"))
+	err = repo.SignImageTags(empty.Image, []string{"v1", "not a tag"})
+	assert.Check(t, is.ErrorContains(err, "invalid tag"))
+	assert.Check(t, is.Len(notaryRepo.published, 1))
+	pending, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(pending, 0))
+
+	// nor does it drop the changes staged before with deferred publishing
+	repo.deferPublish = true
+	assert.NilError(t, repo.SignImage(empty.Image))
+	staged, err := repo.PendingChanges()
+	assert.NilError(t, err)
+	err = repo.SignImageTags(empty.Image, []string{"v1.2.3", "v1.2", "stable"})
+	assert.Check(t, is.ErrorContains(err, "could not stage v1.2"))
+	pending, err = repo.PendingChanges()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(pending, len(staged)))
+	assert.Check(t, is.DeepEqual(stagedTargets(pending), []string{"latest"}))
+	assert.Check(t, is.Len(notaryRepo.published, 1))
+}
+
+func TestImmutableTags(t *testing.T) {
+	img, err := imageTarget(trust.DefaultLogger(), "latest", empty.Image)
+	assert.NilError(t, err)
+	for _, immutable := range []bool{true, false} {
+		opts := []Option{WithDeferredPublish(), WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase"))}
+		if immutable {
+			opts = append(opts, WithImmutableTags())
+		}
+		repo, _, cleanup := newUninitializedRepository(t, opts...)
+		defer cleanup()
+		staging, err := repo.NotaryRepository()
+		assert.NilError(t, err)
+		repo.notary = &revokingRepository{
+			publishingRepository: &publishingRepository{Repository: staging},
+			signed: map[string]client.Target{
+				"latest": {Name: "latest", Hashes: data.Hashes{"sha256": make([]byte, 32)}, Length: img.Length},
+				"stable": *img,
+			},
+		}
+
+		err = repo.SignImage(empty.Image)
+		pending, pendingErr := repo.PendingChanges()
+		assert.NilError(t, pendingErr)
+		if !immutable {
+			// the target is overwritten
+			assert.Check(t, err)
+			assert.Check(t, is.DeepEqual(stagedTargets(pending), []string{"latest"}))
+			continue
+		}
+		assert.Check(t, errors.Is(err, ErrTagAlreadySigned), "unexpected error: %v", err)
+		assert.Check(t, is.Len(pending, 0))
+		// the push fails before reaching the registry
+		err = repo.TrustPush(empty.Image)
+		assert.Check(t, errors.Is(err, ErrTagAlreadySigned), "unexpected error: %v", err)
+
+		// signing a tag again with the same digest is allowed
+		assert.NilError(t, repo.SignImageTags(empty.Image, []string{"stable", "v1"}))
+		pending, err = repo.PendingChanges()
+		assert.NilError(t, err)
+		assert.Check(t, is.DeepEqual(stagedTargets(pending), []string{"stable", "v1"}))
+	}
+}
@@ -0,0 +1,47 @@
+package gcr
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// TrustedTarget is a signed target in a form that marshals to stable JSON,
+// for machine-readable output. Digest is the sha256 digest of the manifest,
+// e.g. sha256:4a5e..., or the first other hash when there is no sha256 one.
+// Role is empty when unknown.
+type TrustedTarget struct {
+	Name   string          `json:"name"`
+	Digest string          `json:"digest"`
+	Size   int64           `json:"size"`
+	Role   string          `json:"role,omitempty"`
+	Custom json.RawMessage `json:"custom,omitempty"`
+}
+
+// NewTrustedTarget converts target, resolved from role, to a TrustedTarget.
+func NewTrustedTarget(target *client.Target, role data.RoleName) *TrustedTarget {
+	t := &TrustedTarget{
+		Name: target.Name,
+		Size: target.Length,
+		Role: role.String(),
+	}
+	if digest, err := targetDigest(target); err == nil {
+		t.Digest = digest.String()
+	} else {
+		algs := make([]string, 0, len(target.Hashes))
+		for alg := range target.Hashes {
+			algs = append(algs, alg)
+		}
+		sort.Strings(algs)
+		if len(algs) > 0 {
+			t.Digest = algs[0] + ":" + hex.EncodeToString(target.Hashes[algs[0]])
+		}
+	}
+	if target.Custom != nil {
+		t.Custom = append(json.RawMessage(nil), *target.Custom...)
+	}
+	return t
+}
@@ -0,0 +1,164 @@
+package gcr
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+)
+
+// Verifier verifies references of any repository with one trust config and
+// one set of options, e.g. in an admission webhook verifying images of many
+// repositories at once. The trust config is read once by NewVerifier, and the
+// repositories it verifies share one connection pool per notary server,
+// unless WithNotaryTransport gives a transport of its own.
+//
+// A Verifier is meant to be created once at start-up and shared: it is safe
+// for concurrent use by multiple goroutines. It keeps a repository, and so
+// the notary repository set up for it, for each of the most recently verified
+// GUNs, up to the size it was created with, so that verifying another image
+// of a GUN does not repeat the setup cost. Verifications of one GUN run one at
+// a time, like with a TrustedGcrRepository, while different GUNs are verified
+// concurrently. Close releases the pooled connections once the Verifier is no
+// longer needed.
+type Verifier struct {
+	config *trust.Config
+	opts   []Option
+	size   int
+
+	mu     sync.Mutex
+	closed bool
+	repos  map[string]*list.Element
+	// lru holds the repositories, most recently used first
+	lru *list.List
+	// transports are the shared notary transports by server
+	transports map[string]http.RoundTripper
+}
+
+type verifierEntry struct {
+	gun  string
+	repo *TrustedGcrRepository
+}
+
+// NewVerifier returns a Verifier keeping the repositories of up to size GUNs,
+// configured by opts like NewTrustedGcrRepositoryWithOptions. The options
+// apply to every repository; authenticators not given explicitly are resolved
+// by the keychain, if any, for each repository.
+func NewVerifier(size int, opts ...Option) (*Verifier, error) {
+	if size <= 0 {
+		return nil, errors.Errorf("verifier size must be positive, got %d", size)
+	}
+	o, err := makeOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	config, err := trust.ParseConfig(o.configDir)
+	if err != nil {
+		o.logger.Errorf("failed to parse config: %s", err)
+		return nil, err
+	}
+	return &Verifier{
+		config:     config,
+		opts:       opts,
+		size:       size,
+		repos:      make(map[string]*list.Element),
+		lru:        list.New(),
+		transports: make(map[string]http.RoundTripper),
+	}, nil
+}
+
+// Verify returns the trusted target of ref, a tag or digest reference, like
+// VerifyTag or VerifyDigest of a repository for ref. ErrNoTrustData is
+// returned when the tag is not signed, and ErrDigestNotSigned or, without any
+// trust data, ErrUninitialized when the digest is not.
+func (v *Verifier) Verify(ctx context.Context, ref name.Reference) (*client.Target, error) {
+	repo, err := v.repository(ref)
+	if err != nil {
+		return nil, err
+	}
+	return repo.verifyReference(ctx, ref)
+}
+
+// Close closes the idle pooled connections and drops the kept repositories.
+// Verify fails once the Verifier is closed.
+func (v *Verifier) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.closed = true
+	for _, rt := range v.transports {
+		if t, ok := rt.(*http.Transport); ok {
+			t.CloseIdleConnections()
+		}
+	}
+	v.transports = nil
+	v.repos = nil
+	v.lru.Init()
+}
+
+// repository returns the kept repository of the GUN of ref, setting one up if
+// there is none and evicting the least recently used one when full. Setting
+// up a repository does not contact the notary server, so it is done under the
+// lock, and concurrent verifications of a GUN always share one repository.
+func (v *Verifier) repository(ref name.Reference) (*TrustedGcrRepository, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return nil, errors.New("verifier is closed")
+	}
+	gun := ref.Context().String()
+	if elem, ok := v.repos[gun]; ok {
+		v.lru.MoveToFront(elem)
+		return elem.Value.(*verifierEntry).repo, nil
+	}
+
+	// the options are applied afresh, as resolving the credentials of a
+	// repository modifies them
+	o, err := makeOptions(v.opts...)
+	if err != nil {
+		return nil, err
+	}
+	config := *v.config
+	r, err := newTrustedGcrRepository(ref, &config, o)
+	if err != nil {
+		return nil, err
+	}
+	repo := &r
+	if repo.config.Transport == nil {
+		registry := ref.Context().Registry
+		if repo.config.Transport, err = v.transport(repo.config, &registry); err != nil {
+			repo.logger.Errorf("failed to set up notary transport: %s", err)
+			return nil, err
+		}
+	}
+
+	v.repos[gun] = v.lru.PushFront(&verifierEntry{gun: gun, repo: repo})
+	if v.lru.Len() > v.size {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.repos, oldest.Value.(*verifierEntry).gun)
+	}
+	return repo, nil
+}
+
+// transport returns the shared transport to the notary server of repoInfo,
+// setting it up with config on first use.
+func (v *Verifier) transport(config *trust.Config, repoInfo *name.Registry) (http.RoundTripper, error) {
+	server, err := trust.Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, err
+	}
+	if rt, ok := v.transports[server]; ok {
+		return rt, nil
+	}
+	rt, err := trust.SharedTransport(config, repoInfo)
+	if err != nil {
+		return nil, err
+	}
+	v.transports[server] = rt
+	return rt, nil
+}
@@ -0,0 +1,72 @@
+package gcr
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestVerifier(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+	configDir, err := ioutil.TempDir("", "notary-gcr")
+	assert.NilError(t, err)
+	defer os.RemoveAll(configDir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(configDir, "gcr-config.json"), []byte("{}"), 0600))
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+
+	_, err = NewVerifier(0)
+	assert.Check(t, is.ErrorContains(err, "verifier size must be positive"))
+
+	v, err := NewVerifier(2, WithConfigDir(configDir), WithTrustServer(s.URL), WithNotaryRootCAs(pool))
+	assert.NilError(t, err)
+
+	var wg sync.WaitGroup
+	for _, refStr := range []string{
+		"gcr.io/project/a:latest", "gcr.io/project/a:v1",
+		"gcr.io/project/b:latest", "gcr.io/project/c:latest",
+	} {
+		ref, err := name.ParseReference(refStr)
+		assert.NilError(t, err)
+		wg.Add(1)
+		go func(ref name.Reference) {
+			defer wg.Done()
+			_, err := v.Verify(context.Background(), ref)
+			assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error verifying %s: %v", ref, err)
+		}(ref)
+	}
+	wg.Wait()
+	// the repositories of all GUNs share one transport, and only the most
+	// recent ones are kept
+	assert.Check(t, is.Len(v.transports, 1))
+	assert.Check(t, is.Len(v.repos, 2))
+
+	ref, err := name.ParseReference("gcr.io/project/a:latest")
+	assert.NilError(t, err)
+	repo, err := v.repository(ref)
+	assert.NilError(t, err)
+	again, err := v.repository(ref)
+	assert.NilError(t, err)
+	assert.Check(t, repo == again)
+	assert.Check(t, repo.config.Transport == v.transports[s.URL])
+
+	v.Close()
+	_, err = v.Verify(context.Background(), ref)
+	assert.Check(t, is.ErrorContains(err, "verifier is closed"))
+}
@@ -1,36 +1,252 @@
 package gcr
 
 import (
-	"github.com/simonshyu/notary-gcr/trust"
-	"github.com/google/go-containerregistry/pkg/authn"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/simonshyu/notary-gcr/trust"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 	"github.com/theupdateframework/notary/client"
 	"github.com/theupdateframework/notary/tuf/data"
 )
 
-func getTrustedTarget(ref name.Reference, auth authn.Authenticator, config *trust.Config) (*client.Target, error) {
-	repoInfo := ref.Context().Registry
-	notaryRepo, err := trust.GetNotaryRepository(ref, auth, &repoInfo, config)
-	if err != nil {
-		return nil, errors.Wrap(err, "error establishing connection to trust repository")
-	}
-	tag, err := name.NewTag(ref.String(), name.StrictValidation)
+func getTrustedTarget(log trust.Logger, notaryRepo client.Repository, repoName string, tag string) (*client.Target, error) {
+	t, err := getTrustedTargetWithRole(log, notaryRepo, repoName, tag)
 	if err != nil {
-		return nil, errors.Wrap(err, "couldn't parse tag from repository name")
+		return nil, err
 	}
+	return &t.Target, nil
+}
 
-	t, err := notaryRepo.GetTargetByName(tag.Identifier(), trust.ReleasesRole, data.CanonicalTargetsRole)
+// getTrustedTargetWithRole is like getTrustedTarget but also returns the role
+// the target was resolved from.
+func getTrustedTargetWithRole(log trust.Logger, notaryRepo client.Repository, repoName string, tag string) (*client.TargetWithRole, error) {
+	t, err := notaryRepo.GetTargetByName(tag, trust.ReleasesRole, data.CanonicalTargetsRole)
 	if err != nil {
-		return nil, trust.NotaryError(ref.Name(), err)
+		switch err.(type) {
+		case client.ErrNoSuchTarget:
+			return nil, errors.Wrapf(ErrNoTrustData, "%s:%s", repoName, tag)
+		case client.ErrRepositoryNotExist, client.ErrRepoNotInitialized:
+			// a repository that was never signed has no trust data for any tag
+			return nil, errors.Wrapf(ErrNoTrustData, "%s:%s: %s", repoName, tag, err)
+		}
+		return nil, notaryError(repoName, err)
 	}
 	// Only get the tag if it's in the top level targets role or the releases delegation role
 	// ignore it if it's in any other delegation roles
 	if t.Role != trust.ReleasesRole && t.Role != data.CanonicalTargetsRole {
-		return nil, trust.NotaryError(ref.Name(), errors.Errorf("No trust data for %s", tag.Identifier()))
+		return nil, errors.Wrapf(ErrNoTrustData, "%s:%s", repoName, tag)
 	}
 
 	log.Debugf("retrieving target for %s role", t.Role)
-	return &t.Target, nil
+	return t, nil
+}
+
+// getTrustedTargetByDigest returns a target of the top level targets role or
+// the releases delegation role whose hash matches digest. Targets signed by
+// other delegations, which notary lists as well, are ignored. When several
+// targets match, the one whose name sorts first is returned.
+func getTrustedTargetByDigest(log trust.Logger, notaryRepo client.Repository, repoName string, digest v1.Hash) (*client.Target, error) {
+	h, err := hex.DecodeString(digest.Hex)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode digest %s", digest)
+	}
+	listed, err := notaryRepo.ListTargets(trust.ReleasesRole, data.CanonicalTargetsRole)
+	if err != nil {
+		return nil, notaryError(repoName, err)
+	}
+	targets := make([]*client.TargetWithRole, 0, len(listed))
+	for _, t := range listed {
+		if t.Role == trust.ReleasesRole || t.Role == data.CanonicalTargetsRole {
+			targets = append(targets, t)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	for _, t := range targets {
+		if signed, ok := t.Hashes[digest.Algorithm]; ok && bytes.Equal(signed, h) {
+			log.Debugf("retrieving target %s for %s role", t.Name, t.Role)
+			return &t.Target, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrDigestNotSigned, "%s@%s", repoName, digest)
+}
+
+// getSigningRoles returns the trusted roles, see inReleasesChain, e.g.
+// targets or targets/releases, that signed target under the name of tag, in
+// the order notary resolves them.
+func getSigningRoles(notaryRepo client.Repository, repoName string, tag string, target *client.Target) ([]data.RoleName, error) {
+	signed, err := notaryRepo.GetAllTargetMetadataByName(tag)
+	if err != nil {
+		return nil, notaryError(repoName, err)
+	}
+	var roles []data.RoleName
+	for _, s := range signed {
+		if !inReleasesChain(s.Role.Name) {
+			continue
+		}
+		if s.Target.Length == target.Length && data.CompareMultiHashes(s.Target.Hashes, target.Hashes) == nil {
+			roles = append(roles, s.Role.Name)
+		}
+	}
+	return roles, nil
+}
+
+// mostSpecificTarget returns the version of target, as signed by the most
+// specific trusted role, from the metadata that signed it. Only the top level
+// targets role, the releases delegation and the delegations below it are
+// trusted; other delegations are ignored, however deep. A deeper delegation,
+// e.g. targets/releases/ci, takes precedence over its parent, and every
+// delegation over the top level targets role. This inverts notary, whose walk
+// visits a role before its delegations and so returns the first, least
+// specific, role: the custom data a delegation records, e.g. provenance, would
+// never be returned when the top level targets role signs the target too.
+// Roles of the same depth are ranked in the order notary walks them, that is
+// the order their parent lists them. Only the metadata signing the same
+// length and hashes as target is considered, so the custom data returned
+// always describes the trusted content.
+func mostSpecificTarget(signed []client.TargetSignedStruct, target *client.Target) *client.Target {
+	best, depth := target, -1
+	for i, s := range signed {
+		if !inReleasesChain(s.Role.Name) {
+			continue
+		}
+		if s.Target.Length != target.Length || data.CompareMultiHashes(s.Target.Hashes, target.Hashes) != nil {
+			continue
+		}
+		if d := strings.Count(s.Role.Name.String(), "/"); d > depth {
+			best, depth = &signed[i].Target, d
+		}
+	}
+	return best
+}
+
+// inReleasesChain reports whether role is the top level targets role, the
+// releases delegation or a delegation below it.
+func inReleasesChain(role data.RoleName) bool {
+	return role == data.CanonicalTargetsRole || role == trust.ReleasesRole ||
+		strings.HasPrefix(role.String(), trust.ReleasesRole.String()+"/")
+}
+
+// countRoleSigners returns the number of distinct keys of role whose valid
+// signatures are present on the metadata that signed target.
+func countRoleSigners(signed []client.TargetSignedStruct, role data.RoleName, target *client.Target) int {
+	signers := make(map[string]struct{})
+	for _, s := range signed {
+		if s.Role.Name != role || s.Target.Length != target.Length || data.CompareMultiHashes(s.Target.Hashes, target.Hashes) != nil {
+			continue
+		}
+		for _, sig := range s.Signatures {
+			if _, ok := s.Role.Keys[sig.KeyID]; ok && sig.IsValid {
+				signers[sig.KeyID] = struct{}{}
+			}
+		}
+	}
+	return len(signers)
+}
+
+// checkThreshold returns ErrThresholdNotMet unless at least threshold keys of
+// role signed target under the name of tag.
+func checkThreshold(notaryRepo client.Repository, repoName string, tag string, role data.RoleName, threshold int, target *client.Target) error {
+	signed, err := notaryRepo.GetAllTargetMetadataByName(tag)
+	if err != nil {
+		return notaryError(repoName, err)
+	}
+	if found := countRoleSigners(signed, role, target); found < threshold {
+		return errors.Wrapf(ErrThresholdNotMet, "%s:%s has %d valid signatures in %s, %d required", repoName, tag, found, role, threshold)
+	}
+	return nil
+}
+
+// targetDigest returns the sha256 digest recorded in target.
+func targetDigest(target *client.Target) (v1.Hash, error) {
+	h, ok := target.Hashes["sha256"]
+	if !ok || len(h) != sha256.Size {
+		return v1.Hash{}, errors.Errorf("signed target %s has no valid sha256 hash", target.Name)
+	}
+	return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h)}, nil
+}
+
+// fetchImage returns the image with the given digest in repo, e.g. a trusted
+// digest, wrapping ErrDigestNotInRegistry if the registry does not serve it.
+func fetchImage(repo name.Repository, digest v1.Hash, options ...remote.Option) (v1.Image, error) {
+	ref := repo.Digest(digest.String())
+	img, err := remote.Image(ref, options...)
+	if err != nil {
+		if terr, ok := err.(*transport.Error); ok && terr.StatusCode == http.StatusNotFound {
+			return nil, errors.Wrapf(ErrDigestNotInRegistry, "%s: %s", ref, err)
+		}
+		return nil, errors.Wrapf(err, "failed to fetch %s", ref)
+	}
+	return img, nil
+}
+
+// checkManifestSize returns ErrSizeMismatch unless the manifest of img is as
+// long as target records.
+func checkManifestSize(img v1.Image, target *client.Target) error {
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return errors.Wrap(err, "failed to read manifest")
+	}
+	if int64(len(manifest)) != target.Length {
+		return errors.Wrapf(ErrSizeMismatch, "%s: registry serves %d bytes, %d signed", target.Name, len(manifest), target.Length)
+	}
+	return nil
+}
+
+// validSigners returns the sorted IDs of the keys of the trusted roles, see
+// inReleasesChain, that signed target whose signatures on the metadata of
+// their role verified.
+func validSigners(signed []client.TargetSignedStruct, target *client.Target) []string {
+	found := make(map[string]struct{})
+	for _, s := range signed {
+		if !inReleasesChain(s.Role.Name) {
+			continue
+		}
+		if s.Target.Length != target.Length || data.CompareMultiHashes(s.Target.Hashes, target.Hashes) != nil {
+			continue
+		}
+		for _, sig := range s.Signatures {
+			if _, ok := s.Role.Keys[sig.KeyID]; ok && sig.IsValid {
+				found[sig.KeyID] = struct{}{}
+			}
+		}
+	}
+	signers := make([]string, 0, len(found))
+	for keyID := range found {
+		signers = append(signers, keyID)
+	}
+	sort.Strings(signers)
+	return signers
+}
+
+// checkRequiredSigners returns ErrRequiredSignerMissing unless one of keyIDs
+// validly signed target under its name.
+func checkRequiredSigners(notaryRepo client.Repository, repoName string, target *client.Target, keyIDs []string) error {
+	signed, err := notaryRepo.GetAllTargetMetadataByName(target.Name)
+	if err != nil {
+		return notaryError(repoName, err)
+	}
+	return checkSignedBy(signed, repoName, target, keyIDs)
+}
+
+// checkSignedBy returns ErrRequiredSignerMissing unless one of keyIDs validly
+// signed target in the metadata that signed it.
+func checkSignedBy(signed []client.TargetSignedStruct, repoName string, target *client.Target, keyIDs []string) error {
+	signers := validSigners(signed, target)
+	for _, signer := range signers {
+		for _, keyID := range keyIDs {
+			if signer == keyID {
+				return nil
+			}
+		}
+	}
+	return errors.Wrapf(ErrRequiredSignerMissing, "%s:%s is signed by keys %v, expected one of %v", repoName, target.Name, signers, keyIDs)
 }
@@ -0,0 +1,511 @@
+package gcr
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	canonicaljson "github.com/docker/go/canonical/json"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/utils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestCountRoleSigners(t *testing.T) {
+	manifest := sha256.Sum256([]byte("manifest"))
+	target := client.Target{Name: "latest", Hashes: data.Hashes{"sha256": manifest[:]}, Length: 42}
+	releases := data.DelegationRole{
+		BaseRole: data.BaseRole{
+			Name: "targets/releases",
+			Keys: map[string]data.PublicKey{"alice": nil, "bob": nil, "carol": nil},
+		},
+	}
+	targets := data.DelegationRole{
+		BaseRole: data.BaseRole{
+			Name: data.CanonicalTargetsRole,
+			Keys: map[string]data.PublicKey{"root-signer": nil},
+		},
+	}
+	signed := []client.TargetSignedStruct{
+		{
+			Role:   releases,
+			Target: target,
+			Signatures: []data.Signature{
+				{KeyID: "alice", IsValid: true},
+				{KeyID: "bob", IsValid: true},
+				// duplicated, foreign and invalid signatures do not count
+				{KeyID: "alice", IsValid: true},
+				{KeyID: "mallory", IsValid: true},
+				{KeyID: "carol"},
+			},
+		},
+		{
+			Role:       targets,
+			Target:     target,
+			Signatures: []data.Signature{{KeyID: "root-signer", IsValid: true}},
+		},
+	}
+
+	assert.Check(t, is.Equal(countRoleSigners(signed, "targets/releases", &target), 2))
+	assert.Check(t, is.Equal(countRoleSigners(signed, data.CanonicalTargetsRole, &target), 1))
+	assert.Check(t, is.Equal(countRoleSigners(signed, "targets/qa", &target), 0))
+
+	otherManifest := sha256.Sum256([]byte("other manifest"))
+	other := client.Target{Name: "latest", Hashes: data.Hashes{"sha256": otherManifest[:]}, Length: 42}
+	assert.Check(t, is.Equal(countRoleSigners(signed, "targets/releases", &other), 0))
+}
+
+func TestCheckRequiredSigners(t *testing.T) {
+	manifest := sha256.Sum256([]byte("manifest"))
+	target := client.Target{Name: "latest", Hashes: data.Hashes{"sha256": manifest[:]}, Length: 42}
+	releases := data.DelegationRole{
+		BaseRole: data.BaseRole{
+			Name: "targets/releases",
+			Keys: map[string]data.PublicKey{"release-key": nil, "ci-key": nil},
+		},
+	}
+	notaryRepo := &signedRepository{signed: []client.TargetSignedStruct{{
+		Role:   releases,
+		Target: target,
+		Signatures: []data.Signature{
+			{KeyID: "ci-key", IsValid: true},
+			// invalid and foreign signatures do not count
+			{KeyID: "release-key"},
+			{KeyID: "mallory", IsValid: true},
+		},
+	}, {
+		// nor do those of delegations outside of targets/releases
+		Role: data.DelegationRole{
+			BaseRole: data.BaseRole{
+				Name: "targets/qa",
+				Keys: map[string]data.PublicKey{"qa-key": nil},
+			},
+		},
+		Target:     target,
+		Signatures: []data.Signature{{KeyID: "qa-key", IsValid: true}},
+	}}}
+
+	assert.Check(t, is.DeepEqual(validSigners(notaryRepo.signed, &target), []string{"ci-key"}))
+	assert.NilError(t, checkRequiredSigners(notaryRepo, "gcr.io/project/image", &target, []string{"release-key", "ci-key"}))
+
+	err := checkRequiredSigners(notaryRepo, "gcr.io/project/image", &target, []string{"release-key"})
+	assert.Check(t, errors.Is(err, ErrRequiredSignerMissing), "unexpected error: %v", err)
+	assert.Check(t, is.ErrorContains(err, "signed by keys [ci-key], expected one of [release-key]"))
+	err = checkRequiredSigners(notaryRepo, "gcr.io/project/image", &target, []string{"mallory"})
+	assert.Check(t, errors.Is(err, ErrRequiredSignerMissing), "unexpected error: %v", err)
+	err = checkRequiredSigners(notaryRepo, "gcr.io/project/image", &target, []string{"qa-key"})
+	assert.Check(t, errors.Is(err, ErrRequiredSignerMissing), "unexpected error: %v", err)
+	err = checkRequiredSigners(&signedRepository{signed: notaryRepo.signed[1:]}, "gcr.io/project/image", &target, []string{"qa-key"})
+	assert.Check(t, errors.Is(err, ErrRequiredSignerMissing), "unexpected error: %v", err)
+	assert.Check(t, is.ErrorContains(err, "signed by keys [], expected one of [qa-key]"))
+
+	_, err = makeOptions(WithRequiredSignerKeys())
+	assert.Check(t, is.ErrorContains(err, "at least one"))
+}
+
+func TestGetSigningRoles(t *testing.T) {
+	manifest := sha256.Sum256([]byte("manifest"))
+	otherManifest := sha256.Sum256([]byte("other manifest"))
+	target := &client.Target{Name: "latest", Hashes: data.Hashes{"sha256": manifest[:]}, Length: 10}
+	signedBy := func(role data.RoleName, h [32]byte) client.TargetSignedStruct {
+		return client.TargetSignedStruct{
+			Role:   data.DelegationRole{BaseRole: data.BaseRole{Name: role}},
+			Target: client.Target{Name: "latest", Hashes: data.Hashes{"sha256": h[:]}, Length: 10},
+		}
+	}
+	notaryRepo := &signedRepository{signed: []client.TargetSignedStruct{
+		signedBy(data.CanonicalTargetsRole, manifest),
+		signedBy("targets/qa", manifest),
+		signedBy(trust.ReleasesRole, manifest),
+		signedBy("targets/releases/ci", manifest),
+		signedBy("targets/releases/other", otherManifest),
+	}}
+
+	roles, err := getSigningRoles(notaryRepo, "gcr.io/project/image", "latest", target)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(roles, []data.RoleName{data.CanonicalTargetsRole, trust.ReleasesRole, "targets/releases/ci"}))
+
+	// a target only an untrusted delegation signed has no signing roles
+	notaryRepo.signed = notaryRepo.signed[1:2]
+	roles, err = getSigningRoles(notaryRepo, "gcr.io/project/image", "latest", target)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(roles, 0))
+}
+
+func TestMostSpecificTarget(t *testing.T) {
+	manifest := sha256.Sum256([]byte("manifest"))
+	otherManifest := sha256.Sum256([]byte("other manifest"))
+	hashes := data.Hashes{"sha256": manifest[:]}
+	target := &client.Target{Name: "latest", Hashes: hashes, Length: 10}
+	signedBy := func(role data.RoleName, custom string, h data.Hashes) client.TargetSignedStruct {
+		raw := canonicaljson.RawMessage(custom)
+		return client.TargetSignedStruct{
+			Role:   data.DelegationRole{BaseRole: data.BaseRole{Name: role}},
+			Target: client.Target{Name: "latest", Hashes: h, Length: 10, Custom: &raw},
+		}
+	}
+	custom := func(t *client.Target) string {
+		if t.Custom == nil {
+			return ""
+		}
+		return string(*t.Custom)
+	}
+
+	signed := []client.TargetSignedStruct{
+		signedBy(data.CanonicalTargetsRole, `"targets"`, hashes),
+		signedBy(trust.ReleasesRole, `"releases"`, hashes),
+		signedBy("targets/qa", `"qa"`, hashes),
+		signedBy("targets/releases/ci", `"ci"`, hashes),
+		signedBy("targets/releases/other", `"other"`, data.Hashes{"sha256": otherManifest[:]}),
+		signedBy("targets/qa/nightly/deep", `"deep"`, hashes),
+		signedBy("targets/releases-old", `"old"`, hashes),
+	}
+	// notary would return the top level targets role, first in its walk;
+	// the deepest trusted delegation is returned instead
+	assert.Check(t, is.Equal(custom(mostSpecificTarget(signed, target)), `"ci"`))
+	assert.Check(t, is.Equal(custom(mostSpecificTarget(signed[:3], target)), `"releases"`))
+	assert.Check(t, is.Equal(custom(mostSpecificTarget(signed[:1], target)), `"targets"`))
+	// delegations outside of targets/releases are ignored, however deep
+	assert.Check(t, is.Equal(custom(mostSpecificTarget(append(signed[:1:1], signed[5:]...), target)), `"targets"`))
+	assert.Check(t, is.Equal(mostSpecificTarget(signed[2:3], target), target))
+	// metadata signing other content is never returned
+	assert.Check(t, is.Equal(mostSpecificTarget(signed[4:5], target), target))
+}
+
+func TestGetTrustedTargetByDigest(t *testing.T) {
+	manifest := sha256.Sum256([]byte("manifest"))
+	qa := sha256.Sum256([]byte("qa"))
+	digest := func(sum [sha256.Size]byte) v1.Hash {
+		return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+	}
+	notaryRepo := &removingRepository{targets: []*client.TargetWithRole{
+		{Target: client.Target{Name: "v1", Hashes: data.Hashes{"sha256": manifest[:]}}, Role: trust.ReleasesRole},
+		{Target: client.Target{Name: "latest", Hashes: data.Hashes{"sha256": manifest[:]}}, Role: data.CanonicalTargetsRole},
+		{Target: client.Target{Name: "candidate", Hashes: data.Hashes{"sha256": manifest[:]}}, Role: "targets/qa"},
+		{Target: client.Target{Name: "qa", Hashes: data.Hashes{"sha256": qa[:]}}, Role: "targets/qa"},
+	}}
+	log := trust.DefaultLogger()
+
+	// the first trusted tag by name, whatever order notary lists them in
+	target, err := getTrustedTargetByDigest(log, notaryRepo, "gcr.io/project/image", digest(manifest))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(target.Name, "latest"))
+
+	// only signed by a delegation other than releases
+	_, err = getTrustedTargetByDigest(log, notaryRepo, "gcr.io/project/image", digest(qa))
+	assert.Check(t, errors.Is(err, ErrDigestNotSigned), "unexpected error: %v", err)
+}
+
+func TestTargetDigest(t *testing.T) {
+	manifest := sha256.Sum256([]byte("manifest"))
+	digest, err := targetDigest(&client.Target{Name: "latest", Hashes: data.Hashes{"sha256": manifest[:]}})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(digest.String(), "sha256:"+hex.EncodeToString(manifest[:])))
+
+	_, err = targetDigest(&client.Target{Name: "latest", Hashes: data.Hashes{"sha512": manifest[:]}})
+	assert.Check(t, is.ErrorContains(err, "no valid sha256 hash"))
+}
+
+func TestPinnedReference(t *testing.T) {
+	manifest := sha256.Sum256([]byte("manifest"))
+	digest, err := targetDigest(&client.Target{Name: "latest", Hashes: data.Hashes{"sha256": manifest[:]}})
+	assert.NilError(t, err)
+	for _, tc := range []struct {
+		ref      string
+		expected string
+	}{
+		{ref: "gcr.io/project/image:latest", expected: "gcr.io/project/image@"},
+		{ref: "localhost:5000/project/image:v1", expected: "localhost:5000/project/image@"},
+		{ref: "gcr.io/project/image@" + digest.String(), expected: "gcr.io/project/image@"},
+	} {
+		ref, err := name.ParseReference(tc.ref)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(pinnedReference(ref.Context(), digest), tc.expected+digest.String()))
+	}
+
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+	_, err = repo.PinnedReference("latest")
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+}
+
+// newUninitializedRepository returns a repository of a GUN the notary server
+// it talks to has no trust data for, with a trust directory of its own, and a
+// function cleaning them up. Like a real notary server, the server hands out
+// a timestamp key so that the repository can be initialized. pings returns
+// the number of token handshakes the server has seen.
+func newUninitializedRepository(t *testing.T, opts ...Option) (repo *TrustedGcrRepository, pings func() int, cleanup func()) {
+	timestampKey, err := utils.GenerateECDSAKey(rand.Reader)
+	assert.NilError(t, err)
+	timestampPub, err := json.Marshal(data.PublicKeyFromPrivate(timestampKey))
+	assert.NilError(t, err)
+	var pinged int32
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			atomic.AddInt32(&pinged, 1)
+		case strings.HasSuffix(r.URL.Path, "/_trust/tuf/timestamp.key"):
+			w.Write(timestampPub)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	configDir, err := ioutil.TempDir("", "notary-gcr")
+	assert.NilError(t, err)
+	cleanup = func() {
+		s.Close()
+		os.RemoveAll(configDir)
+	}
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(configDir, "gcr-config.json"), []byte("{}"), 0600))
+
+	ref, err := name.ParseReference("gcr.io/project/never-signed:latest")
+	assert.NilError(t, err)
+	r, err := NewTrustedGcrRepositoryWithOptions(ref, append([]Option{
+		WithConfigDir(configDir),
+		WithTrustServer(s.URL),
+		WithNotaryTransport(s.Client().Transport),
+	}, opts...)...)
+	assert.NilError(t, err)
+	return &r, func() int { return int(atomic.LoadInt32(&pinged)) }, cleanup
+}
+
+func TestVerifyUninitializedRepository(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+
+	_, err := repo.Verify()
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+}
+
+func TestNotaryRepository(t *testing.T) {
+	repo, pings, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+
+	notaryRepo, err := repo.NotaryRepository()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(notaryRepo.GetGUN(), data.GUN("gcr.io/project/never-signed")))
+	_, err = repo.VerifyTag("latest")
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+	again, err := repo.NotaryRepository()
+	assert.NilError(t, err)
+	assert.Check(t, again == notaryRepo, "expected the notary repository to be reused")
+	assert.Check(t, is.Equal(pings(), 1))
+}
+
+func TestNotaryCallsCancelled(t *testing.T) {
+	repo, pings, cleanup := newUninitializedRepository(t,
+		WithDeferredPublish(),
+		WithPassphraseRetriever(passphrase.ConstantRetriever("passphrase")),
+	)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.VerifyContext(ctx)
+	assert.Check(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	_, err = repo.ListTargetContext(ctx)
+	assert.Check(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	err = repo.SignImageContext(ctx, empty.Image)
+	assert.Check(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	assert.Check(t, is.Equal(pings(), 0), "expected the notary server not to be reached")
+
+	// the handle set up by a cancelled call still serves later ones
+	_, err = repo.Verify()
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+	assert.Check(t, is.Equal(pings(), 1))
+}
+
+type recordingObserver struct {
+	nopObserver
+	verified []error
+}
+
+func (o *recordingObserver) ObserveVerify(d time.Duration, err error) {
+	o.verified = append(o.verified, err)
+}
+
+func TestObserveVerify(t *testing.T) {
+	observer := &recordingObserver{}
+	repo, _, cleanup := newUninitializedRepository(t, WithObserver(observer))
+	defer cleanup()
+
+	_, err := repo.Verify()
+	assert.Assert(t, is.Len(observer.verified, 1))
+	assert.Check(t, is.Equal(observer.verified[0], err))
+}
+
+func TestVerifyHook(t *testing.T) {
+	var tags []string
+	var errs []error
+	repo, _, cleanup := newUninitializedRepository(t, WithVerifyHook(func(tag string, target *client.Target, err error) {
+		assert.Check(t, is.Nil(target))
+		tags = append(tags, tag)
+		errs = append(errs, err)
+	}))
+	defer cleanup()
+
+	_, err := repo.VerifyTag("v1")
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+	_, err = repo.VerifyOffline()
+	assert.Check(t, err != nil)
+	assert.Check(t, is.DeepEqual(tags, []string{"v1", "latest"}))
+	assert.Assert(t, is.Len(errs, 2))
+	assert.Check(t, errors.Is(errs[0], ErrNoTrustData), "unexpected error: %v", errs[0])
+	assert.Check(t, is.Equal(errs[1], err))
+}
+
+func TestRootChangeHook(t *testing.T) {
+	var changes [][2]string
+	repo, _, cleanup := newUninitializedRepository(t, WithRootChangeHook(func(oldKeyID, newKeyID string) {
+		changes = append(changes, [2]string{oldKeyID, newKeyID})
+	}))
+	defer cleanup()
+	metadataDir := filepath.Join(repo.config.RootPath, "trust", "tuf", "gcr.io", "project", "never-signed", "metadata")
+	assert.NilError(t, os.MkdirAll(metadataDir, 0700))
+	cacheRoot := func(key data.PublicKey) {
+		roles := map[data.RoleName]*data.RootRole{}
+		for _, role := range data.BaseRoles {
+			roles[role] = &data.RootRole{KeyIDs: []string{key.ID()}, Threshold: 1}
+		}
+		root, err := data.NewRoot(data.Keys{key.ID(): key}, roles, false)
+		assert.NilError(t, err)
+		root.Signed.Version = 1
+		s, err := root.ToSigned()
+		assert.NilError(t, err)
+		raw, err := json.Marshal(s)
+		assert.NilError(t, err)
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(metadataDir, "root.json"), raw, 0600))
+	}
+	original := data.NewPublicKey(data.ECDSAx509Key, []byte("original"))
+	rotated := data.NewPublicKey(data.ECDSAx509Key, []byte("rotated"))
+
+	// nothing cached before, so there is nothing to compare against
+	done := repo.watchRootKey()
+	cacheRoot(original)
+	done()
+	assert.Check(t, is.Len(changes, 0))
+
+	// the root stays the same
+	done = repo.watchRootKey()
+	cacheRoot(original)
+	done()
+	assert.Check(t, is.Len(changes, 0))
+
+	done = repo.watchRootKey()
+	cacheRoot(rotated)
+	done()
+	assert.Check(t, is.DeepEqual(changes, [][2]string{{original.ID(), rotated.ID()}}))
+
+	_, err := NewTrustedGcrRepositoryWithOptions(repo.ref, WithRootChangeHook(nil))
+	assert.Check(t, is.ErrorContains(err, "root change hook must not be nil"))
+}
+
+func TestVerifyAtVersion(t *testing.T) {
+	observer := &recordingObserver{}
+	var tags []string
+	repo, _, cleanup := newUninitializedRepository(t, WithObserver(observer), WithVerifyHook(func(tag string, target *client.Target, err error) {
+		assert.Check(t, is.Nil(target))
+		tags = append(tags, tag)
+	}))
+	defer cleanup()
+
+	_, err := repo.VerifyAtVersion("latest", 0)
+	assert.Check(t, is.ErrorContains(err, "invalid targets metadata version 0"))
+
+	_, err = repo.VerifyAtVersion("v1", 1)
+	assert.Check(t, errors.Is(err, ErrUninitialized), "unexpected error: %v", err)
+	assert.Check(t, is.DeepEqual(tags, []string{"latest", "v1"}))
+	assert.Assert(t, is.Len(observer.verified, 2))
+	assert.Check(t, is.Equal(observer.verified[1], err))
+}
+
+func TestFetchImage(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/project/image:latest")
+	assert.NilError(t, err)
+	assert.NilError(t, remote.Write(ref, empty.Image))
+	digest, err := empty.Image.Digest()
+	assert.NilError(t, err)
+
+	img, err := fetchImage(ref.Context(), digest)
+	assert.NilError(t, err)
+	got, err := img.Digest()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(got, digest))
+
+	missing := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}
+	_, err = fetchImage(ref.Context(), missing)
+	assert.Check(t, errors.Is(err, ErrDigestNotInRegistry), "unexpected error: %v", err)
+}
+
+func TestCheckManifestSize(t *testing.T) {
+	manifest, err := empty.Image.RawManifest()
+	assert.NilError(t, err)
+	target := &client.Target{Name: "latest", Length: int64(len(manifest))}
+	assert.Check(t, checkManifestSize(empty.Image, target))
+
+	target.Length++
+	err = checkManifestSize(empty.Image, target)
+	assert.Check(t, errors.Is(err, ErrSizeMismatch), "unexpected error: %v", err)
+}
+
+func TestVerifyImageUninitializedRepository(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+
+	_, err := repo.VerifyImage("latest")
+	assert.Check(t, errors.Is(err, ErrNoTrustData), "unexpected error: %v", err)
+}
+
+func TestIsRevokedUninitializedRepository(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+
+	digest, err := empty.Image.Digest()
+	assert.NilError(t, err)
+	revoked, err := repo.IsRevoked(digest)
+	assert.NilError(t, err)
+	assert.Check(t, revoked)
+}
+
+func TestIsRevoked(t *testing.T) {
+	repo, _, cleanup := newUninitializedRepository(t)
+	defer cleanup()
+	released := sha256.Sum256([]byte("released"))
+	qa := sha256.Sum256([]byte("qa"))
+	repo.notary = &removingRepository{targets: []*client.TargetWithRole{
+		{Target: client.Target{Name: "v1", Hashes: data.Hashes{"sha256": released[:]}}, Role: trust.ReleasesRole},
+		{Target: client.Target{Name: "qa", Hashes: data.Hashes{"sha256": qa[:]}}, Role: "targets/qa"},
+	}}
+
+	revoked, err := repo.IsRevoked(v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(released[:])})
+	assert.NilError(t, err)
+	assert.Check(t, !revoked)
+
+	// its releases tag was revoked, only another delegation still signs it
+	revoked, err = repo.IsRevoked(v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(qa[:])})
+	assert.NilError(t, err)
+	assert.Check(t, revoked)
+}
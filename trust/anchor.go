@@ -0,0 +1,78 @@
+package trust
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+)
+
+// ParseTrustAnchor parses rootJSON, signed root metadata delivered out of
+// band, and checks that it is signed by a threshold of its own root keys.
+func ParseTrustAnchor(rootJSON []byte) (*data.SignedRoot, error) {
+	s, root, err := parseRoot(rootJSON)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid trust anchor")
+	}
+	role, err := root.BuildBaseRole(data.CanonicalRootRole)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid trust anchor")
+	}
+	if err := signed.VerifySignatures(s, role); err != nil {
+		return nil, errors.Wrap(err, "trust anchor is not signed by its root keys")
+	}
+	return root, nil
+}
+
+func parseRoot(raw []byte) (*data.Signed, *data.SignedRoot, error) {
+	var s data.Signed
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, nil, err
+	}
+	root, err := data.RootFromSigned(&s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &s, root, nil
+}
+
+// anchoredRoot returns the root metadata notary must start from to verify the
+// trust data against anchor: cached, the root metadata cached from an earlier
+// update, if it is anchor or a later version signed by the root keys of
+// anchor, and anchor otherwise, so that notary downloads and verifies the
+// chain of root rotations from anchor again.
+func anchoredRoot(cached, anchor []byte) []byte {
+	if cached == nil || bytes.Equal(cached, anchor) {
+		return anchor
+	}
+	s, root, err := parseRoot(cached)
+	if err != nil {
+		return anchor
+	}
+	_, anchorRoot, err := parseRoot(anchor)
+	if err != nil || root.Signed.Version <= anchorRoot.Signed.Version {
+		return anchor
+	}
+	role, err := anchorRoot.BuildBaseRole(data.CanonicalRootRole)
+	if err != nil || signed.VerifySignatures(s, role) != nil {
+		return anchor
+	}
+	return cached
+}
+
+// seedTrustAnchor makes the root metadata of cache chain to anchor, see
+// anchoredRoot.
+func seedTrustAnchor(cache storage.MetadataStore, anchor []byte) error {
+	cached, err := cache.GetSized(data.CanonicalRootRole.String(), storage.NoSizeLimit)
+	if err != nil {
+		cached = nil
+	}
+	root := anchoredRoot(cached, anchor)
+	if bytes.Equal(root, cached) {
+		return nil
+	}
+	return cache.Set(data.CanonicalRootRole.String(), root)
+}
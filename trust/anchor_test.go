@@ -0,0 +1,70 @@
+package trust
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// signedRoot returns version of root metadata with rootKey as root key, signed
+// by signer.
+func signedRoot(t *testing.T, cs *cryptoservice.CryptoService, rootKey, signer data.PublicKey, version int) []byte {
+	roles := map[data.RoleName]*data.RootRole{}
+	for _, role := range data.BaseRoles {
+		roles[role] = &data.RootRole{KeyIDs: []string{rootKey.ID()}, Threshold: 1}
+	}
+	root, err := data.NewRoot(data.Keys{rootKey.ID(): rootKey}, roles, false)
+	assert.NilError(t, err)
+	root.Signed.Version = version
+	s, err := root.ToSigned()
+	assert.NilError(t, err)
+	assert.NilError(t, signed.Sign(cs, s, []data.PublicKey{signer}, 1, nil))
+	raw, err := json.Marshal(s)
+	assert.NilError(t, err)
+	return raw
+}
+
+func TestParseTrustAnchor(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	key, err := cs.Create(data.CanonicalRootRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	other, err := cs.Create(data.CanonicalRootRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+
+	root, err := ParseTrustAnchor(signedRoot(t, cs, key, key, 1))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(root.Signed.Version, 1))
+
+	_, err = ParseTrustAnchor(signedRoot(t, cs, key, other, 1))
+	assert.Check(t, is.ErrorContains(err, "not signed by its root keys"))
+
+	_, err = ParseTrustAnchor([]byte("{"))
+	assert.Check(t, is.ErrorContains(err, "invalid trust anchor"))
+}
+
+func TestAnchoredRoot(t *testing.T) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	key, err := cs.Create(data.CanonicalRootRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	rotated, err := cs.Create(data.CanonicalRootRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	anchor := signedRoot(t, cs, key, key, 2)
+
+	older := signedRoot(t, cs, key, key, 1)
+	rotation := signedRoot(t, cs, rotated, key, 3)
+	impostor := signedRoot(t, cs, rotated, rotated, 3)
+
+	assert.Check(t, is.DeepEqual(anchoredRoot(nil, anchor), anchor))
+	assert.Check(t, is.DeepEqual(anchoredRoot(anchor, anchor), anchor))
+	assert.Check(t, is.DeepEqual(anchoredRoot(older, anchor), anchor))
+	assert.Check(t, is.DeepEqual(anchoredRoot(rotation, anchor), rotation))
+	assert.Check(t, is.DeepEqual(anchoredRoot(impostor, anchor), anchor))
+	assert.Check(t, is.DeepEqual(anchoredRoot([]byte("{"), anchor), anchor))
+}
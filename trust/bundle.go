@@ -0,0 +1,91 @@
+package trust
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// GetCachedMetadata returns the TUF metadata of the notary repository of ref
+// cached in the trust directory of config, keyed by role name, e.g. root or
+// targets/releases. An error satisfying os.IsNotExist is returned when
+// nothing is cached.
+func GetCachedMetadata(ref name.Reference, repoInfo *name.Registry, config *Config) (map[data.RoleName][]byte, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, err
+	}
+	dir := metadataDirectory(config, notaryGUN(ref.Context(), server))
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	meta := make(map[data.RoleName][]byte)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		meta[data.RoleName(filepath.ToSlash(strings.TrimSuffix(rel, ".json")))] = raw
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// SetCachedMetadata replaces the TUF metadata of the notary repository of ref
+// cached in the trust directory of config with meta, keyed by role name as
+// returned by GetCachedMetadata, e.g. to restore a backup. meta must verify
+// against its own root metadata, whose root keys must be certified for the
+// GUN, and, with a trust anchor, that root must chain to the anchor. Expired
+// metadata is accepted, as notary refreshes it from the server on next use,
+// and delegation metadata that does not verify is left out.
+func SetCachedMetadata(ref name.Reference, repoInfo *name.Registry, config *Config, meta map[data.RoleName][]byte) error {
+	cache, gun, err := metadataCache(ref, repoInfo, config)
+	if err != nil {
+		return err
+	}
+	root, ok := meta[data.CanonicalRootRole]
+	if !ok {
+		return errors.Errorf("no root metadata for %s", gun)
+	}
+	if config.TrustAnchor != nil && !bytes.Equal(anchoredRoot(root, config.TrustAnchor), root) {
+		return errors.Errorf("root metadata for %s does not chain to the trust anchor", gun)
+	}
+	repo, err := loadIgnoringExpiry(data.GUN(gun), root, storage.NewMemoryStore(meta), false)
+	if err != nil {
+		return errors.Wrapf(err, "invalid trust metadata for %s", gun)
+	}
+
+	// only the metadata that verified is cached
+	roles := append([]data.RoleName(nil), BaseRoles...)
+	for role := range repo.Targets {
+		if role != data.CanonicalTargetsRole {
+			roles = append(roles, role)
+		}
+	}
+	if err := cache.RemoveAll(); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := cache.Set(role.String(), meta[role]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
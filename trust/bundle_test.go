@@ -0,0 +1,48 @@
+package trust
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestSetCachedMetadata(t *testing.T) {
+	rootPath, err := ioutil.TempDir("", "notary-gcr")
+	assert.NilError(t, err)
+	defer os.RemoveAll(rootPath)
+	config := &Config{RootPath: rootPath, ServerUrl: "https://notary.example.com"}
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	registry := ref.Context().Registry
+
+	_, err = GetCachedMetadata(ref, &registry, config)
+	assert.Check(t, os.IsNotExist(err), "unexpected error: %v", err)
+
+	// expired metadata is restored too
+	meta := signedMetadata(t, time.Now().Add(-time.Hour))
+	bundle := map[data.RoleName][]byte{"targets/unknown": []byte("{}")}
+	for role, raw := range meta {
+		bundle[role] = raw
+	}
+	assert.NilError(t, SetCachedMetadata(ref, &registry, config, bundle))
+	cached, err := GetCachedMetadata(ref, &registry, config)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(cached, meta))
+
+	// metadata that does not verify against its root is rejected
+	tampered := signedMetadata(t, time.Now().Add(time.Hour))
+	tampered[data.CanonicalRootRole] = meta[data.CanonicalRootRole]
+	assert.Check(t, SetCachedMetadata(ref, &registry, config, tampered) != nil)
+	cached, err = GetCachedMetadata(ref, &registry, config)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(cached, meta))
+
+	delete(tampered, data.CanonicalRootRole)
+	assert.Check(t, is.ErrorContains(SetCachedMetadata(ref, &registry, config, tampered), "no root metadata"))
+}
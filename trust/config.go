@@ -1,21 +1,96 @@
 package trust
 
 import (
+	"bytes"
+	"crypto/x509"
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
 )
 
+// Config is the trust config of notary repositories, read by ParseConfig from
+// the config file of a config directory, by ParseConfigFromEnv from the
+// environment, or filled in programmatically.
 type Config struct {
-	RootPath             string
-	ServerUrl            string `json:"server_url"`
+	// RootPath is the config directory. The signing keys and the TUF
+	// metadata cache are kept in its trust directory, and the TLS
+	// certificates of notary servers in its tls/<host> directories. The
+	// default config directory is used when it is empty.
+	RootPath string
+	// ServerUrl is the https URL of the notary server. When it is empty the
+	// notary server is derived from the registry.
+	ServerUrl string `json:"server_url"`
+	// RootPassphrase and RepositoryPassphrase are the passphrases of the root
+	// key and of the other signing keys.
 	RootPassphrase       string `json:"root_passphrase"`
 	RepositoryPassphrase string `json:"repository_passphrase"`
-	Scopes               string `json:"scopes,omitempty"`
+	// Scopes are the actions requested on the notary repository, "pull" by
+	// default.
+	Scopes string `json:"scopes,omitempty"`
+
+	// Logger receives the diagnostics of notary operations. The standard
+	// logrus logger is used when it is nil.
+	Logger Logger `json:"-"`
+	// PassRetriever supplies the passphrases of the notary signing keys. When
+	// it is nil the passphrases configured above are used, falling back to an
+	// interactive prompt.
+	PassRetriever notary.PassRetriever `json:"-"`
+	// Retry is the policy used to retry notary server calls on transient
+	// failures. No call is retried by default.
+	Retry RetryPolicy `json:"-"`
+	// Transport is the base transport of notary server calls. When it is nil
+	// a transport trusting the certificates of the tls directory is used.
+	Transport http.RoundTripper `json:"-"`
+	// CacheDir, when set, is where the TUF metadata of notary repositories
+	// is cached, in a directory per GUN, instead of the trust directory,
+	// e.g. a writable tmpfs when the config directory is read-only.
+	CacheDir string `json:"cache_dir,omitempty"`
+	// RootCAs, when set, are the certificate authorities trusted to have
+	// issued the TLS certificate of the notary server, in place of the system
+	// roots and the CA certificates of the tls directory. It is ignored when
+	// Transport is set.
+	RootCAs *x509.CertPool `json:"-"`
+	// InsecureSkipVerify, when set, decides whether the TLS certificate of
+	// the notary server goes unverified. Otherwise it is only verified for
+	// registries accessed over plain http or when RootCAs is set. It is
+	// ignored when Transport is set.
+	InsecureSkipVerify *bool `json:"-"`
+	// KeyAlgorithm is the algorithm of the keys generated for the repository,
+	// data.ECDSAKey or data.ED25519Key. Root keys are always ECDSA keys, and
+	// notary's default of ECDSA is used when it is empty.
+	KeyAlgorithm string `json:"-"`
+	// KeyGenHook, when set, is called with the role and ID of every signing
+	// key generated in the local key store, e.g. by initialization or key
+	// rotation, right after the key is created and before any metadata
+	// signed with it is published.
+	KeyGenHook func(role data.RoleName, keyID string) `json:"-"`
+	// KeyStore, when set, holds the signing keys in place of the private
+	// directory of the trust directory, e.g. a store made by
+	// NewMemoryKeyStore.
+	KeyStore trustmanager.KeyStore `json:"-"`
+	// MetadataExpiry, when set, is how long the metadata of the roles it
+	// holds, among the root, targets, snapshot and timestamp roles, stays
+	// valid after it is signed locally, in place of notary's defaults.
+	// Delegations use the period of the targets role. Metadata signed by the
+	// notary server keeps the server's expiry.
+	MetadataExpiry map[data.RoleName]time.Duration `json:"-"`
+	// TrustAnchor, when set, is the root metadata, root.json, the trust data
+	// of the repository must chain to, as delivered out of band, instead of
+	// trusting the root metadata first seen on the notary server. See
+	// ParseTrustAnchor.
+	TrustAnchor []byte `json:"-"`
 }
 
 const (
@@ -23,27 +98,24 @@ const (
 	configDirEnv          = "NOTARY_CONFIG_DIR"
 	configFileNameEnv     = "NOTARY_CONFIG_FILENAME"
 	defaultConfigFileName = "gcr-config.json"
+
+	// serverEnv, rootCAEnv and cacheDirEnv configure the notary server URL,
+	// the file of the PEM certificate authorities trusted to have issued
+	// its TLS certificate, and the TUF metadata cache directory.
+	serverEnv   = "NOTARY_SERVER"
+	rootCAEnv   = "NOTARY_ROOT_CA"
+	cacheDirEnv = "NOTARY_TRUST_CACHE_DIR"
 )
 
 // ParseConfig read configfile (${configDir}/${configFileName})
 // returns a Config object and error.
 func ParseConfig(configDir string) (*Config, error) {
-	if configDir == "" {
-		configDir = os.Getenv(configDirEnv)
-		if configDir == "" {
-			configDir = filepath.Join(os.Getenv("HOME"), ".notary")
-		}
-	}
+	configDir = configDirectory(configDir)
 	if !filepath.IsAbs(configDir) {
 		log.Warnf("config directory %s maybe wrong, not absolute path", configDir)
 	}
 
-	configFileName := os.Getenv(configFileNameEnv)
-	if configFileName == "" {
-		configFileName = defaultConfigFileName
-	}
-
-	configFilePath := filepath.Join(configDir, configFileName)
+	configFilePath := configFilePath(configDir)
 	configFile, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
 		return nil, err
@@ -59,17 +131,140 @@ func ParseConfig(configDir string) (*Config, error) {
 	return c, nil
 }
 
+// ParseConfigStrict is like ParseConfig but rejects a config that would only
+// fail later on: a missing config directory, an unreadable or malformed
+// config file, unknown fields, a server_url that is missing or not an https
+// URL and unsupported scopes. The errors name the config file and the
+// offending field.
+func ParseConfigStrict(configDir string) (*Config, error) {
+	configDir = configDirectory(configDir)
+	info, err := os.Stat(configDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "config directory %s", configDir)
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("config directory %s is not a directory", configDir)
+	}
+
+	path := configFilePath(configDir)
+	configFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read trust config %s", path)
+	}
+	c := new(Config)
+	decoder := json.NewDecoder(bytes.NewReader(configFile))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, errors.Wrapf(err, "invalid trust config %s", path)
+	}
+
+	if c.ServerUrl == "" {
+		return nil, errors.Errorf("trust config %s: server_url is required", path)
+	}
+	if u, err := url.Parse(c.ServerUrl); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, errors.Errorf("trust config %s: server_url %q is not a valid https URL", path, c.ServerUrl)
+	}
+	if c.Scopes != "" && !contains(supportedScopes, c.Scopes) {
+		return nil, errors.Errorf("trust config %s: scopes %q is not one of %s", path, c.Scopes, strings.Join(supportedScopes, ", "))
+	}
+	c.Scopes = parseScopes(c)
+	c.RootPath = configDir
+	return c, nil
+}
+
+// ParseConfigFromEnv is like ParseConfig but lets environment variables
+// override the config file, which may then be missing, e.g. in containers
+// configured solely through their environment:
+//
+//   - NOTARY_SERVER sets server_url
+//   - NOTARY_ROOT_CA names a file of PEM certificate authorities trusted to
+//     have issued the TLS certificate of the notary server, see RootCAs
+//   - NOTARY_TRUST_CACHE_DIR sets cache_dir
+//
+// A variable that is unset or empty leaves the setting of the config file.
+func ParseConfigFromEnv(configDir string) (*Config, error) {
+	c, err := ParseConfig(configDir)
+	if os.IsNotExist(err) {
+		c = &Config{RootPath: configDirectory(configDir)}
+		c.Scopes = parseScopes(c)
+	} else if err != nil {
+		return nil, err
+	}
+	if server := os.Getenv(serverEnv); server != "" {
+		c.ServerUrl = server
+	}
+	if cacheDir := os.Getenv(cacheDirEnv); cacheDir != "" {
+		c.CacheDir = cacheDir
+	}
+	if rootCA := os.Getenv(rootCAEnv); rootCA != "" {
+		pem, err := ioutil.ReadFile(rootCA)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read %s", rootCAEnv)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("%s %s holds no PEM certificate", rootCAEnv, rootCA)
+		}
+	}
+	return c, nil
+}
+
+// configFilePath returns the path of the config file in configDir, named by
+// NOTARY_CONFIG_FILENAME or gcr-config.json.
+func configFilePath(configDir string) string {
+	configFileName := os.Getenv(configFileNameEnv)
+	if configFileName == "" {
+		configFileName = defaultConfigFileName
+	}
+	return filepath.Join(configDir, configFileName)
+}
+
+// configDirectory returns configDir, or the default config directory when it
+// is empty: NOTARY_CONFIG_DIR, falling back to ~/.notary.
+func configDirectory(configDir string) string {
+	if configDir != "" {
+		return configDir
+	}
+	if dir := os.Getenv(configDirEnv); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".notary")
+}
+
+// passRetriever returns the configured PassRetriever, or one using the
+// passphrases of c and prompting for missing ones.
+func (c *Config) passRetriever() notary.PassRetriever {
+	if c.PassRetriever != nil {
+		return c.PassRetriever
+	}
+	return GetPassphraseRetriever(os.Stdin, os.Stderr, c.RootPassphrase, c.RepositoryPassphrase)
+}
+
+// NewMemoryKeyStore returns a key store keeping the signing keys in memory,
+// encrypted with the passphrases config supplies, for Config.KeyStore.
+func NewMemoryKeyStore(config *Config) trustmanager.KeyStore {
+	return trustmanager.NewKeyMemoryStore(config.passRetriever())
+}
+
+// supportedScopes are the scopes a trust config may request.
+var supportedScopes = []string{
+	transport.PullScope,
+	transport.PushScope,
+	transport.DeleteScope,
+	transport.CatalogScope,
+}
+
+// scopes returns the scopes requested on notary repositories, defaulting to
+// pull for configs not read by ParseConfig.
+func (c *Config) scopes() string {
+	return parseScopes(c)
+}
+
 func parseScopes(config *Config) string {
 	if config.Scopes == "" {
 		return transport.PullScope
 	}
-	supportScopes := []string{
-		transport.PullScope,
-		transport.PushScope,
-		transport.DeleteScope,
-		transport.CatalogScope,
-	}
-	if !contains(supportScopes, config.Scopes) {
+	if !contains(supportedScopes, config.Scopes) {
 		log.Warnf("Scope %s is not supported. Supported: ['pull', 'push,pull', 'catalog'] ", config.Scopes)
 		return transport.PullScope
 	}
@@ -1,6 +1,9 @@
 package trust
 
 import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"io/ioutil"
 	"path/filepath"
@@ -68,4 +71,90 @@ func TestParsePartialConfig(t *testing.T) {
 	if err := configFile.Close(); err != nil {
 		log.Fatal(err)
 	}
-}
\ No newline at end of file
+}
+
+func TestParseConfigStrict(t *testing.T) {
+	os.Setenv("NOTARY_CONFIG_FILENAME", "gcr-config.json")
+	defer os.Unsetenv("NOTARY_CONFIG_FILENAME")
+	dir, err := ioutil.TempDir("", "trust")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gcr-config.json")
+
+	_, err = ParseConfigStrict(filepath.Join(dir, "missing"))
+	assert.Check(t, is.ErrorContains(err, "config directory "+filepath.Join(dir, "missing")))
+	_, err = ParseConfigStrict(dir)
+	assert.Check(t, is.ErrorContains(err, "cannot read trust config "+path))
+
+	for _, c := range []struct {
+		config string
+		msg    string
+	}{
+		{`{"server_url":`, "invalid trust config " + path},
+		{`{"server":"https://notary.example.com"}`, `unknown field "server"`},
+		{`{}`, "server_url is required"},
+		{`{"server_url":"http://notary.example.com"}`, `server_url "http://notary.example.com" is not a valid https URL`},
+		{`{"server_url":"https://notary.example.com","scopes":"admin"}`, `scopes "admin" is not one of`},
+	} {
+		assert.NilError(t, ioutil.WriteFile(path, []byte(c.config), 0600))
+		_, err = ParseConfigStrict(dir)
+		assert.Check(t, is.ErrorContains(err, c.msg), c.config)
+		assert.Check(t, is.ErrorContains(err, path), c.config)
+	}
+
+	assert.NilError(t, ioutil.WriteFile(path, []byte(`{"server_url":"https://notary.example.com","scopes":"push,pull"}`), 0600))
+	config, err := ParseConfigStrict(dir)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(config.ServerUrl, "https://notary.example.com"))
+	assert.Check(t, is.Equal(config.Scopes, "push,pull"))
+	assert.Check(t, is.Equal(config.RootPath, dir))
+}
+
+func TestParseConfigFromEnv(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "notary-gcr")
+	assert.NilError(t, err)
+	defer os.RemoveAll(configDir)
+	defer os.Unsetenv("NOTARY_CONFIG_FILENAME")
+	os.Setenv("NOTARY_CONFIG_FILENAME", "gcr-config.json")
+
+	// without a config file the environment is the whole config
+	os.Setenv("NOTARY_SERVER", "https://notary.example.com")
+	defer os.Unsetenv("NOTARY_SERVER")
+	conf, err := ParseConfigFromEnv(configDir)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(conf.ServerUrl, "https://notary.example.com"))
+	assert.Check(t, is.Equal(conf.RootPath, configDir))
+	assert.Check(t, is.Equal(conf.Scopes, "pull"))
+	assert.Check(t, is.Nil(conf.RootCAs))
+
+	// the environment takes precedence over the config file
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(configDir, "gcr-config.json"),
+		[]byte(`{"server_url": "https://127.0.0.1:4443", "root_passphrase": "root", "cache_dir": "/cache"}`), 0600))
+	os.Setenv("NOTARY_TRUST_CACHE_DIR", "/tmp/cache")
+	defer os.Unsetenv("NOTARY_TRUST_CACHE_DIR")
+	conf, err = ParseConfigFromEnv(configDir)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(conf.ServerUrl, "https://notary.example.com"))
+	assert.Check(t, is.Equal(conf.CacheDir, "/tmp/cache"))
+	assert.Check(t, is.Equal(conf.RootPassphrase, "root"))
+
+	os.Unsetenv("NOTARY_SERVER")
+	conf, err = ParseConfigFromEnv(configDir)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(conf.ServerUrl, "https://127.0.0.1:4443"))
+
+	s := httptest.NewTLSServer(http.NotFoundHandler())
+	defer s.Close()
+	rootCA := filepath.Join(configDir, "ca.pem")
+	assert.NilError(t, ioutil.WriteFile(rootCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0600))
+	os.Setenv("NOTARY_ROOT_CA", rootCA)
+	defer os.Unsetenv("NOTARY_ROOT_CA")
+	conf, err = ParseConfigFromEnv(configDir)
+	assert.NilError(t, err)
+	assert.Assert(t, conf.RootCAs != nil)
+	assert.Check(t, is.Len(conf.RootCAs.Subjects(), 1))
+
+	os.Setenv("NOTARY_ROOT_CA", filepath.Join(configDir, "gcr-config.json"))
+	_, err = ParseConfigFromEnv(configDir)
+	assert.Check(t, is.ErrorContains(err, "holds no PEM certificate"))
+}
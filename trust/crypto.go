@@ -0,0 +1,146 @@
+package trust
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/trustpinning"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+)
+
+// newNotaryRepository is like client.NewFileCachedRepository, keeping the TUF
+// cache, changelist and private keys in the same places under baseDir, but
+// keeps and generates keys as configured by config and seeds the cache with
+// its trust anchor, if any. A nil rt makes the repository use an offline
+// remote store.
+func newNotaryRepository(baseDir string, gun data.GUN, server string, rt http.RoundTripper, config *Config) (client.Repository, error) {
+	cache, err := storage.NewFileStore(metadataDirectory(config, gun.String()), "json")
+	if err != nil {
+		return nil, err
+	}
+	if config.TrustAnchor != nil {
+		if err := seedTrustAnchor(cache, config.TrustAnchor); err != nil {
+			return nil, err
+		}
+	}
+	cl, err := changelist.NewFileChangelist(changelistDirectory(baseDir, gun.String()))
+	if err != nil {
+		return nil, err
+	}
+	return buildNotaryRepository(baseDir, gun, server, rt, config, cache, cl)
+}
+
+// buildNotaryRepository returns a notary repository keeping its TUF metadata
+// in cache and its staged changes in cl.
+func buildNotaryRepository(baseDir string, gun data.GUN, server string, rt http.RoundTripper, config *Config, cache storage.MetadataStore, cl changelist.Changelist) (client.Repository, error) {
+	keyStore := config.KeyStore
+	if keyStore == nil {
+		fileStore, err := trustmanager.NewKeyFileStore(baseDir, config.passRetriever())
+		if err != nil {
+			return nil, err
+		}
+		keyStore = fileStore
+	}
+	base := cryptoservice.NewCryptoService(keyStore)
+	var cs signed.CryptoService = base
+	if config.KeyAlgorithm != "" {
+		cs = &keyAlgorithmService{CryptoService: base, algorithm: config.KeyAlgorithm}
+	}
+	if config.KeyGenHook != nil {
+		cs = &keyGenHookService{CryptoService: cs, hook: config.KeyGenHook}
+	}
+	remoteStore, err := storage.NewHTTPStore(server+"/v2/"+gun.String()+"/_trust/tuf/", "", "json", "key", rt)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := client.NewRepository(baseDir, gun, server, remoteStore, cache, trustpinning.TrustPinConfig{}, cs, cl)
+	if err != nil {
+		return nil, err
+	}
+	return &expiryRepository{Repository: repo, expiry: config.MetadataExpiry}, nil
+}
+
+// expiryMu guards the metadata expiry times notary keeps for the whole
+// process: metadata is signed with the read lock held, or with the write
+// lock held while the expiry times are changed for one repository.
+var expiryMu sync.RWMutex
+
+// expiryRepository signs metadata with the validity periods of expiry instead
+// of notary's defaults for the roles of expiry. Notary signs metadata when
+// initializing a repository, publishing and rotating keys.
+type expiryRepository struct {
+	client.Repository
+	expiry map[data.RoleName]time.Duration
+}
+
+// signing runs sign, which makes notary sign metadata, with the expiry times
+// of r in effect.
+func (r *expiryRepository) signing(sign func() error) error {
+	if len(r.expiry) == 0 {
+		expiryMu.RLock()
+		defer expiryMu.RUnlock()
+		return sign()
+	}
+	expiryMu.Lock()
+	defer expiryMu.Unlock()
+	data.SetDefaultExpiryTimes(r.expiry)
+	defer data.SetDefaultExpiryTimes(data.NotaryDefaultExpiries)
+	return sign()
+}
+
+func (r *expiryRepository) Initialize(rootKeyIDs []string, serverManagedRoles ...data.RoleName) error {
+	return r.signing(func() error { return r.Repository.Initialize(rootKeyIDs, serverManagedRoles...) })
+}
+
+func (r *expiryRepository) InitializeWithCertificate(rootKeyIDs []string, rootCerts []data.PublicKey, serverManagedRoles ...data.RoleName) error {
+	return r.signing(func() error {
+		return r.Repository.InitializeWithCertificate(rootKeyIDs, rootCerts, serverManagedRoles...)
+	})
+}
+
+func (r *expiryRepository) Publish() error {
+	return r.signing(r.Repository.Publish)
+}
+
+func (r *expiryRepository) RotateKey(role data.RoleName, serverManagesKey bool, keyList []string) error {
+	return r.signing(func() error { return r.Repository.RotateKey(role, serverManagesKey, keyList) })
+}
+
+// keyAlgorithmService generates every key but root keys with algorithm,
+// whatever algorithm notary asks for. Root keys are certified by X.509
+// certificates, which notary only produces for ECDSA keys.
+type keyAlgorithmService struct {
+	*cryptoservice.CryptoService
+	algorithm string
+}
+
+func (s *keyAlgorithmService) Create(role data.RoleName, gun data.GUN, algorithm string) (data.PublicKey, error) {
+	if role != data.CanonicalRootRole {
+		algorithm = s.algorithm
+	}
+	return s.CryptoService.Create(role, gun, algorithm)
+}
+
+// keyGenHookService calls hook with the role and ID of every key it
+// generates. Notary generates keys before signing metadata with them, so the
+// hook always runs before the key is published.
+type keyGenHookService struct {
+	signed.CryptoService
+	hook func(role data.RoleName, keyID string)
+}
+
+func (s *keyGenHookService) Create(role data.RoleName, gun data.GUN, algorithm string) (data.PublicKey, error) {
+	key, err := s.CryptoService.Create(role, gun, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	s.hook(role, key.ID())
+	return key, nil
+}
@@ -0,0 +1,54 @@
+package trust
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestKeyAlgorithmService(t *testing.T) {
+	cs := &keyAlgorithmService{
+		CryptoService: cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase"))),
+		algorithm:     data.ED25519Key,
+	}
+
+	targetsKey, err := cs.Create(data.CanonicalTargetsRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(targetsKey.Algorithm(), data.ED25519Key))
+
+	rootKey, err := cs.Create(data.CanonicalRootRole, "", data.ECDSAKey)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(rootKey.Algorithm(), data.ECDSAKey))
+}
+
+// publishingRepository records the targets expiry notary would sign with.
+type publishingRepository struct {
+	client.Repository
+	expires time.Time
+}
+
+func (r *publishingRepository) Publish() error {
+	r.expires = data.DefaultExpires(data.CanonicalTargetsRole)
+	return nil
+}
+
+func TestExpiryRepository(t *testing.T) {
+	published := &publishingRepository{}
+	repo := &expiryRepository{
+		Repository: published,
+		expiry:     map[data.RoleName]time.Duration{data.CanonicalTargetsRole: time.Hour},
+	}
+	assert.NilError(t, repo.Publish())
+	assert.Check(t, published.expires.Before(time.Now().Add(2*time.Hour)), "expires %s", published.expires)
+
+	// notary's defaults are back in effect afterwards
+	assert.NilError(t, (&expiryRepository{Repository: published}).Publish())
+	assert.Check(t, published.expires.After(time.Now().Add(80*24*time.Hour)), "expires %s", published.expires)
+}
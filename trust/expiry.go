@@ -0,0 +1,192 @@
+package trust
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/theupdateframework/notary"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/trustpinning"
+	"github.com/theupdateframework/notary/tuf"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// metadataGetter reads TUF metadata, from the notary server or a cache.
+type metadataGetter interface {
+	GetSized(name string, size int64) ([]byte, error)
+}
+
+// GetTargetIgnoringExpiry is like GetTargetByName of the notary repository of
+// ref, but verifies the trust data without checking whether it has expired,
+// and reports whether any of it has. It is meant for forensic inspection of
+// what an expired repository claims and must not be used to admit images.
+// The trust data is read from the notary server, or from the cache of the
+// trust directory if the server cannot provide it, and verified against the
+// cached root, or the trust anchor of config, without updating the cache.
+func GetTargetIgnoringExpiry(ctx context.Context, ref name.Reference, auth authn.Authenticator, repoInfo *name.Registry, config *Config, targetName string, roles ...data.RoleName) (_ *client.TargetWithRole, expired bool, err error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, false, err
+	}
+	gun := notaryGUN(ref.Context(), server)
+	rt, err := notaryRoundTripper(ctx, auth, repoInfo, server, gun, config.scopes(), config)
+	if err != nil {
+		return nil, false, err
+	}
+	remote, err := storage.NewHTTPStore(server+"/v2/"+gun+"/_trust/tuf/", "", "json", "key", rt)
+	if err != nil {
+		return nil, false, err
+	}
+	cache, _, err := metadataCache(ref, repoInfo, config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	root, err := trustedRoot(cache, remote, config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	repo, err := loadIgnoringExpiry(data.GUN(gun), root, remote, true)
+	if err != nil {
+		cached, cacheErr := loadIgnoringExpiry(data.GUN(gun), root, cache, false)
+		if cacheErr != nil {
+			return nil, false, err
+		}
+		config.logger().Warnf("falling back to cached trust data of %s: %s", gun, err)
+		repo = cached
+	}
+	target, err := findTarget(repo, targetName, roles...)
+	if err != nil {
+		return nil, false, err
+	}
+	return target, isExpired(repo), nil
+}
+
+// trustedRoot returns the root metadata to verify trust data against without
+// updating the cache: the cached root, or the trust anchor of config, or else
+// the root remote publishes, trusted on first use.
+func trustedRoot(cache, remote metadataGetter, config *Config) ([]byte, error) {
+	root, err := cache.GetSized(data.CanonicalRootRole.String(), storage.NoSizeLimit)
+	if err != nil {
+		root = nil
+	}
+	if config.TrustAnchor != nil {
+		root = anchoredRoot(root, config.TrustAnchor)
+	}
+	if root == nil {
+		return remote.GetSized(data.CanonicalRootRole.String(), storage.NoSizeLimit)
+	}
+	return root, nil
+}
+
+// loadIgnoringExpiry verifies the trust data of store against root, skipping
+// the expiry checks, and returns it. consistent is whether store serves the
+// snapshot and targets metadata by checksum, as notary servers do, rather than
+// by role name, as caches do. Delegations whose metadata cannot be read or
+// verified are left out, like notary does.
+func loadIgnoringExpiry(gun data.GUN, root []byte, store metadataGetter, consistent bool) (*tuf.Repo, error) {
+	builder := tuf.NewRepoBuilder(gun, nil, trustpinning.TrustPinConfig{})
+	if err := builder.Load(data.CanonicalRootRole, root, 1, true); err != nil {
+		return nil, err
+	}
+	timestamp, err := store.GetSized(data.CanonicalTimestampRole.String(), notary.MaxTimestampSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := builder.Load(data.CanonicalTimestampRole, timestamp, 1, true); err != nil {
+		return nil, err
+	}
+	get := func(role data.RoleName) ([]byte, error) {
+		info := builder.GetConsistentInfo(role)
+		metaName := role.String()
+		if consistent {
+			metaName = info.ConsistentName()
+		}
+		raw, err := store.GetSized(metaName, info.Length())
+		if err != nil {
+			return nil, err
+		}
+		return raw, builder.Load(role, raw, 1, true)
+	}
+	if _, err := get(data.CanonicalSnapshotRole); err != nil {
+		return nil, err
+	}
+
+	toLoad := []data.DelegationRole{{
+		BaseRole: data.BaseRole{Name: data.CanonicalTargetsRole},
+		Paths:    []string{""},
+	}}
+	for len(toLoad) > 0 {
+		role := toLoad[0]
+		toLoad = toLoad[1:]
+		if !builder.GetConsistentInfo(role.Name).ChecksumKnown() {
+			continue
+		}
+		raw, err := get(role.Name)
+		if err != nil {
+			if role.Name == data.CanonicalTargetsRole {
+				return nil, err
+			}
+			continue
+		}
+		var targets data.SignedTargets
+		if err := json.Unmarshal(raw, &targets); err != nil {
+			continue
+		}
+		toLoad = append(targets.GetValidDelegations(role), toLoad...)
+	}
+	repo, _, err := builder.Finish()
+	return repo, err
+}
+
+// findTarget looks targetName up in roles of repo, in order, like
+// GetTargetByName of notary repositories.
+func findTarget(repo *tuf.Repo, targetName string, roles ...data.RoleName) (*client.TargetWithRole, error) {
+	if len(roles) == 0 {
+		roles = []data.RoleName{data.CanonicalTargetsRole}
+	}
+	for _, role := range roles {
+		var found *client.TargetWithRole
+		visit := func(tgt *data.SignedTargets, validRole data.DelegationRole) interface{} {
+			if tgt == nil {
+				return nil
+			}
+			if meta, ok := tgt.Signed.Targets[targetName]; ok {
+				found = &client.TargetWithRole{
+					Target: client.Target{Name: targetName, Hashes: meta.Hashes, Length: meta.Length, Custom: meta.Custom},
+					Role:   validRole.Name,
+				}
+				return tuf.StopWalk{}
+			}
+			return nil
+		}
+		if err := repo.WalkTargets(targetName, role, visit, utils.RoleNameSliceRemove(roles, role)...); err == nil && found != nil {
+			return found, nil
+		}
+	}
+	return nil, client.ErrNoSuchTarget(targetName)
+}
+
+// isExpired reports whether any metadata of repo has expired.
+func isExpired(repo *tuf.Repo) bool {
+	common := []*data.SignedCommon{
+		&repo.Root.Signed.SignedCommon,
+		&repo.Snapshot.Signed.SignedCommon,
+		&repo.Timestamp.Signed.SignedCommon,
+	}
+	for _, targets := range repo.Targets {
+		common = append(common, &targets.Signed.SignedCommon)
+	}
+	for _, c := range common {
+		if signed.VerifyExpiry(c, "") != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package trust
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	canonicaljson "github.com/docker/go/canonical/json"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/cryptoservice"
+	"github.com/theupdateframework/notary/passphrase"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/trustmanager"
+	"github.com/theupdateframework/notary/tuf"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/utils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// newTestRepo returns a repository of gun with its base roles initialized,
+// whose keys cs holds.
+func newTestRepo(t *testing.T, gun data.GUN) (*tuf.Repo, *cryptoservice.CryptoService) {
+	cs := cryptoservice.NewCryptoService(trustmanager.NewKeyMemoryStore(passphrase.ConstantRetriever("passphrase")))
+	roles := map[data.RoleName]data.BaseRole{}
+	for _, role := range BaseRoles {
+		key, err := cs.Create(role, gun, data.ECDSAKey)
+		assert.NilError(t, err)
+		if role == data.CanonicalRootRole {
+			// notary only trusts root keys certified for the GUN
+			priv, _, err := cs.GetPrivateKey(key.ID())
+			assert.NilError(t, err)
+			cert, err := cryptoservice.GenerateCertificate(priv, gun, time.Now(), time.Now().Add(time.Hour))
+			assert.NilError(t, err)
+			key = utils.CertToKey(cert)
+		}
+		roles[role] = data.NewBaseRole(role, 1, key)
+	}
+	repo := tuf.NewRepo(cs)
+	assert.NilError(t, repo.InitRoot(roles[data.CanonicalRootRole], roles[data.CanonicalTimestampRole],
+		roles[data.CanonicalSnapshotRole], roles[data.CanonicalTargetsRole], false))
+	_, err := repo.InitTargets(data.CanonicalTargetsRole)
+	assert.NilError(t, err)
+	assert.NilError(t, repo.InitSnapshot())
+	assert.NilError(t, repo.InitTimestamp())
+	return repo, cs
+}
+
+// signedMetadata returns the signed base role metadata of a repository with a
+// latest target, whose timestamp expires at timestampExpiry.
+func signedMetadata(t *testing.T, timestampExpiry time.Time) map[data.RoleName][]byte {
+	repo, _ := newTestRepo(t, "gcr.io/project/image")
+	sum := sha256.Sum256([]byte("latest"))
+	_, err := repo.AddTargets(data.CanonicalTargetsRole, data.Files{"latest": {Length: 6, Hashes: data.Hashes{"sha256": sum[:]}}})
+	assert.NilError(t, err)
+
+	expires := time.Now().Add(time.Hour)
+	meta := map[data.RoleName][]byte{}
+	for role, sign := range map[data.RoleName]func() (*data.Signed, error){
+		data.CanonicalRootRole:    func() (*data.Signed, error) { return repo.SignRoot(expires, nil) },
+		data.CanonicalTargetsRole: func() (*data.Signed, error) { return repo.SignTargets(data.CanonicalTargetsRole, expires) },
+	} {
+		s, err := sign()
+		assert.NilError(t, err)
+		meta[role], err = canonicaljson.Marshal(s)
+		assert.NilError(t, err)
+	}
+	s, err := repo.SignSnapshot(expires)
+	assert.NilError(t, err)
+	meta[data.CanonicalSnapshotRole], err = canonicaljson.Marshal(s)
+	assert.NilError(t, err)
+	s, err = repo.SignTimestamp(timestampExpiry)
+	assert.NilError(t, err)
+	meta[data.CanonicalTimestampRole], err = canonicaljson.Marshal(s)
+	assert.NilError(t, err)
+	return meta
+}
+
+func TestLoadIgnoringExpiry(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		expiry  time.Time
+		expired bool
+	}{
+		{name: "expired", expiry: time.Now().Add(-time.Hour), expired: true},
+		{name: "fresh", expiry: time.Now().Add(time.Hour)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := signedMetadata(t, tc.expiry)
+			repo, err := loadIgnoringExpiry("gcr.io/project/image", meta[data.CanonicalRootRole], storage.NewMemoryStore(meta), false)
+			assert.NilError(t, err)
+			assert.Check(t, is.Equal(isExpired(repo), tc.expired))
+
+			target, err := findTarget(repo, "latest", ReleasesRole, data.CanonicalTargetsRole)
+			assert.NilError(t, err)
+			assert.Check(t, is.Equal(target.Role, data.CanonicalTargetsRole))
+			assert.Check(t, is.Equal(target.Length, int64(6)))
+
+			_, err = findTarget(repo, "missing", ReleasesRole, data.CanonicalTargetsRole)
+			_, ok := err.(client.ErrNoSuchTarget)
+			assert.Check(t, ok, "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestLoadIgnoringExpiryChecksSignatures(t *testing.T) {
+	meta := signedMetadata(t, time.Now().Add(-time.Hour))
+	other := signedMetadata(t, time.Now().Add(-time.Hour))
+
+	_, err := loadIgnoringExpiry("gcr.io/project/image", other[data.CanonicalRootRole], storage.NewMemoryStore(meta), false)
+	assert.Check(t, err != nil)
+}
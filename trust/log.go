@@ -0,0 +1,51 @@
+package trust
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Logger is the minimal logging interface used to report diagnostics.
+// *logrus.Logger and *logrus.Entry satisfy it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// FieldLogger is a Logger that can attach structured fields to the messages
+// it logs. Loggers other than those of logrus implement it to receive the
+// fields passed to WithFields.
+type FieldLogger interface {
+	Logger
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// WithFields returns l with fields attached to every message it logs, e.g.
+// the GUN and tag an operation is about. Loggers that are neither logrus
+// loggers nor FieldLoggers are returned as is.
+func WithFields(l Logger, fields map[string]interface{}) Logger {
+	switch l := l.(type) {
+	case *log.Logger:
+		return l.WithFields(log.Fields(fields))
+	case *log.Entry:
+		return l.WithFields(log.Fields(fields))
+	case FieldLogger:
+		return l.WithFields(fields)
+	}
+	return l
+}
+
+// DefaultLogger returns the logger used when none is configured, the
+// standard logrus logger.
+func DefaultLogger() Logger {
+	return log.StandardLogger()
+}
+
+// logger returns the logger configured in c, or DefaultLogger.
+func (c *Config) logger() Logger {
+	if c == nil || c.Logger == nil {
+		return DefaultLogger()
+	}
+	return c.Logger
+}
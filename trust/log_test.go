@@ -0,0 +1,41 @@
+package trust
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+type fieldRecorder struct {
+	Logger
+	fields map[string]interface{}
+}
+
+func (r *fieldRecorder) WithFields(fields map[string]interface{}) Logger {
+	r.fields = fields
+	return r
+}
+
+func TestWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+	logger.Formatter = &log.JSONFormatter{}
+	fields := map[string]interface{}{"gun": "gcr.io/project/image", "tag": "latest"}
+
+	WithFields(WithFields(logger, fields), map[string]interface{}{"digest": "sha256:abc"}).Infof("signed")
+	var entry map[string]interface{}
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Check(t, is.Equal(entry["gun"], "gcr.io/project/image"))
+	assert.Check(t, is.Equal(entry["tag"], "latest"))
+	assert.Check(t, is.Equal(entry["digest"], "sha256:abc"))
+	assert.Check(t, is.Equal(entry["msg"], "signed"))
+
+	recorder := &fieldRecorder{Logger: logger}
+	assert.Check(t, is.Equal(WithFields(recorder, fields), Logger(recorder)))
+	assert.Check(t, is.DeepEqual(recorder.fields, fields))
+}
@@ -0,0 +1,245 @@
+package trust
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// BaseRoles are the top level TUF roles of every notary repository.
+var BaseRoles = []data.RoleName{
+	data.CanonicalRootRole,
+	data.CanonicalTargetsRole,
+	data.CanonicalSnapshotRole,
+	data.CanonicalTimestampRole,
+}
+
+// GetMetadataExpiries returns the expiry time of the base roles of the notary
+// repository of ref, as found in the metadata cached in the trust directory of
+// config. Roles that have no cached metadata are left out.
+func GetMetadataExpiries(ref name.Reference, repoInfo *name.Registry, config *Config) (map[data.RoleName]time.Time, error) {
+	cache, gun, err := metadataCache(ref, repoInfo, config)
+	if err != nil {
+		return nil, err
+	}
+
+	expiries := make(map[data.RoleName]time.Time, len(BaseRoles))
+	for _, role := range BaseRoles {
+		raw, err := cache.GetSized(role.String(), storage.NoSizeLimit)
+		if _, ok := err.(storage.ErrMetaNotFound); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		expires, err := metadataExpiry(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s metadata of %s", role, gun)
+		}
+		expiries[role] = expires
+	}
+	return expiries, nil
+}
+
+// GetMetadataVersions is like GetMetadataExpiries but returns the version of
+// the cached metadata of the base roles.
+func GetMetadataVersions(ref name.Reference, repoInfo *name.Registry, config *Config) (map[data.RoleName]int, error) {
+	cache, gun, err := metadataCache(ref, repoInfo, config)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[data.RoleName]int, len(BaseRoles))
+	for _, role := range BaseRoles {
+		raw, err := cache.GetSized(role.String(), storage.NoSizeLimit)
+		if _, ok := err.(storage.ErrMetaNotFound); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		common, err := metadataCommon(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s metadata of %s", role, gun)
+		}
+		versions[role] = common.Version
+	}
+	return versions, nil
+}
+
+// GetCachedRoot returns the root metadata of the notary repository of ref
+// cached in the trust directory of config, as last downloaded and verified by
+// notary.
+func GetCachedRoot(ref name.Reference, repoInfo *name.Registry, config *Config) (*data.SignedRoot, error) {
+	cache, gun, err := metadataCache(ref, repoInfo, config)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := cache.GetSized(data.CanonicalRootRole.String(), storage.NoSizeLimit)
+	if err != nil {
+		return nil, err
+	}
+	var s data.Signed
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, errors.Wrapf(err, "invalid root metadata of %s", gun)
+	}
+	root, err := data.RootFromSigned(&s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid root metadata of %s", gun)
+	}
+	return root, nil
+}
+
+// GetCacheAge returns how long ago the TUF metadata of the notary repository
+// of ref cached in the trust directory of config was last fetched from the
+// notary server. Notary writes the timestamp to the cache on every successful
+// update, so its modification time is the time of the last fetch. An error
+// satisfying os.IsNotExist is returned when nothing is cached.
+func GetCacheAge(ref name.Reference, repoInfo *name.Registry, config *Config) (time.Duration, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return 0, err
+	}
+	gun := notaryGUN(ref.Context(), server)
+	info, err := os.Stat(filepath.Join(metadataDirectory(config, gun), data.CanonicalTimestampRole.String()+".json"))
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(info.ModTime()), nil
+}
+
+// ChangelistDirectory returns the directory in the trust directory of config
+// holding the changes staged for the notary repository of ref that have not
+// been published yet, one file per change. Unlike the cached metadata, it is
+// never moved to the cache directory.
+func ChangelistDirectory(ref name.Reference, repoInfo *name.Registry, config *Config) (string, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return "", err
+	}
+	return changelistDirectory(getTrustDirectory(config.RootPath), notaryGUN(ref.Context(), server)), nil
+}
+
+// metadataCache returns the store of the TUF metadata of the notary
+// repository of ref cached in the trust directory of config, and its GUN.
+func metadataCache(ref name.Reference, repoInfo *name.Registry, config *Config) (*storage.FilesystemStore, string, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, "", err
+	}
+	gun := notaryGUN(ref.Context(), server)
+	cache, err := storage.NewFileStore(metadataDirectory(config, gun), "json")
+	if err != nil {
+		return nil, "", err
+	}
+	return cache, gun, nil
+}
+
+// PruneCache removes the TUF metadata cached in the trust directory of
+// configDir, or of the default config directory when it is empty, for every
+// notary repository whose cached timestamp has expired and whose cache was
+// last written more than olderThan ago. Signing keys and pending changelists
+// are left alone. It returns the number of repositories whose cache was
+// removed. A later use of a pruned repository trusts the root metadata it
+// downloads afresh, as on first use.
+func PruneCache(configDir string, olderThan time.Duration) (int, error) {
+	tufDir := filepath.Join(getTrustDirectory(configDirectory(configDir)), "tuf")
+	now := time.Now()
+	var stale []string
+	err := filepath.Walk(tufDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == tufDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() || info.Name() != "metadata" {
+			return nil
+		}
+		if isStaleCache(path, now, olderThan) {
+			stale = append(stale, path)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, dir := range stale {
+		if err := os.RemoveAll(dir); err != nil {
+			return i, err
+		}
+		// drop the directory of the repository too if nothing else is left
+		gunDir := filepath.Dir(dir)
+		os.Remove(filepath.Join(gunDir, "changelist"))
+		os.Remove(gunDir)
+	}
+	return len(stale), nil
+}
+
+// isStaleCache reports whether the metadata cache dir holds an expired
+// timestamp and has not been written to within olderThan of now.
+func isStaleCache(dir string, now time.Time, olderThan time.Duration) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if f.ModTime().After(now.Add(-olderThan)) {
+			return false
+		}
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, data.CanonicalTimestampRole.String()+".json"))
+	if err != nil {
+		return false
+	}
+	expires, err := metadataExpiry(raw)
+	return err == nil && expires.Before(now)
+}
+
+// metadataDirectory returns the directory the TUF metadata of the notary
+// repository gun is cached in: under the cache directory of config if it has
+// one, otherwise under its trust directory.
+func metadataDirectory(config *Config, gun string) string {
+	if config.CacheDir != "" {
+		return filepath.Join(config.CacheDir, filepath.FromSlash(gun), "metadata")
+	}
+	return filepath.Join(getTrustDirectory(config.RootPath), "tuf", filepath.FromSlash(gun), "metadata")
+}
+
+func changelistDirectory(baseDir string, gun string) string {
+	return filepath.Join(baseDir, "tuf", filepath.FromSlash(gun), "changelist")
+}
+
+// metadataExpiry returns the expiry time of the signed TUF metadata raw.
+func metadataExpiry(raw []byte) (time.Time, error) {
+	common, err := metadataCommon(raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return common.Expires, nil
+}
+
+// metadataCommon returns the fields common to all roles of the signed TUF
+// metadata raw.
+func metadataCommon(raw []byte) (*data.SignedCommon, error) {
+	var s data.Signed
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.Signed == nil {
+		return nil, errors.New("missing signed section")
+	}
+	var common data.SignedCommon
+	if err := json.Unmarshal(*s.Signed, &common); err != nil {
+		return nil, err
+	}
+	return &common, nil
+}
@@ -0,0 +1,138 @@
+package trust
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/theupdateframework/notary/tuf/data"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestGetMetadataExpiries(t *testing.T) {
+	root, err := ioutil.TempDir("", "trust")
+	assert.NilError(t, err)
+	defer os.RemoveAll(root)
+
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	registry := ref.Context().Registry
+	config := &Config{RootPath: root}
+
+	// nothing cached yet
+	expiries, err := GetMetadataExpiries(ref, &registry, config)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(expiries, 0))
+
+	dir := filepath.Join(root, "trust", "tuf", "gcr.io", "project", "image", "metadata")
+	assert.NilError(t, os.MkdirAll(dir, 0700))
+	timestamp := `{"signed":{"_type":"Timestamp","expires":"2030-01-02T03:04:05Z","version":3},"signatures":[]}`
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "timestamp.json"), []byte(timestamp), 0600))
+
+	expiries, err = GetMetadataExpiries(ref, &registry, config)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(expiries, 1))
+	assert.Check(t, expiries[data.CanonicalTimestampRole].Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "snapshot.json"), []byte(`{"signed":`), 0600))
+	_, err = GetMetadataExpiries(ref, &registry, config)
+	assert.Check(t, is.ErrorContains(err, "invalid snapshot metadata"))
+}
+
+func TestGetMetadataVersions(t *testing.T) {
+	root, err := ioutil.TempDir("", "trust")
+	assert.NilError(t, err)
+	defer os.RemoveAll(root)
+
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	registry := ref.Context().Registry
+	config := &Config{RootPath: root}
+
+	dir := filepath.Join(root, "trust", "tuf", "gcr.io", "project", "image", "metadata")
+	assert.NilError(t, os.MkdirAll(dir, 0700))
+	timestamp := `{"signed":{"_type":"Timestamp","expires":"2030-01-02T03:04:05Z","version":3},"signatures":[]}`
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "timestamp.json"), []byte(timestamp), 0600))
+	targets := `{"signed":{"_type":"Targets","expires":"2030-01-02T03:04:05Z","version":7},"signatures":[]}`
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "targets.json"), []byte(targets), 0600))
+
+	versions, err := GetMetadataVersions(ref, &registry, config)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(versions, map[data.RoleName]int{
+		data.CanonicalTimestampRole: 3,
+		data.CanonicalTargetsRole:   7,
+	}))
+}
+
+func TestPruneCache(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "trust")
+	assert.NilError(t, err)
+	defer os.RemoveAll(configDir)
+
+	tufDir := filepath.Join(configDir, "trust", "tuf")
+	expired := `{"signed":{"_type":"Timestamp","expires":"2020-01-02T03:04:05Z","version":3},"signatures":[]}`
+	valid := `{"signed":{"_type":"Timestamp","expires":"2999-01-02T03:04:05Z","version":3},"signatures":[]}`
+	old := time.Now().Add(-48 * time.Hour)
+	cache := func(gun string, timestamp string, modTime time.Time) string {
+		dir := filepath.Join(tufDir, filepath.FromSlash(gun), "metadata")
+		assert.NilError(t, os.MkdirAll(dir, 0700))
+		assert.NilError(t, os.MkdirAll(filepath.Join(tufDir, filepath.FromSlash(gun), "changelist"), 0700))
+		file := filepath.Join(dir, "timestamp.json")
+		assert.NilError(t, ioutil.WriteFile(file, []byte(timestamp), 0600))
+		assert.NilError(t, os.Chtimes(file, modTime, modTime))
+		return dir
+	}
+	gone := cache("gcr.io/project/gone", expired, old)
+	recent := cache("gcr.io/project/recent", expired, time.Now())
+	live := cache("gcr.io/project/live", valid, old)
+	key := filepath.Join(configDir, "trust", "private", "root.key")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(key), 0700))
+	assert.NilError(t, ioutil.WriteFile(key, []byte("key"), 0600))
+
+	removed, err := PruneCache(configDir, 24*time.Hour)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(removed, 1))
+	for dir, kept := range map[string]bool{
+		filepath.Dir(gone): false,
+		recent:             true,
+		live:               true,
+		key:                true,
+	} {
+		_, err := os.Stat(dir)
+		assert.Check(t, is.Equal(err == nil, kept), dir)
+	}
+
+	// nothing cached at all
+	removed, err = PruneCache(filepath.Join(configDir, "missing"), 24*time.Hour)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(removed, 0))
+}
+
+func TestGetCacheAge(t *testing.T) {
+	root, err := ioutil.TempDir("", "trust")
+	assert.NilError(t, err)
+	defer os.RemoveAll(root)
+
+	ref, err := name.ParseReference("gcr.io/project/image:latest")
+	assert.NilError(t, err)
+	registry := ref.Context().Registry
+	config := &Config{RootPath: root}
+
+	_, err = GetCacheAge(ref, &registry, config)
+	assert.Check(t, os.IsNotExist(err), "unexpected error: %v", err)
+
+	dir := filepath.Join(root, "trust", "tuf", "gcr.io", "project", "image", "metadata")
+	assert.NilError(t, os.MkdirAll(dir, 0700))
+	timestamp := filepath.Join(dir, "timestamp.json")
+	assert.NilError(t, ioutil.WriteFile(timestamp, []byte("{}"), 0600))
+	fetched := time.Now().Add(-time.Hour)
+	assert.NilError(t, os.Chtimes(timestamp, fetched, fetched))
+
+	age, err := GetCacheAge(ref, &registry, config)
+	assert.NilError(t, err)
+	assert.Check(t, age >= time.Hour && age < 2*time.Hour, "unexpected age %s", age)
+}
@@ -0,0 +1,91 @@
+package trust
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how notary server calls are retried on transient
+// failures. The zero value disables retries.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a failed call is retried.
+	MaxRetries int
+	// Backoff is the delay before the first retry, doubled after every
+	// further attempt.
+	Backoff time.Duration
+}
+
+// retryTransport retries idempotent notary calls, that is metadata and key
+// downloads and metadata publishes, when the server answers 502, 503 or 504
+// or the connection is reset. Other calls, such as asking the server to
+// rotate a key, are sent once.
+type retryTransport struct {
+	policy RetryPolicy
+	log    Logger
+	base   http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.MaxRetries <= 0 || !isRetriable(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	backoff := t.policy.Backoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.policy.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		t.log.Warnf("notary request %s %s failed, retry %d/%d in %s", req.Method, req.URL.Path, attempt+1, t.policy.MaxRetries, backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// isRetriable reports whether req can safely be sent more than once.
+func isRetriable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		// publishing metadata is a POST to the bare TUF endpoint, and the
+		// server rejects it if the versions it carries were already accepted
+		return strings.HasSuffix(req.URL.Path, "/_trust/tuf") && (req.Body == nil || req.GetBody != nil)
+	}
+	return false
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package trust
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tr := &retryTransport{policy: RetryPolicy{MaxRetries: 3}, log: DefaultLogger(), base: http.DefaultTransport}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v2/gun/_trust/tuf/root.json", nil)
+	resp, err := tr.RoundTrip(req)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(resp.StatusCode, http.StatusOK))
+	assert.Check(t, is.Equal(calls, 3))
+
+	// asking the server to rotate a key is not retried
+	calls = 0
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/v2/gun/_trust/tuf/snapshot.key", nil)
+	resp, err = tr.RoundTrip(req)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(resp.StatusCode, http.StatusServiceUnavailable))
+	assert.Check(t, is.Equal(calls, 1))
+}
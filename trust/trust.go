@@ -1,6 +1,7 @@
 package trust
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -23,6 +24,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/theupdateframework/notary"
 	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/client/changelist"
 	"github.com/theupdateframework/notary/passphrase"
 	"github.com/theupdateframework/notary/storage"
 	"github.com/theupdateframework/notary/trustmanager"
@@ -64,11 +66,217 @@ func Server(serverUrl string, repoInfo *name.Registry) (string, error) {
 // information needed to operate on a notary repository.
 // It creates an HTTP transport providing authentication support.
 func GetNotaryRepository(ref name.Reference, auth authn.Authenticator, repoInfo *name.Registry, config *Config) (client.Repository, error) {
+	return GetNotaryRepositoryContext(context.Background(), ref, auth, repoInfo, config)
+}
+
+// GetNotaryRepositoryContext is like GetNotaryRepository, but every HTTP
+// request issued by the returned repository, including the token handshake,
+// is bound to ctx so that cancelling it aborts in-flight notary calls.
+func GetNotaryRepositoryContext(ctx context.Context, ref name.Reference, auth authn.Authenticator, repoInfo *name.Registry, config *Config) (client.Repository, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	gun := notaryGUN(ref.Context(), server)
+	rt, err := notaryRoundTripper(ctx, auth, repoInfo, server, gun, config.scopes(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return newNotaryRepository(getTrustDirectory(config.RootPath), data.GUN(gun), server, rt, config)
+}
+
+// notaryRoundTripper returns the authenticated transport of calls to the
+// notary repository gun on server, requesting a token for actions.
+func notaryRoundTripper(ctx context.Context, auth authn.Authenticator, repoInfo *name.Registry, server string, gun string, actions string, config *Config) (http.RoundTripper, error) {
+	log := config.logger()
+	base, err := baseTransport(log, repoInfo, server, config)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{fmt.Sprintf("repository:%s:%s", gun, actions)}
+	notaryURL, _ := url.Parse(server)
+	reg, _ := name.NewRegistry(notaryURL.Host)
+	if notaryURL.Host == NotaryServerHostname {
+		log.Infof("Overrode registry (%s), GUN (%s), and scopes (%s) for default Notary DCT", reg.Name(), gun, scopes)
+	}
+
+	tr, err := transport.NewWithContext(ctx, reg, auth, base, scopes)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		// the handshake flattens the errors of its pings into a message, so
+		// that callers could not tell a cancellation apart otherwise
+		return nil, errors.Wrap(ctxErr, "error authenticating to the notary server")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &contextTransport{ctx: ctx, base: &retryTransport{policy: config.Retry, log: log, base: tr}}, nil
+}
+
+// PingNotary checks that the notary server of ref can be reached and accepts
+// auth for pulling the trust data of ref, by authenticating and requesting the
+// timestamp metadata of its notary repository. Nothing is cached or written.
+// Connection failures are returned as *url.Error.
+// A repository without trust data is not an error. Failed responses are
+// returned as *transport.Error.
+func PingNotary(ctx context.Context, ref name.Reference, auth authn.Authenticator, repoInfo *name.Registry, config *Config) error {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return err
+	}
+	// reach the server unauthenticated first, as the token handshake does not
+	// tell network failures apart
+	base, err := baseTransport(config.logger(), repoInfo, server, config)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, server+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: base}).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	gun := notaryGUN(ref.Context(), server)
+	rt, err := notaryRoundTripper(ctx, auth, repoInfo, server, gun, strings.Join(ActionsPullOnly, ","), config)
+	if err != nil {
+		return err
+	}
+	req, err = http.NewRequest(http.MethodGet, server+"/v2/"+gun+"/_trust/tuf/"+data.CanonicalTimestampRole.String()+".json", nil)
+	if err != nil {
+		return err
+	}
+	resp, err = (&http.Client{Transport: rt}).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return transport.CheckError(resp, http.StatusOK, http.StatusNotFound)
+}
+
+// DeleteTrustData removes the cached TUF metadata and the changelist of the
+// notary repository of ref from the trust directory of config, and its trust
+// data from the notary server too when deleteRemote is set, which requires
+// admin access to the repository. Signing keys are left alone. It reports
+// whether there was any trust data to delete; missing trust data is not an
+// error.
+func DeleteTrustData(ctx context.Context, ref name.Reference, auth authn.Authenticator, repoInfo *name.Registry, config *Config, deleteRemote bool) (bool, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return false, err
+	}
+	gun := notaryGUN(ref.Context(), server)
+	trustDir := getTrustDirectory(config.RootPath)
+	_, err = os.Stat(filepath.Join(trustDir, "tuf", filepath.FromSlash(gun)))
+	deleted := err == nil
+	if config.CacheDir != "" {
+		// notary only knows about the metadata cached in the trust directory
+		cacheDir := filepath.Dir(metadataDirectory(config, gun))
+		if _, err := os.Stat(cacheDir); err == nil {
+			deleted = true
+		}
+		if err := os.RemoveAll(cacheDir); err != nil {
+			return false, err
+		}
+	}
+
+	var rt http.RoundTripper
+	if deleteRemote {
+		// deleting a repository requires the wildcard scope
+		rt, err = notaryRoundTripper(ctx, auth, repoInfo, server, gun, "*", config)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = client.DeleteTrustData(trustDir, data.GUN(gun), server, rt, deleteRemote)
+	switch err.(type) {
+	case nil:
+		return deleted || deleteRemote, nil
+	case storage.ErrMetaNotFound:
+		// the notary server has no trust data for the repository
+		return deleted, nil
+	default:
+		return deleted, err
+	}
+}
+
+// GetOfflineNotaryRepository returns a NotaryRepository which only reads the
+// trust data cached in the trust directory of config and never contacts the
+// notary server. The cached metadata is still verified, including its
+// signatures and expiry.
+func GetOfflineNotaryRepository(ref name.Reference, repoInfo *name.Registry, config *Config) (client.Repository, error) {
 	server, err := Server(config.ServerUrl, repoInfo)
 	if err != nil {
 		return nil, err
 	}
 
+	// a nil round tripper makes notary use an offline remote store
+	return newNotaryRepository(getTrustDirectory(config.RootPath), data.GUN(notaryGUN(ref.Context(), server)), server, nil, config)
+}
+
+// GetUncachedNotaryRepository is like GetNotaryRepositoryContext, but the
+// returned repository keeps the metadata it downloads in memory and leaves the
+// cache of the trust directory untouched. The cached root, if any, is still
+// the trust anchor the downloaded metadata is verified against.
+func GetUncachedNotaryRepository(ctx context.Context, ref name.Reference, auth authn.Authenticator, repoInfo *name.Registry, config *Config) (client.Repository, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, err
+	}
+	gun := notaryGUN(ref.Context(), server)
+	rt, err := notaryRoundTripper(ctx, auth, repoInfo, server, gun, config.scopes(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := make(map[data.RoleName][]byte)
+	cached, _, err := metadataCache(ref, repoInfo, config)
+	if err != nil {
+		return nil, err
+	}
+	if root, err := cached.GetSized(data.CanonicalRootRole.String(), storage.NoSizeLimit); err == nil {
+		seed[data.CanonicalRootRole] = root
+	}
+	if config.TrustAnchor != nil {
+		seed[data.CanonicalRootRole] = anchoredRoot(seed[data.CanonicalRootRole], config.TrustAnchor)
+	}
+	return buildNotaryRepository(getTrustDirectory(config.RootPath), data.GUN(gun), server, rt, config, storage.NewMemoryStore(seed), changelist.NewMemChangelist())
+}
+
+// GUN returns the notary GUN naming the trust data of the repository of ref on
+// the trust server of config, both on the server and in the trust directory.
+func GUN(ref name.Reference, repoInfo *name.Registry, config *Config) (data.GUN, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return "", err
+	}
+	return data.GUN(notaryGUN(ref.Context(), server)), nil
+}
+
+// notaryGUN returns the notary GUN of repo on the given trust server. The
+// default Notary DCT server names repositories after the docker.io alias
+// rather than the registry.
+func notaryGUN(repo name.Repository, server string) string {
+	notaryURL, _ := url.Parse(server)
+	if notaryURL != nil && notaryURL.Host == NotaryServerHostname {
+		return fmt.Sprintf("%s/%s", NotaryServerIndexAlias, repo.RepositoryStr())
+	}
+	return repo.String()
+}
+
+// baseTransport returns the transport notary server calls are sent through
+// before authentication is added: config.Transport if it is set, otherwise a
+// transport trusting the certificates of the tls directory of server.
+func baseTransport(log Logger, repoInfo *name.Registry, server string, config *Config) (http.RoundTripper, error) {
+	if config.Transport != nil {
+		return config.Transport, nil
+	}
+
 	var cfg = tlsconfig.ClientDefault()
 	if repoInfo.Scheme() == "https" {
 		cfg.InsecureSkipVerify = true
@@ -81,11 +289,21 @@ func GetNotaryRepository(ref name.Reference, auth authn.Authenticator, repoInfo
 	}
 	log.Infof("reading certificate directory: %s \n", certDir)
 
-	if err := readCertsDirectory(cfg, certDir); err != nil {
+	if err := readCertsDirectory(log, cfg, certDir); err != nil {
 		return nil, err
 	}
+	if config.RootCAs != nil {
+		cfg.RootCAs = config.RootCAs
+		cfg.InsecureSkipVerify = false
+	}
+	if config.InsecureSkipVerify != nil {
+		cfg.InsecureSkipVerify = *config.InsecureSkipVerify
+		if cfg.InsecureSkipVerify {
+			log.Warnf("TLS certificate verification of notary server %s is disabled", server)
+		}
+	}
 
-	base := &http.Transport{
+	return &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		Dial: (&net.Dialer{
 			Timeout:   30 * time.Second,
@@ -95,31 +313,38 @@ func GetNotaryRepository(ref name.Reference, auth authn.Authenticator, repoInfo
 		TLSHandshakeTimeout: 10 * time.Second,
 		TLSClientConfig:     cfg,
 		DisableKeepAlives:   true,
-	}
+	}, nil
+}
 
-	repo := ref.Context()
-	gun := repo.String()
-	scopes := []string{fmt.Sprintf("repository:%s:%s", gun, config.Scopes)}
-	notaryURL, _ := url.Parse(server)
-	reg, _ := name.NewRegistry(notaryURL.Host)
-	if notaryURL.Host == NotaryServerHostname {
-		gun = fmt.Sprintf("%s/%s", NotaryServerIndexAlias, repo.RepositoryStr())
-		scopes = []string{fmt.Sprintf("repository:%s:%s", gun, config.Scopes)}
-		log.Infof("Overrode registry (%s), GUN (%s), and scopes (%s) for default Notary DCT", reg.Name(), gun, scopes)
+// SharedTransport returns a transport to the notary server of repoInfo set up
+// like the one notary repositories of config build for themselves, but
+// keeping connections alive, so that the repositories of many GUNs on that
+// server can share its connection pool by setting it as their Transport.
+// config.Transport is returned as is if it is set.
+func SharedTransport(config *Config, repoInfo *name.Registry) (http.RoundTripper, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, err
 	}
-
-	tr, err := transport.New(reg, auth, base, scopes)
+	rt, err := baseTransport(config.logger(), repoInfo, server, config)
 	if err != nil {
 		return nil, err
 	}
+	if t, ok := rt.(*http.Transport); ok && config.Transport == nil {
+		t.DisableKeepAlives = false
+	}
+	return rt, nil
+}
+
+// contextTransport binds every request it sends to ctx. The notary client
+// does not accept a context, so this is how cancellation reaches it.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
 
-	return client.NewFileCachedRepository(
-		getTrustDirectory(config.RootPath),
-		data.GUN(gun),
-		server,
-		tr,
-		GetPassphraseRetriever(os.Stdin, os.Stderr, config.RootPassphrase, config.RepositoryPassphrase),
-		trustpinning.TrustPinConfig{})
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
 }
 
 // GetSignableRoles returns a list of roles for which we have valid signing
@@ -201,7 +426,7 @@ func GetPassphraseRetriever(in io.Reader, out io.Writer, rootPassphrase string,
 
 // getTrustDirectory returns the base trust directory name
 func getTrustDirectory(configDir string) string {
-	return filepath.Join(configDir, "trust")
+	return filepath.Join(configDirectory(configDir), "trust")
 }
 
 // certificateDirectory returns the directory containing
@@ -213,13 +438,13 @@ func certificateDirectory(configDir string, server string) (string, error) {
 		return "", err
 	}
 
-	return filepath.Join(configDir, "tls", u.Host), nil
+	return filepath.Join(configDirectory(configDir), "tls", u.Host), nil
 }
 
 // readCertsDirectory reads the directory for TLS certificates
 // including roots and certificate pairs and updates the
 // provided TLS configuration.
-func readCertsDirectory(tlsConfig *tls.Config, directory string) error {
+func readCertsDirectory(log Logger, tlsConfig *tls.Config, directory string) error {
 	fs, err := ioutil.ReadDir(directory)
 	if err != nil && !os.IsNotExist(err) {
 		return err
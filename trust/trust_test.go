@@ -1,7 +1,10 @@
 package trust
 
 import (
+	"crypto/x509"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -69,4 +72,67 @@ func TestGetSignableRolesError(t *testing.T) {
 	target := client.Target{}
 	_, err = GetSignableRoles(notaryRepo, &target)
 	assert.Error(t, err, "client is offline")
-}
\ No newline at end of file
+}
+
+func TestNotaryGUN(t *testing.T) {
+	ref, _ := name.ParseReference("gcr.io/foo/image:latest", name.WeakValidation)
+	assert.Check(t, is.Equal(notaryGUN(ref.Context(), "https://notary.example.com"), "gcr.io/foo/image"))
+
+	ref, _ = name.ParseReference("alpine:latest", name.WeakValidation)
+	assert.Check(t, is.Equal(notaryGUN(ref.Context(), NotaryServer), "docker.io/library/alpine"))
+}
+
+func TestBaseTransportTLSVerification(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	configDir, err := ioutil.TempDir("", "trust")
+	assert.NilError(t, err)
+	defer os.RemoveAll(configDir)
+	registry, err := name.NewRegistry("gcr.io")
+	assert.NilError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	verify, skip := false, true
+
+	for _, c := range []struct {
+		name    string
+		config  Config
+		trusted bool
+	}{
+		{"https registry default", Config{}, true},
+		{"verified", Config{InsecureSkipVerify: &verify}, false},
+		{"root CAs", Config{RootCAs: pool}, true},
+		{"root CAs of another server", Config{RootCAs: x509.NewCertPool()}, false},
+		{"insecure", Config{RootCAs: x509.NewCertPool(), InsecureSkipVerify: &skip}, true},
+	} {
+		c.config.RootPath = configDir
+		rt, err := baseTransport(DefaultLogger(), &registry, s.URL, &c.config)
+		assert.NilError(t, err)
+		req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+		assert.NilError(t, err)
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		assert.Check(t, is.Equal(err == nil, c.trusted), "%s: %v", c.name, err)
+	}
+}
+
+func TestGUN(t *testing.T) {
+	ref, err := name.ParseReference("gcr.io/foo/image:latest")
+	assert.NilError(t, err)
+	registry := ref.Context().Registry
+	gun, err := GUN(ref, &registry, &Config{ServerUrl: "https://notary.example.com"})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(gun, data.GUN("gcr.io/foo/image")))
+
+	ref, err = name.ParseReference("alpine:latest")
+	assert.NilError(t, err)
+	registry = ref.Context().Registry
+	gun, err = GUN(ref, &registry, &Config{})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(gun, data.GUN("docker.io/library/alpine")))
+
+	_, err = GUN(ref, &registry, &Config{ServerUrl: "http://notary.example.com"})
+	assert.Check(t, is.ErrorContains(err, "valid https URL required"))
+}
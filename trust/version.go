@@ -0,0 +1,243 @@
+package trust
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/trustpinning"
+	"github.com/theupdateframework/notary/tuf"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// GetTargetAtVersion returns the metadata that signed targetName as of
+// version targetsVersion of the top level targets metadata of the notary
+// repository of ref, and the root it was verified against, for replaying what
+// was trusted in the past. Like GetTargetByName with the releases delegation
+// role and then the top level targets role, the releases delegation takes
+// precedence; it is read as listed by the last snapshot listing that targets
+// version.
+//
+// Notary servers keep every version of the metadata they were sent and serve
+// it by version and by checksum; a storage.ErrMetaNotFound is returned when
+// the server does not have what is needed, and client.ErrRepoNotInitialized
+// when the repository has no trust data at all. The metadata is verified
+// against the cached root, or the trust anchor of config, without updating
+// the cache and without checking its expiry, as past versions have usually
+// expired. Metadata signed by keys the root no longer lists fails to verify.
+func GetTargetAtVersion(ctx context.Context, ref name.Reference, auth authn.Authenticator, repoInfo *name.Registry, config *Config, targetName string, targetsVersion int) (*client.TargetSignedStruct, *data.SignedRoot, error) {
+	server, err := Server(config.ServerUrl, repoInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	gun := notaryGUN(ref.Context(), server)
+	rt, err := notaryRoundTripper(ctx, auth, repoInfo, server, gun, config.scopes(), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	remote, err := storage.NewHTTPStore(server+"/v2/"+gun+"/_trust/tuf/", "", "json", "key", rt)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache, _, err := metadataCache(ref, repoInfo, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := trustedRoot(cache, remote, config)
+	if _, ok := err.(storage.ErrMetaNotFound); ok {
+		// tell a missing version apart from a repository never signed
+		return nil, nil, client.ErrRepoNotInitialized{}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return targetAtVersion(data.GUN(gun), root, remote, targetName, targetsVersion)
+}
+
+// targetAtVersion resolves targetName as of version of the targets metadata
+// of store, verified against rawRoot.
+func targetAtVersion(gun data.GUN, rawRoot []byte, store metadataGetter, targetName string, version int) (*client.TargetSignedStruct, *data.SignedRoot, error) {
+	builder := tuf.NewRepoBuilder(gun, nil, trustpinning.TrustPinConfig{})
+	if err := builder.Load(data.CanonicalRootRole, rawRoot, 1, true); err != nil {
+		return nil, nil, err
+	}
+	repo, _, err := builder.Finish()
+	if err != nil {
+		return nil, nil, err
+	}
+	root := repo.Root
+	targetsRole, err := root.BuildBaseRole(data.CanonicalTargetsRole)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawTargets, err := store.GetSized(strconv.Itoa(version)+"."+data.CanonicalTargetsRole.String(), notary.MaxDownloadSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := verifySigned(rawTargets, targetsRole)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, err := data.TargetsFromSigned(s, data.CanonicalTargetsRole)
+	if err != nil {
+		return nil, nil, err
+	}
+	if targets.Signed.Version != version {
+		return nil, nil, errors.Errorf("targets metadata of %s is version %d, not %d", gun, targets.Signed.Version, version)
+	}
+
+	if releasesRole, err := targets.BuildDelegationRole(ReleasesRole); err == nil && releasesRole.CheckPaths(targetName) {
+		releases, err := releasesAtTargets(root, store, rawTargets, version, releasesRole)
+		if err != nil {
+			return nil, nil, err
+		}
+		if releases != nil {
+			if meta, ok := releases.Signed.Targets[targetName]; ok {
+				return targetSignedStruct(releasesRole, targetName, meta, releases.Signatures), root, nil
+			}
+		}
+	}
+	if meta, ok := targets.Signed.Targets[targetName]; ok {
+		role := data.DelegationRole{BaseRole: targetsRole, Paths: []string{""}}
+		return targetSignedStruct(role, targetName, meta, targets.Signatures), root, nil
+	}
+	return nil, nil, client.ErrNoSuchTarget(targetName)
+}
+
+// releasesAtTargets returns the releases delegation, whose role targets
+// version version, rawTargets, defines, as listed by the last snapshot
+// listing rawTargets, or nil if that snapshot lists none.
+func releasesAtTargets(root *data.SignedRoot, store metadataGetter, rawTargets []byte, version int, role data.DelegationRole) (*data.SignedTargets, error) {
+	snapshot, err := lastSnapshotOf(root, store, rawTargets, version)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := snapshot.Signed.Meta[ReleasesRole.String()]
+	if !ok {
+		return nil, nil
+	}
+	raw, err := store.GetSized(utils.ConsistentName(ReleasesRole.String(), meta.Hashes[notary.SHA256]), meta.Length)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.CheckHashes(raw, ReleasesRole.String(), meta.Hashes); err != nil {
+		return nil, err
+	}
+	s, err := verifySigned(raw, role.BaseRole)
+	if err != nil {
+		return nil, err
+	}
+	return data.TargetsFromSigned(s, ReleasesRole)
+}
+
+// lastSnapshotOf returns the last snapshot listing rawTargets, version of
+// the targets metadata. Snapshots only list the checksum of the targets
+// metadata, so the snapshot versions up to the current one are bisected by
+// the version of the targets metadata they list, read unverified for that
+// purpose only: the snapshot returned is verified and lists rawTargets.
+func lastSnapshotOf(root *data.SignedRoot, store metadataGetter, rawTargets []byte, version int) (*data.SignedSnapshot, error) {
+	role, err := root.BuildBaseRole(data.CanonicalSnapshotRole)
+	if err != nil {
+		return nil, err
+	}
+	load := func(metaName string) (*data.SignedSnapshot, error) {
+		raw, err := store.GetSized(metaName, notary.MaxDownloadSize)
+		if err != nil {
+			return nil, err
+		}
+		s, err := verifySigned(raw, role)
+		if err != nil {
+			return nil, err
+		}
+		return data.SnapshotFromSigned(s)
+	}
+	// listed returns the version of the targets metadata snapshot lists, and
+	// whether it is rawTargets
+	listed := func(snapshot *data.SignedSnapshot) (int, bool, error) {
+		meta, ok := snapshot.Signed.Meta[data.CanonicalTargetsRole.String()]
+		if !ok {
+			return 0, false, nil
+		}
+		if data.CheckHashes(rawTargets, data.CanonicalTargetsRole.String(), meta.Hashes) == nil {
+			return version, true, nil
+		}
+		raw, err := store.GetSized(utils.ConsistentName(data.CanonicalTargetsRole.String(), meta.Hashes[notary.SHA256]), meta.Length)
+		if err != nil {
+			return 0, false, err
+		}
+		var s struct {
+			Signed struct {
+				Version int `json:"version"`
+			} `json:"signed"`
+		}
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, false, err
+		}
+		return s.Signed.Version, false, nil
+	}
+
+	current, err := load(data.CanonicalSnapshotRole.String())
+	if err != nil {
+		return nil, err
+	}
+	var found *data.SignedSnapshot
+	for lo, hi := 1, current.Signed.Version; lo <= hi; {
+		mid := lo + (hi-lo)/2
+		snapshot := current
+		if mid != current.Signed.Version {
+			if snapshot, err = load(strconv.Itoa(mid) + "." + data.CanonicalSnapshotRole.String()); err != nil {
+				return nil, err
+			}
+		}
+		v, matches, err := listed(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if v > version {
+			hi = mid - 1
+			continue
+		}
+		found = nil
+		if matches {
+			found = snapshot
+		}
+		lo = mid + 1
+	}
+	if found == nil {
+		return nil, storage.ErrMetaNotFound{Resource: fmt.Sprintf("snapshot listing version %d of targets", version)}
+	}
+	return found, nil
+}
+
+// verifySigned parses raw metadata and verifies its signatures by role,
+// marking the valid ones.
+func verifySigned(raw []byte, role data.BaseRole) (*data.Signed, error) {
+	var s data.Signed
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s metadata", role.Name)
+	}
+	if err := signed.VerifySignatures(&s, role); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// targetSignedStruct returns meta of targetName as signed with signatures by
+// role.
+func targetSignedStruct(role data.DelegationRole, targetName string, meta data.FileMeta, signatures []data.Signature) *client.TargetSignedStruct {
+	return &client.TargetSignedStruct{
+		Role:       role,
+		Target:     client.Target{Name: targetName, Hashes: meta.Hashes, Length: meta.Length, Custom: meta.Custom},
+		Signatures: signatures,
+	}
+}
@@ -0,0 +1,137 @@
+package trust
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+
+	canonicaljson "github.com/docker/go/canonical/json"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/utils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// historicMetadata returns the root metadata of a repository with a releases
+// delegation and a store of its metadata history as served by notary
+// servers, by version and by checksum:
+//   - snapshot 1 lists targets 1, with latest, and releases 1, with v1
+//   - snapshot 2 lists releases 2, which adds v2
+//   - snapshot 3 lists targets 2, which adds stable, and releases 3, which
+//     drops v1
+func historicMetadata(t *testing.T) ([]byte, *storage.MemoryStore) {
+	repo, cs := newTestRepo(t, "gcr.io/project/image")
+	key, err := cs.Create(ReleasesRole, "gcr.io/project/image", data.ECDSAKey)
+	assert.NilError(t, err)
+	assert.NilError(t, repo.UpdateDelegationKeys(ReleasesRole, data.KeyList{key}, nil, 1))
+	assert.NilError(t, repo.UpdateDelegationPaths(ReleasesRole, []string{""}, nil, false))
+	files := func(names ...string) data.Files {
+		files := data.Files{}
+		for _, name := range names {
+			sum := sha256.Sum256([]byte(name))
+			files[name] = data.FileMeta{Length: int64(len(name)), Hashes: data.Hashes{"sha256": sum[:]}}
+		}
+		return files
+	}
+
+	expires := time.Now().Add(time.Hour)
+	store := storage.NewMemoryStore(nil)
+	set := func(role data.RoleName, s *data.Signed, versioned bool) {
+		raw, err := canonicaljson.Marshal(s)
+		assert.NilError(t, err)
+		sum := sha256.Sum256(raw)
+		assert.NilError(t, store.Set(utils.ConsistentName(role.String(), sum[:]), raw))
+		if versioned {
+			var common data.SignedCommon
+			assert.NilError(t, canonicaljson.Unmarshal(*s.Signed, &common))
+			assert.NilError(t, store.Set(strconv.Itoa(common.Version)+"."+role.String(), raw))
+		}
+		assert.NilError(t, store.Set(role.String(), raw))
+	}
+	publish := func(roles ...data.RoleName) {
+		for _, role := range roles {
+			s, err := repo.SignTargets(role, expires)
+			assert.NilError(t, err)
+			set(role, s, role == data.CanonicalTargetsRole)
+		}
+		s, err := repo.SignSnapshot(expires)
+		assert.NilError(t, err)
+		set(data.CanonicalSnapshotRole, s, true)
+	}
+
+	_, err = repo.AddTargets(data.CanonicalTargetsRole, files("latest"))
+	assert.NilError(t, err)
+	_, err = repo.AddTargets(ReleasesRole, files("v1"))
+	assert.NilError(t, err)
+	publish(data.CanonicalTargetsRole, ReleasesRole)
+
+	_, err = repo.AddTargets(ReleasesRole, files("v2"))
+	assert.NilError(t, err)
+	publish(ReleasesRole)
+
+	_, err = repo.AddTargets(data.CanonicalTargetsRole, files("stable"))
+	assert.NilError(t, err)
+	assert.NilError(t, repo.RemoveTargets(ReleasesRole, "v1"))
+	publish(data.CanonicalTargetsRole, ReleasesRole)
+
+	root, err := repo.SignRoot(expires, nil)
+	assert.NilError(t, err)
+	rawRoot, err := canonicaljson.Marshal(root)
+	assert.NilError(t, err)
+	return rawRoot, store
+}
+
+func TestTargetAtVersion(t *testing.T) {
+	root, store := historicMetadata(t)
+	for _, tc := range []struct {
+		name    string
+		version int
+		role    data.RoleName
+	}{
+		{name: "latest", version: 1, role: data.CanonicalTargetsRole},
+		{name: "v1", version: 1, role: ReleasesRole},
+		// the releases delegation as of the last snapshot of the version
+		{name: "v2", version: 1, role: ReleasesRole},
+		{name: "stable", version: 1},
+		{name: "v1", version: 2},
+		{name: "v2", version: 2, role: ReleasesRole},
+		{name: "stable", version: 2, role: data.CanonicalTargetsRole},
+	} {
+		t.Run(tc.name+"@"+strconv.Itoa(tc.version), func(t *testing.T) {
+			target, signedRoot, err := targetAtVersion("gcr.io/project/image", root, store, tc.name, tc.version)
+			if tc.role == "" {
+				_, ok := err.(client.ErrNoSuchTarget)
+				assert.Check(t, ok, "unexpected error: %v", err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Check(t, is.Equal(target.Role.Name, tc.role))
+			assert.Check(t, is.Equal(target.Target.Length, int64(len(tc.name))))
+			assert.Assert(t, is.Len(target.Signatures, 1))
+			assert.Check(t, target.Signatures[0].IsValid)
+			assert.Check(t, is.Equal(signedRoot.Signed.Version, 1))
+		})
+	}
+
+	_, _, err := targetAtVersion("gcr.io/project/image", root, store, "latest", 3)
+	_, ok := err.(storage.ErrMetaNotFound)
+	assert.Check(t, ok, "unexpected error: %v", err)
+
+	// metadata that is not signed by the keys of the root does not verify
+	other, _ := historicMetadata(t)
+	_, _, err = targetAtVersion("gcr.io/project/image", other, store, "latest", 1)
+	assert.Check(t, err != nil)
+}
+
+func TestTargetAtVersionChecksVersion(t *testing.T) {
+	root, store := historicMetadata(t)
+	raw, err := store.GetSized("1.targets", storage.NoSizeLimit)
+	assert.NilError(t, err)
+	assert.NilError(t, store.Set("2.targets", raw))
+
+	_, _, err = targetAtVersion("gcr.io/project/image", root, store, "latest", 2)
+	assert.Check(t, is.ErrorContains(err, "is version 1, not 2"))
+}